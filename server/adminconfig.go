@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Get Effective Config endpoint (admin) - reports the configuration the
+// server is running with, so operators can tell why a feature is on or off
+// without reading the process environment. Secrets are only reported as set
+// or not.
+func getEffectiveConfig(c *gin.Context) {
+	consul := consulConfig()
+	consulAuth := ""
+	if consul.HttpAuth != nil {
+		consulAuth = consul.HttpAuth.Password
+	}
+
+	c.JSON(http.StatusOK, sharewoodapi.EffectiveConfig{
+		Port:            listenPort(),
+		TLS:             cfg.TLSCertFile != "",
+		ConsulAddress:   consul.Address,
+		ConsulNamespace: consul.Namespace,
+		DevMode:         os.Getenv("DEV_MODE") == "true",
+		ReadOnly:        readOnly.Load(),
+		Secrets: map[string]string{
+			"jwtSecret":            redact(os.Getenv("JWT_SECRET")),
+			"consulToken":          redact(consul.Token),
+			"consulHttpAuth":       redact(consulAuth),
+			"criticalAlertWebhook": redact(cfg.CriticalAlertWebhook),
+		},
+		Settings: configSettings(cfg),
+	})
+}
+
+// redact returns RedactedValue for a set secret and "" for an unset one
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return sharewoodapi.RedactedValue
+}
+
+// configSettings lists the fields of a serverConfig by name, with durations
+// in their string form. Secret fields are left out; getEffectiveConfig
+// reports them among the secrets.
+func configSettings(config serverConfig) map[string]interface{} {
+	settings := make(map[string]interface{})
+	value := reflect.ValueOf(config)
+	for i := 0; i < value.NumField(); i++ {
+		if value.Type().Field(i).Tag.Get("config") == "secret" {
+			continue
+		}
+		field := value.Field(i)
+		if d, ok := field.Interface().(time.Duration); ok {
+			settings[value.Type().Field(i).Name] = d.String()
+		} else {
+			settings[value.Type().Field(i).Name] = field.Interface()
+		}
+	}
+	return settings
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestEffectiveConfigRedactsSecrets(t *testing.T) {
+	secrets := map[string]string{
+		"JWT_SECRET":             "jwt-secret-value",
+		"CONSUL_TOKEN":           "consul-token-value",
+		"CONSUL_HTTP_AUTH":       "registry:consul-password-value",
+		"CRITICAL_ALERT_WEBHOOK": "https://hooks.example.com/secret-path-value",
+	}
+	for key, value := range secrets {
+		t.Setenv(key, value)
+	}
+	t.Setenv("KV_INDEX", "true")
+	t.Setenv("MAX_BODY_BYTES", "2048")
+	t.Setenv("WRITE_TIMEOUT", "45s")
+	_, router := newTestRegistry(t)
+
+	resp := serve(router, http.MethodGet, "/api/v1/admin/config", nil, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", resp.Code, resp.Body)
+	}
+	for key, value := range secrets {
+		if strings.Contains(resp.Body.String(), strings.TrimPrefix(value, "registry:")) {
+			t.Errorf("%s appears in the output", key)
+		}
+	}
+
+	var config sharewoodapi.EffectiveConfig
+	if err := json.Unmarshal(resp.Body.Bytes(), &config); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	for _, name := range []string{"jwtSecret", "consulToken", "consulHttpAuth", "criticalAlertWebhook"} {
+		if config.Secrets[name] != sharewoodapi.RedactedValue {
+			t.Errorf("secret %s is %q, want %q", name, config.Secrets[name], sharewoodapi.RedactedValue)
+		}
+	}
+	if _, ok := config.Settings["CriticalAlertWebhook"]; ok {
+		t.Error("the alert webhook is listed among the settings")
+	}
+
+	// Settings are reported as resolved from the environment
+	want := map[string]interface{}{"KVIndex": true, "MaxBodyBytes": float64(2048), "WriteTimeout": "45s", "AgentTag": "ai-agent"}
+	for name, value := range want {
+		if config.Settings[name] != value {
+			t.Errorf("setting %s is %v, want %v", name, config.Settings[name], value)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Consul does not record when a TTL check was last updated, so the registry
+// remembers when it last applied a health update for each service. This is
+// per server instance and lost on restart.
+var (
+	healthUpdatesMu sync.Mutex
+	healthUpdates   = make(map[string]time.Time)
+)
+
+// recordHealthUpdate notes that the health of a service was just updated
+func recordHealthUpdate(serviceID string) {
+	healthUpdatesMu.Lock()
+	defer healthUpdatesMu.Unlock()
+	healthUpdates[serviceID] = time.Now().UTC()
+}
+
+// lastHealthUpdate returns when this instance last updated a service's
+// health, or nil if it has not
+func lastHealthUpdate(serviceID string) *time.Time {
+	healthUpdatesMu.Lock()
+	defer healthUpdatesMu.Unlock()
+	if t, ok := healthUpdates[serviceID]; ok {
+		return &t
+	}
+	return nil
+}
+
+// Agent Health endpoint - returns the status of an agent's TTL check together
+// with the output and notes Consul holds for it, to help diagnose a critical
+// agent. Each instance of a scaled agent has its own check, selected with the
+// instance query parameter.
+func getAgentHealth(c *gin.Context) {
+	name := c.Param("name")
+
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	checks, err := consulChecks(c.Request.Context())
+	if err != nil {
+		log.Printf("Error reading agent checks: %v", err)
+		respondConsulError(c, "Failed to read agent health", err)
+		return
+	}
+
+	check, ok := checks["service:"+service.ID]
+	if !ok {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Error:   "Health check not found",
+			Details: fmt.Sprintf("Agent '%s' has no TTL check", name),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, sharewoodapi.AgentHealth{
+		Name:        service.Service,
+		InstanceID:  service.Meta["instanceId"],
+		Status:      sharewoodapi.HealthStatus(check.Status),
+		Output:      check.Output,
+		Notes:       check.Notes,
+		LastUpdated: lastHealthUpdate(service.ID),
+	})
+}
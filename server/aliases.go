@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// findAgentServiceByAlias looks up the agent in the given environment that
+// lists name among its aliases. Returns nil if no agent does.
+func findAgentServiceByAlias(ctx context.Context, name, environment string) (*api.AgentService, error) {
+	services, err := consulServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up agent: %w", err)
+	}
+
+	for _, service := range services {
+		if !isAIAgent(service) || service.Meta["environment"] != environment {
+			continue
+		}
+		for _, alias := range metaStringList(service.Meta, "aliases") {
+			if alias == name {
+				return service, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// resolveAgentService looks up an agent by its primary name, falling back to
+// its aliases. Returns nil if neither matches. For a scaled agent it returns
+// one of its instances; see findAgentInstances for all of them.
+func resolveAgentService(ctx context.Context, name, environment string) (*api.AgentService, error) {
+	instances, err := findAgentInstances(ctx, name, environment)
+	if err != nil {
+		return nil, err
+	}
+	if len(instances) > 0 {
+		return instances[0], nil
+	}
+	return findAgentServiceByAlias(ctx, name, environment)
+}
+
+// findNameClaimConflict checks the name and aliases of an agent against the
+// names and aliases already claimed by other agents in its environment. The
+// service selfID, if any, is the agent's own registration and is skipped. It
+// returns the contested name and the agent holding it, or empty strings when
+// there is no conflict. Other instances of a scaled agent share its names and
+// are not in conflict with it.
+func findNameClaimConflict(ctx context.Context, agentName string, aliases []string, environment, selfID string) (string, string, error) {
+	services, err := consulServices(ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to check agent names: %w", err)
+	}
+
+	claimed := make(map[string]string)
+	for _, service := range services {
+		if !isAIAgent(service) || service.ID == selfID || service.Meta["environment"] != environment {
+			continue
+		}
+		if service.Service == agentName && service.Meta["instanceId"] != "" {
+			continue
+		}
+		claimed[service.Service] = service.Service
+		for _, alias := range metaStringList(service.Meta, "aliases") {
+			claimed[alias] = service.Service
+		}
+	}
+
+	for _, name := range append([]string{agentName}, aliases...) {
+		if holder, ok := claimed[name]; ok {
+			return name, holder, nil
+		}
+	}
+	return "", "", nil
+}
+
+// checkNameClaims responds with 409 and returns false if the agent's name or
+// one of its aliases is claimed by another agent. selfID is the agent's own
+// service ID when it is already registered.
+func checkNameClaims(c *gin.Context, agent sharewoodapi.Agent, selfID string) bool {
+	contested, holder, err := findNameClaimConflict(c.Request.Context(), agent.Name, agent.Aliases, agent.Environment, selfID)
+	if err != nil {
+		log.Printf("Error checking agent names: %v", err)
+		respondConsulError(c, "Failed to check agent names", err)
+		return false
+	}
+
+	if contested != "" {
+		respondError(c, http.StatusConflict, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentConflict,
+			Error:   "Name already claimed",
+			Details: fmt.Sprintf("'%s' is already used as a name or alias by agent '%s'", contested, holder),
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAliasesResolveAndCannotBeClaimedTwice(t *testing.T) {
+	_, router := newTestRegistry(t)
+	weather := testAgent("weather")
+	weather.Aliases = []string{"forecast", "meteo"}
+	registerTestAgent(t, router, weather)
+
+	if got := getTestAgent(t, router, "forecast"); got.Name != "weather" {
+		t.Fatalf("looking up an alias returned %q, want the canonical agent weather", got.Name)
+	}
+
+	collisions := map[string]func() interface{}{
+		"an alias equal to an existing name": func() interface{} {
+			agent := testAgent("news")
+			agent.Aliases = []string{"weather"}
+			return agent
+		},
+		"an alias equal to an existing alias": func() interface{} {
+			agent := testAgent("news")
+			agent.Aliases = []string{"headlines", "meteo"}
+			return agent
+		},
+		"a name equal to an existing alias": func() interface{} {
+			return testAgent("forecast")
+		},
+	}
+	for what, agent := range collisions {
+		resp := serve(router, http.MethodPost, "/api/v1/agents", agent(), nil)
+		if resp.Code != http.StatusConflict || !strings.Contains(resp.Body.String(), "weather") {
+			t.Errorf("registering %s: got %d, want 409 naming weather: %s", what, resp.Code, resp.Body)
+		}
+	}
+
+	registerTestAgent(t, router, testAgent("news"))
+	resp := serve(router, http.MethodPatch, "/api/v1/agents/news", map[string]interface{}{"aliases": []string{"meteo"}}, nil)
+	if resp.Code != http.StatusConflict {
+		t.Errorf("patching in an existing alias: got %d, want 409: %s", resp.Code, resp.Body)
+	}
+	if got := getTestAgent(t, router, "meteo"); got.Name != "weather" {
+		t.Errorf("after the rejected patch meteo resolves to %q, want weather", got.Name)
+	}
+}
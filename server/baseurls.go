@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// normalizeBaseURLs puts the primary base URL first in BaseURLs, followed by
+// the fallbacks in the order given. Agents without a primary are left alone
+// for validateBaseURLs to reject.
+func normalizeBaseURLs(agent *sharewoodapi.Agent) {
+	if agent.BaseURL == "" {
+		return
+	}
+	agent.BaseURLs = append([]string{agent.BaseURL}, fallbackBaseURLs(*agent)...)
+}
+
+// fallbackBaseURLs returns the agent's base URLs other than the primary
+func fallbackBaseURLs(agent sharewoodapi.Agent) []string {
+	var fallbacks []string
+	for _, u := range agent.BaseURLs {
+		if u != agent.BaseURL {
+			fallbacks = append(fallbacks, u)
+		}
+	}
+	return fallbacks
+}
+
+// validateBaseURLs checks every base URL of a normalized agent. Fallbacks
+// require a primary, and each URL must be absolute and listed once.
+func validateBaseURLs(agent sharewoodapi.Agent) *sharewoodapi.ErrorResponse {
+	if agent.BaseURL == "" && len(agent.BaseURLs) > 0 {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid base URL",
+			Details: "baseurl is required when baseurls are given; it is the primary URL",
+			Fields:  map[string]string{"baseurl": "is required when baseurls are given"},
+		}
+	}
+
+	seen := make(map[string]bool, len(agent.BaseURLs))
+	for i, u := range agent.BaseURLs {
+		field := fmt.Sprintf("baseurls/%d", i)
+		if seen[u] {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid base URL",
+				Details: fmt.Sprintf("'%s' is listed more than once", u),
+				Fields:  map[string]string{field: "duplicate base URL"},
+			}
+		}
+		seen[u] = true
+
+		if err := sharewoodapi.ValidateBaseURL(u, cfg.AllowAnyURLScheme); err != nil {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid base URL",
+				Details: err.Error(),
+				Fields:  map[string]string{field: err.Error()},
+			}
+		}
+	}
+	return nil
+}
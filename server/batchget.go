@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Largest number of names accepted by one batch get
+const maxBatchGetNames = 500
+
+// Batch Get endpoint - returns the agents with the given names in one round
+// trip, in the order requested, and lists the names that matched no agent.
+// Names resolve like a single get: by primary name, then alias, in the
+// environment selected by the env query parameter.
+func getAgents(c *gin.Context) {
+	var request sharewoodapi.GetAgentsRequest
+	if !decodeJSONBody(c, &request) {
+		return
+	}
+
+	if len(request.Names) > maxBatchGetNames {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Too many names",
+			Details: "A batch get accepts at most 500 names",
+		})
+		return
+	}
+
+	environment := c.Query("env")
+	services, err := consulServices(c.Request.Context())
+	if err != nil {
+		log.Printf("Error getting agents: %v", err)
+		respondConsulError(c, "Failed to get agents", err)
+		return
+	}
+
+	byName := make(map[string][]*api.AgentService)
+	byAlias := make(map[string][]*api.AgentService)
+	for _, instances := range groupAgentInstances(services) {
+		if instances[0].Meta["environment"] != environment {
+			continue
+		}
+		byName[instances[0].Service] = instances
+		for _, service := range instances {
+			for _, alias := range metaStringList(service.Meta, "aliases") {
+				byAlias[alias] = instances
+			}
+		}
+	}
+
+	statuses, err := agentHealthStatuses(c.Request.Context())
+	if err != nil {
+		// Health is informational; still return the agents
+		log.Printf("Error reading agent health: %v", err)
+	}
+
+	response := sharewoodapi.GetAgentsResponse{
+		Agents:   make([]sharewoodapi.Agent, 0, len(request.Names)),
+		NotFound: make([]string, 0),
+	}
+	seen := make(map[string]bool)
+	for _, name := range request.Names {
+		instances, ok := byName[name]
+		if !ok {
+			instances, ok = byAlias[name]
+		}
+		if !ok {
+			response.NotFound = append(response.NotFound, name)
+			continue
+		}
+
+		// Asking for an agent by two of its names returns it once
+		if seen[instances[0].ID] {
+			continue
+		}
+		seen[instances[0].ID] = true
+
+		response.Agents = append(response.Agents, instancesToAgent(instances, statuses))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// serverConfig holds the settings read from the environment at startup.
+// Admins can read it through the config endpoint, which only reports
+// whether fields tagged `config:"secret"` are set. Credentials such as
+// JWT_SECRET and CONSUL_TOKEN are read where they are used instead.
+type serverConfig struct {
+	// Path prefix the API routes are mounted under. Default: /api/v1.
+	BasePath string
+	// Path of the unauthenticated health endpoint. Default: /health.
+	HealthPath string
+	// Path of the unauthenticated Prometheus metrics endpoint. Default: /metrics.
+	MetricsPath string
+
+	// Consul service tag marking a service as a registry agent. Only services
+	// carrying it are listed, and it is added to every registration, so the
+	// registry can share a Consul with other services. Default: ai-agent.
+	AgentTag string
+
+	// Prefix of the Consul KV keys used by the registry. Default: sharewood.
+	KVPrefix string
+
+	// Maintain an index of agents by tag and owner in Consul KV, consulted by
+	// the tag and owner list filters instead of scanning every agent. Useful
+	// for large registries.
+	KVIndex bool
+
+	// Periodically register again agents whose Consul service has gone
+	// missing, e.g. after the Consul agent restarted without its state,
+	// using records kept in KV. Agents past their expiration are not
+	// restored. The check runs every ReassertInterval, jittered by up to
+	// 20%. Default: off, every minute.
+	ReassertRegistrations bool
+	ReassertInterval      time.Duration
+
+	// Hooks invoked when an agent's health turns critical, e.g. because its
+	// TTL lapsed: any of log, webhook and metric. The webhook hook posts the
+	// alert as JSON to CriticalAlertWebhook, a secret since such URLs often
+	// embed a token. Agent health is checked every CriticalAlertInterval,
+	// and an agent raises at most one alert per CriticalAlertDebounce so a
+	// flapping agent does not flood the hooks. Defaults: no hooks, checked
+	// every 10s, one alert per 5 minutes.
+	CriticalAlertHooks    []string
+	CriticalAlertWebhook  string `config:"secret"`
+	CriticalAlertInterval time.Duration
+	CriticalAlertDebounce time.Duration
+
+	// Only the owner of an agent or an admin may deregister it
+	RestrictDeregisterToOwner bool
+
+	// Accepted range for an agent's TTL check. Registrations with a TTL outside
+	// [MinTTL, MaxTTL] are rejected. Defaults: 10s to 24h.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// Interval and timeout of the HTTP checks Consul runs against an
+	// agent's healthCheckUrl. Defaults: 30s and 10s.
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+
+	// Expiration given to agents registered without one, counted from the
+	// registration. Zero registers such agents without an expiration.
+	// Default: 8760h (one year).
+	DefaultExpiration time.Duration
+
+	// Start in read-only mode, rejecting changes to agents with 503
+	ReadOnly bool
+
+	// Reject requests not made over HTTPS, as seen directly or through
+	// X-Forwarded-Proto. The health endpoint is exempt.
+	RequireHTTPS bool
+
+	// Addresses or CIDR ranges of the proxies whose X-Forwarded-Proto is
+	// honored. The header is ignored from any other peer. Default: none.
+	TrustedProxies []string
+
+	// Agent fields that must be present and non-empty on registration, by
+	// JSON name. Must include name. Default: name, description, baseurl and
+	// howtouse.
+	RequiredFields []string
+
+	// Probe an agent's OpenAPI URL on registration and warn if it cannot be
+	// fetched. The registration succeeds either way.
+	CheckOpenAPIURL bool
+
+	// Reject agents depending on agents that are not registered. Otherwise
+	// such dependencies are accepted with a warning.
+	StrictDependencies bool
+
+	// Tags agents may carry. When empty, any tag is allowed.
+	AllowedTags []string
+
+	// Roles required to register or change agents carrying a tag, from
+	// TAG_ROLES as comma-separated tag=role pairs, e.g.
+	// production=admin. Admins may always. Default: none.
+	TagRoles map[string]sharewoodapi.Role
+
+	// Signing algorithm bearer tokens must use, e.g. HS256 or RS256. HMAC
+	// algorithms verify with JWT_SECRET; the others with the PEM public key
+	// in JWTPublicKeyFile or the keys published at JWKSURL, cached for
+	// JWKSCacheTTL. Defaults: HS256, keys cached for 10 minutes.
+	JWTAlgorithm     string
+	JWTPublicKeyFile string
+	JWKSURL          string
+	JWKSCacheTTL     time.Duration
+
+	// Clock skew tolerated when checking a token's expiry and not-before
+	// times. Default: 30s.
+	JWTLeeway time.Duration
+
+	// Accept agent base URLs with schemes other than http/https
+	AllowAnyURLScheme bool
+
+	// Largest request body accepted by mutating endpoints, in bytes. Default: 1 MiB.
+	MaxBodyBytes int64
+
+	// HTTP server timeouts, guarding against clients that hold connections
+	// open. Defaults: 5s to read request headers, 30s to read the whole
+	// request, 30s to write the response and 120s for idle keep-alive
+	// connections. Blocking and streaming list requests extend their own
+	// write deadline beyond WriteTimeout.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// Certificate and key files enabling TLS. When set, the server listens
+	// with TLS and negotiates HTTP/2 with clients that support it.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// Close connections after each response instead of keeping them alive,
+	// for debugging connection handling
+	DisableKeepAlives bool
+
+	// Delay clients are asked to wait, in a Retry-After header, before
+	// retrying a request that failed because Consul could not be reached.
+	// Zero omits the header. Default: 5s.
+	BackendRetryAfter time.Duration
+
+	// Timeout for probing an agent's base URL from the ping endpoint, and the
+	// number of redirects the probe follows. Defaults: 5s and 3 redirects.
+	PingTimeout      time.Duration
+	PingMaxRedirects int64
+
+	// Smallest response body, in bytes, compressed for clients accepting
+	// gzip. A negative value disables compression. Default: 1024.
+	GzipMinBytes int64
+
+	// Largest agent icon accepted for upload, in bytes. Icons are stored in
+	// Consul KV, which caps values at 512 KiB. Default: 64 KiB.
+	MaxIconBytes int64
+}
+
+var cfg serverConfig
+
+// loadServerConfig reads the server configuration from environment variables
+func loadServerConfig() serverConfig {
+	config := serverConfig{
+		BasePath:                  envPath("API_BASE_PATH", "/api/v1"),
+		HealthPath:                envPath("HEALTH_PATH", "/health"),
+		MetricsPath:               envPath("METRICS_PATH", "/metrics"),
+		AgentTag:                  envString("AGENT_TAG", "ai-agent"),
+		KVPrefix:                  strings.Trim(envString("KV_PREFIX", "sharewood"), "/"),
+		KVIndex:                   envBool("KV_INDEX", false),
+		ReassertRegistrations:     envBool("REASSERT_REGISTRATIONS", false),
+		ReassertInterval:          envDuration("REASSERT_INTERVAL", time.Minute),
+		CriticalAlertHooks:        envList("CRITICAL_ALERT_HOOKS", nil),
+		CriticalAlertWebhook:      envString("CRITICAL_ALERT_WEBHOOK", ""),
+		CriticalAlertInterval:     envDuration("CRITICAL_ALERT_INTERVAL", 10*time.Second),
+		CriticalAlertDebounce:     envDuration("CRITICAL_ALERT_DEBOUNCE", 5*time.Minute),
+		RestrictDeregisterToOwner: envBool("RESTRICT_DEREGISTER_TO_OWNER", false),
+		MinTTL:                    envDuration("MIN_TTL", 10*time.Second),
+		MaxTTL:                    envDuration("MAX_TTL", 24*time.Hour),
+		HealthCheckInterval:       envDuration("HEALTH_CHECK_INTERVAL", 30*time.Second),
+		HealthCheckTimeout:        envDuration("HEALTH_CHECK_TIMEOUT", 10*time.Second),
+		DefaultExpiration:         envDuration("DEFAULT_EXPIRATION", 365*24*time.Hour),
+		RequiredFields:            envList("REQUIRED_FIELDS", []string{"name", "description", "baseurl", "howtouse"}),
+		CheckOpenAPIURL:           envBool("CHECK_OPENAPI_URL", false),
+		StrictDependencies:        envBool("STRICT_DEPENDENCIES", false),
+		AllowedTags:               envList("ALLOWED_TAGS", nil),
+		TagRoles:                  envTagRoles("TAG_ROLES"),
+		JWTAlgorithm:              strings.ToUpper(envString("JWT_ALGORITHM", "HS256")),
+		JWTPublicKeyFile:          envString("JWT_PUBLIC_KEY", ""),
+		JWKSURL:                   envString("JWKS_URL", ""),
+		JWKSCacheTTL:              envDuration("JWKS_CACHE_TTL", 10*time.Minute),
+		JWTLeeway:                 envDuration("JWT_LEEWAY", 30*time.Second),
+		AllowAnyURLScheme:         envBool("ALLOW_ANY_URL_SCHEME", false),
+		RequireHTTPS:              envBool("REQUIRE_HTTPS", false),
+		TrustedProxies:            envList("TRUSTED_PROXIES", nil),
+		ReadOnly:                  envBool("READ_ONLY", false),
+		MaxBodyBytes:              envInt64("MAX_BODY_BYTES", 1<<20),
+		ReadHeaderTimeout:         envDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:               envDuration("READ_TIMEOUT", 30*time.Second),
+		WriteTimeout:              envDuration("WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:               envDuration("IDLE_TIMEOUT", 120*time.Second),
+		TLSCertFile:               envString("TLS_CERT", ""),
+		TLSKeyFile:                envString("TLS_KEY", ""),
+		DisableKeepAlives:         envBool("DISABLE_KEEP_ALIVES", false),
+		BackendRetryAfter:         envDuration("BACKEND_RETRY_AFTER", 5*time.Second),
+		PingTimeout:               envDuration("PING_TIMEOUT", 5*time.Second),
+		PingMaxRedirects:          envInt64("PING_MAX_REDIRECTS", 3),
+		GzipMinBytes:              envInt64("GZIP_MIN_BYTES", 1024),
+		MaxIconBytes:              envInt64("MAX_ICON_BYTES", 64<<10),
+	}
+
+	if config.MinTTL < time.Second || config.MaxTTL < config.MinTTL {
+		log.Fatalf("Invalid TTL bounds: MIN_TTL=%s MAX_TTL=%s", config.MinTTL, config.MaxTTL)
+	}
+	if config.HealthCheckInterval <= 0 || config.HealthCheckTimeout <= 0 {
+		log.Fatalf("Invalid HTTP health check settings: HEALTH_CHECK_INTERVAL=%s HEALTH_CHECK_TIMEOUT=%s", config.HealthCheckInterval, config.HealthCheckTimeout)
+	}
+	if config.DefaultExpiration < 0 {
+		log.Fatalf("Invalid DEFAULT_EXPIRATION: must not be negative")
+	}
+	if !containsString(config.RequiredFields, "name") {
+		log.Fatalf("Invalid REQUIRED_FIELDS: name must always be required")
+	}
+	if config.ReassertInterval <= 0 {
+		log.Fatalf("Invalid REASSERT_INTERVAL: must be positive")
+	}
+	for _, hook := range config.CriticalAlertHooks {
+		if !criticalAlertHooks[hook] {
+			log.Fatalf("Invalid CRITICAL_ALERT_HOOKS: unknown hook %q", hook)
+		}
+	}
+	if containsString(config.CriticalAlertHooks, "webhook") && config.CriticalAlertWebhook == "" {
+		log.Fatalf("Invalid CRITICAL_ALERT_HOOKS: the webhook hook requires CRITICAL_ALERT_WEBHOOK")
+	}
+	if config.CriticalAlertInterval <= 0 || config.CriticalAlertDebounce < 0 {
+		log.Fatalf("Invalid critical alert settings: CRITICAL_ALERT_INTERVAL must be positive and CRITICAL_ALERT_DEBOUNCE non-negative")
+	}
+	if !supportedJWTAlgorithms[config.JWTAlgorithm] {
+		log.Fatalf("Invalid JWT_ALGORITHM: %q is not supported", config.JWTAlgorithm)
+	}
+	if !strings.HasPrefix(config.JWTAlgorithm, "HS") && (config.JWTPublicKeyFile == "") == (config.JWKSURL == "") {
+		log.Fatalf("Invalid JWT settings: %s requires exactly one of JWT_PUBLIC_KEY and JWKS_URL", config.JWTAlgorithm)
+	}
+	if config.JWTLeeway < 0 {
+		log.Fatalf("Invalid JWT_LEEWAY: must not be negative")
+	}
+	if _, err := parseTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if config.MaxBodyBytes <= 0 {
+		log.Fatalf("Invalid MAX_BODY_BYTES: must be positive")
+	}
+	if config.ReadHeaderTimeout <= 0 || config.ReadTimeout <= 0 || config.WriteTimeout <= 0 || config.IdleTimeout <= 0 {
+		log.Fatalf("Invalid server timeouts: READ_HEADER_TIMEOUT, READ_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT must be positive")
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		log.Fatalf("Invalid TLS settings: TLS_CERT and TLS_KEY must be set together")
+	}
+	if config.BackendRetryAfter < 0 {
+		log.Fatalf("Invalid BACKEND_RETRY_AFTER: must not be negative")
+	}
+	if config.PingTimeout <= 0 || config.PingMaxRedirects < 0 {
+		log.Fatalf("Invalid ping settings: PING_TIMEOUT must be positive and PING_MAX_REDIRECTS non-negative")
+	}
+	if config.MaxIconBytes <= 0 || config.MaxIconBytes > maxConsulKVValueBytes {
+		log.Fatalf("Invalid MAX_ICON_BYTES: must be positive and at most %d", maxConsulKVValueBytes)
+	}
+
+	return config
+}
+
+// envString reads a string environment variable, falling back to def when unset
+func envString(key string, def string) string {
+	if val := strings.TrimSpace(os.Getenv(key)); val != "" {
+		return val
+	}
+	return def
+}
+
+// envBool reads a boolean environment variable, falling back to def when unset
+func envBool(key string, def bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %q is not a boolean", key, val)
+	}
+	return b
+}
+
+// envDuration reads a duration environment variable (e.g. "30s", "24h"),
+// falling back to def when unset
+func envDuration(key string, def time.Duration) time.Duration {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %q is not a duration", key, val)
+	}
+	return d
+}
+
+// envInt64 reads an integer environment variable, falling back to def when unset
+func envInt64(key string, def int64) int64 {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid value for %s: %q is not an integer", key, val)
+	}
+	return n
+}
+
+// envList reads a comma-separated environment variable, falling back to def
+// when unset. Empty entries are ignored.
+func envList(key string, def []string) []string {
+	val := os.Getenv(key)
+	if strings.TrimSpace(val) == "" {
+		return def
+	}
+	list := make([]string, 0)
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envTagRoles reads a list of tag=role pairs, exiting on a malformed pair or
+// an unknown role
+func envTagRoles(key string) map[string]sharewoodapi.Role {
+	roles := make(map[string]sharewoodapi.Role)
+	for _, pair := range envList(key, nil) {
+		tag, role, ok := strings.Cut(pair, "=")
+		tag, role = strings.TrimSpace(tag), strings.TrimSpace(role)
+		if !ok || tag == "" {
+			log.Fatalf("Invalid value for %s: %q is not a tag=role pair", key, pair)
+		}
+		if r := sharewoodapi.Role(role); r != sharewoodapi.RoleAdmin && r != sharewoodapi.RoleAgentPublisher {
+			log.Fatalf("Invalid value for %s: unknown role %q for tag %q", key, role, tag)
+		}
+		roles[tag] = sharewoodapi.Role(role)
+	}
+	return roles
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// envPath reads a URL path environment variable, normalizing it to have a
+// leading slash and no trailing slash
+func envPath(key string, def string) string {
+	val := os.Getenv(key)
+	if val == "" {
+		val = def
+	}
+	return "/" + strings.Trim(val, "/")
+}
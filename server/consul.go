@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// The functions below are the only place the server talks to Consul. Each one
+// wraps a single Consul API call and records its latency and errors, so new
+// backend calls should be added here rather than made on consulClient directly.
+// Calls take the context of the request they serve, so a client disconnect or
+// server timeout cancels the Consul operation instead of leaving it running.
+//
+// Background tasks, registration reassertion and critical health alerts, use
+// the same wrappers, so their calls are measured too. The registry has no
+// expiration sweeper: expiration is stored with each agent and nothing
+// removes agents once it passes, so there are no sweeper calls to measure.
+
+// queryOptions and writeOptions carry ctx into a Consul call
+func queryOptions(ctx context.Context) *api.QueryOptions {
+	return (&api.QueryOptions{}).WithContext(ctx)
+}
+
+func writeOptions(ctx context.Context) *api.WriteOptions {
+	return (&api.WriteOptions{}).WithContext(ctx)
+}
+
+func consulServices(ctx context.Context) (map[string]*api.AgentService, error) {
+	start := time.Now()
+	services, err := consulClient.Agent().ServicesWithFilterOpts("", queryOptions(ctx))
+	observeConsulCall("agent.services", start, err)
+	return services, err
+}
+
+func consulChecks(ctx context.Context) (map[string]*api.AgentCheck, error) {
+	start := time.Now()
+	checks, err := consulClient.Agent().ChecksWithFilterOpts("", queryOptions(ctx))
+	observeConsulCall("agent.checks", start, err)
+	return checks, err
+}
+
+// consulAgentSelf cannot be cancelled: the Consul API offers no
+// context-aware variant of Self
+func consulAgentSelf() (map[string]map[string]interface{}, error) {
+	start := time.Now()
+	self, err := consulClient.Agent().Self()
+	observeConsulCall("agent.self", start, err)
+	return self, err
+}
+
+func consulServiceRegister(ctx context.Context, registration *api.AgentServiceRegistration) error {
+	start := time.Now()
+	err := consulClient.Agent().ServiceRegisterOpts(registration, api.ServiceRegisterOpts{}.WithContext(ctx))
+	observeConsulCall("agent.service_register", start, err)
+	return err
+}
+
+func consulServiceDeregister(ctx context.Context, serviceID string) error {
+	start := time.Now()
+	err := consulClient.Agent().ServiceDeregisterOpts(serviceID, queryOptions(ctx))
+	observeConsulCall("agent.service_deregister", start, err)
+	return err
+}
+
+func consulCheckDeregister(ctx context.Context, checkID string) error {
+	start := time.Now()
+	err := consulClient.Agent().CheckDeregisterOpts(checkID, queryOptions(ctx))
+	observeConsulCall("agent.check_deregister", start, err)
+	return err
+}
+
+func consulUpdateTTL(ctx context.Context, checkID, output, status string) error {
+	start := time.Now()
+	err := consulClient.Agent().UpdateTTLOpts(checkID, output, status, queryOptions(ctx))
+	observeConsulCall("agent.update_ttl", start, err)
+	return err
+}
+
+// consulPreparedQueryExecute runs a prepared query by name or ID. Consul
+// applies the query's failover policy, so the result may come from another
+// datacenter.
+func consulPreparedQueryExecute(ctx context.Context, nameOrID string) (*api.PreparedQueryExecuteResponse, error) {
+	start := time.Now()
+	result, _, err := consulClient.PreparedQuery().Execute(nameOrID, queryOptions(ctx))
+	observeConsulCall("query.execute", start, err)
+	return result, err
+}
+
+func consulCatalogServices(ctx context.Context, q *api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	start := time.Now()
+	services, meta, err := consulClient.Catalog().Services(q.WithContext(ctx))
+	observeConsulCall("catalog.services", start, err)
+	return services, meta, err
+}
+
+func consulSessionCreate(ctx context.Context, entry *api.SessionEntry) (string, error) {
+	start := time.Now()
+	id, _, err := consulClient.Session().Create(entry, writeOptions(ctx))
+	observeConsulCall("session.create", start, err)
+	return id, err
+}
+
+func consulSessionDestroy(ctx context.Context, id string) error {
+	start := time.Now()
+	_, err := consulClient.Session().Destroy(id, writeOptions(ctx))
+	observeConsulCall("session.destroy", start, err)
+	return err
+}
+
+func consulKVAcquire(ctx context.Context, pair *api.KVPair) (bool, error) {
+	start := time.Now()
+	acquired, _, err := consulClient.KV().Acquire(pair, writeOptions(ctx))
+	observeConsulCall("kv.acquire", start, err)
+	return acquired, err
+}
+
+func consulKVRelease(ctx context.Context, pair *api.KVPair) error {
+	start := time.Now()
+	_, _, err := consulClient.KV().Release(pair, writeOptions(ctx))
+	observeConsulCall("kv.release", start, err)
+	return err
+}
+
+func consulKVKeys(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	keys, _, err := consulClient.KV().Keys(prefix, "", queryOptions(ctx))
+	observeConsulCall("kv.keys", start, err)
+	return keys, err
+}
+
+// consulKVTxn applies KV operations atomically, failing if Consul rolled the
+// transaction back
+func consulKVTxn(ctx context.Context, ops api.TxnOps) error {
+	start := time.Now()
+	ok, resp, _, err := consulClient.Txn().Txn(ops, queryOptions(ctx))
+	if err == nil && !ok {
+		err = fmt.Errorf("transaction rolled back: %v", resp.Errors)
+	}
+	observeConsulCall("txn", start, err)
+	return err
+}
+
+func consulKVList(ctx context.Context, prefix string) (api.KVPairs, error) {
+	start := time.Now()
+	pairs, _, err := consulClient.KV().List(prefix, queryOptions(ctx))
+	observeConsulCall("kv.list", start, err)
+	return pairs, err
+}
+
+// consulKVGet reads one key, returning nil if it does not exist
+func consulKVGet(ctx context.Context, key string) (*api.KVPair, error) {
+	start := time.Now()
+	pair, _, err := consulClient.KV().Get(key, queryOptions(ctx))
+	observeConsulCall("kv.get", start, err)
+	return pair, err
+}
+
+func consulKVPut(ctx context.Context, pair *api.KVPair) error {
+	start := time.Now()
+	_, err := consulClient.KV().Put(pair, writeOptions(ctx))
+	observeConsulCall("kv.put", start, err)
+	return err
+}
+
+func consulKVDelete(ctx context.Context, key string) error {
+	start := time.Now()
+	_, err := consulClient.KV().Delete(key, writeOptions(ctx))
+	observeConsulCall("kv.delete", start, err)
+	return err
+}
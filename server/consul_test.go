@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCancelledRequestCancelsConsulCall(t *testing.T) {
+	consul, router := newTestRegistry(t)
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	var once sync.Once
+	consul.onRequest = func(r *http.Request) {
+		if r.URL.Path != "/v1/agent/services" {
+			return
+		}
+		// Hold the call until the registry gives up on it
+		once.Do(func() { close(started) })
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request never reached Consul")
+	}
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the Consul call was not cancelled with the request")
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the handler did not return after the request was cancelled")
+	}
+}
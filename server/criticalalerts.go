@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Hooks that may be listed in CRITICAL_ALERT_HOOKS
+var criticalAlertHooks = map[string]bool{
+	"log":     true,
+	"webhook": true,
+	"metric":  true,
+}
+
+// Count of agents whose health turned critical, for the metric hook
+var agentCriticalTransitions = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "sharewood",
+	Subsystem: "agents",
+	Name:      "critical_transitions_total",
+	Help:      "Agents whose health turned critical, after debouncing.",
+})
+
+func init() {
+	prometheus.MustRegister(agentCriticalTransitions)
+}
+
+// Time allowed for each webhook call. Every alert gets its own, so a slow
+// webhook cannot use up the time of the alerts after it in a pass.
+const criticalAlertWebhookTimeout = 10 * time.Second
+
+// criticalAlert describes an agent whose health turned critical. It is the
+// body the webhook hook posts. ServiceID identifies the logical agent: a
+// scaled agent's instances share the service ID its name and environment
+// give it, and it turns critical only once every instance has.
+type criticalAlert struct {
+	ServiceID string                    `json:"serviceId"`
+	Name      string                    `json:"name"`
+	Previous  sharewoodapi.HealthStatus `json:"previous"`
+	Health    sharewoodapi.HealthStatus `json:"health"`
+	Time      time.Time                 `json:"time"`
+}
+
+// criticalWatcher remembers agent health between passes, so only agents
+// turning critical raise alerts, and when each agent last raised one
+type criticalWatcher struct {
+	debounce  time.Duration
+	alert     func(alert criticalAlert) error
+	previous  map[string]sharewoodapi.HealthStatus
+	lastAlert map[string]time.Time
+}
+
+// observe compares the health of each agent, keyed by service ID, with the
+// previous pass and alerts for those that turned critical. Agents seen for
+// the first time only set the baseline, so agents already critical when the
+// watcher starts, and new agents whose TTL has not been renewed yet, raise
+// no alert. An agent alerts at most once per debounce period. An alert that
+// fails to be delivered is not recorded, so the next pass raises it again.
+func (w *criticalWatcher) observe(names map[string]string, health map[string]sharewoodapi.HealthStatus, now time.Time) {
+	first := w.previous == nil
+	if first {
+		w.previous = make(map[string]sharewoodapi.HealthStatus)
+		w.lastAlert = make(map[string]time.Time)
+	}
+
+	for serviceID, status := range health {
+		previous, seen := w.previous[serviceID]
+		if first || !seen || status != sharewoodapi.HealthCritical || previous == sharewoodapi.HealthCritical {
+			w.previous[serviceID] = status
+			continue
+		}
+		if last, ok := w.lastAlert[serviceID]; ok && now.Sub(last) < w.debounce {
+			w.previous[serviceID] = status
+			continue
+		}
+		err := w.alert(criticalAlert{
+			ServiceID: serviceID,
+			Name:      names[serviceID],
+			Previous:  previous,
+			Health:    status,
+			Time:      now,
+		})
+		if err != nil {
+			log.Printf("Error raising critical alert for %s, retrying on the next pass: %v", serviceID, err)
+			continue
+		}
+		w.previous[serviceID] = status
+		w.lastAlert[serviceID] = now
+	}
+
+	// Forget deregistered agents
+	for serviceID := range w.previous {
+		if _, ok := health[serviceID]; !ok {
+			delete(w.previous, serviceID)
+			delete(w.lastAlert, serviceID)
+		}
+	}
+}
+
+// runCriticalAlerts checks agent health every cfg.CriticalAlertInterval and
+// invokes the configured hooks for agents that turned critical, e.g. because
+// their TTL lapsed. Each pass is cut off after one interval. It never
+// returns.
+func runCriticalAlerts() {
+	watcher := &criticalWatcher{
+		debounce: cfg.CriticalAlertDebounce,
+		alert:    raiseCriticalAlert,
+	}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.CriticalAlertInterval)
+		if err := watchCriticalAgents(ctx, watcher); err != nil {
+			log.Printf("Error checking agents for critical health: %v", err)
+		}
+		cancel()
+		time.Sleep(cfg.CriticalAlertInterval)
+	}
+}
+
+// watchCriticalAgents reads the health of every agent and passes it to the
+// watcher. A scaled agent is watched as one agent, as healthy as its
+// healthiest instance, so losing one instance raises no alert. A failed read
+// leaves the watcher unchanged.
+func watchCriticalAgents(ctx context.Context, watcher *criticalWatcher) error {
+	services, err := consulServices(ctx)
+	if err != nil {
+		return err
+	}
+	statuses, err := agentHealthStatuses(ctx)
+	if err != nil {
+		return err
+	}
+
+	groups := groupAgentInstances(services)
+	names := make(map[string]string, len(groups))
+	health := make(map[string]sharewoodapi.HealthStatus, len(groups))
+	for key, instances := range groups {
+		names[key] = instances[0].Service
+		health[key] = instancesToAgent(instances, statuses).Health
+	}
+	watcher.observe(names, health, time.Now())
+	return nil
+}
+
+// raiseCriticalAlert invokes each configured hook for the alert. The webhook
+// is posted first: if that fails the error is returned and the other hooks
+// are left for the retry, so each alert is logged and counted once.
+func raiseCriticalAlert(alert criticalAlert) error {
+	if containsString(cfg.CriticalAlertHooks, "webhook") {
+		if err := postCriticalAlert(cfg.CriticalAlertWebhook, alert); err != nil {
+			return fmt.Errorf("failed to post to the webhook: %w", err)
+		}
+	}
+	for _, hook := range cfg.CriticalAlertHooks {
+		switch hook {
+		case "log":
+			log.Printf("Agent %s (%s) turned critical, was %s", alert.Name, alert.ServiceID, alert.Previous)
+		case "metric":
+			agentCriticalTransitions.Inc()
+		}
+	}
+	return nil
+}
+
+// postCriticalAlert posts the alert as JSON to target, expecting a 2xx
+// response within criticalAlertWebhookTimeout
+func postCriticalAlert(target string, alert criticalAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), criticalAlertWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// newAlertWebhook returns a webhook recording the alerts posted to it. Each
+// call is answered with the next of statuses, then with 200.
+func newAlertWebhook(t *testing.T, statuses ...int) (*httptest.Server, func() []criticalAlert) {
+	var mu sync.Mutex
+	var alerts []criticalAlert
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var alert criticalAlert
+		if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+			t.Errorf("webhook received an invalid alert: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		alerts = append(alerts, alert)
+		if len(statuses) > 0 {
+			w.WriteHeader(statuses[0])
+			statuses = statuses[1:]
+		}
+	}))
+	t.Cleanup(webhook.Close)
+	return webhook, func() []criticalAlert {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]criticalAlert(nil), alerts...)
+	}
+}
+
+// newAlertWatcher returns a watcher posting alerts for the fake Consul's
+// agents to webhook, and a function running one pass of it
+func newAlertWatcher(t *testing.T, webhook string) func() {
+	cfg.CriticalAlertHooks = []string{"webhook", "log"}
+	cfg.CriticalAlertWebhook = webhook
+	watcher := &criticalWatcher{debounce: cfg.CriticalAlertDebounce, alert: raiseCriticalAlert}
+	return func() {
+		t.Helper()
+		if err := watchCriticalAgents(context.Background(), watcher); err != nil {
+			t.Fatalf("watchCriticalAgents: %v", err)
+		}
+	}
+}
+
+func TestCriticalAlertFiresOnceWhenTTLLapses(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	webhook, alerts := newAlertWebhook(t)
+	pass := newAlertWatcher(t, webhook.URL)
+	registerTestAgent(t, router, testAgent("geo"))
+
+	// The agent renews its TTL, then stops and Consul marks it critical. It
+	// stays critical, then flaps within the debounce period.
+	if resp := serve(router, http.MethodPut, "/api/v1/agents/geo/health", map[string]string{"status": "passing"}, nil); resp.Code != http.StatusOK {
+		t.Fatalf("renewing the TTL: got %d: %s", resp.Code, resp.Body)
+	}
+	pass()
+	consul.setCheckStatus("service:geo", api.HealthCritical)
+	pass()
+	pass()
+	consul.setCheckStatus("service:geo", api.HealthPassing)
+	pass()
+	consul.setCheckStatus("service:geo", api.HealthCritical)
+	pass()
+
+	got := alerts()
+	if len(got) != 1 {
+		t.Fatalf("hook fired %d times, want once", len(got))
+	}
+	if got[0].Name != "geo" || got[0].Previous != sharewoodapi.HealthPassing || got[0].Health != sharewoodapi.HealthCritical {
+		t.Fatalf("unexpected alert %+v", got[0])
+	}
+}
+
+func TestFailedCriticalAlertIsRetried(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	webhook, alerts := newAlertWebhook(t, http.StatusBadGateway)
+	pass := newAlertWatcher(t, webhook.URL)
+	registerTestAgent(t, router, testAgent("geo"))
+	consul.setCheckStatus("service:geo", api.HealthPassing)
+
+	pass()
+	consul.setCheckStatus("service:geo", api.HealthCritical)
+	pass()
+	if got := alerts(); len(got) != 1 {
+		t.Fatalf("the webhook was called %d times, want once", len(got))
+	}
+
+	// The failed delivery is neither debounced nor taken as the agent
+	// having been seen critical, so the next pass delivers it
+	pass()
+	pass()
+	got := alerts()
+	if len(got) != 2 || got[1].Name != "geo" || got[1].Previous != sharewoodapi.HealthPassing {
+		t.Fatalf("got alerts %+v, want the failed one delivered again once", got)
+	}
+}
+
+func TestCriticalAlertSkipsAgentsAlreadyCritical(t *testing.T) {
+	fired := 0
+	watcher := &criticalWatcher{
+		debounce: time.Minute,
+		alert: func(criticalAlert) error {
+			fired++
+			return nil
+		},
+	}
+
+	names := map[string]string{"geo": "geo", "new": "new"}
+	start := time.Now()
+
+	// geo is critical when the watcher starts; new appears critical later
+	watcher.observe(names, map[string]sharewoodapi.HealthStatus{"geo": sharewoodapi.HealthCritical}, start)
+	watcher.observe(names, map[string]sharewoodapi.HealthStatus{
+		"geo": sharewoodapi.HealthCritical,
+		"new": sharewoodapi.HealthCritical,
+	}, start.Add(10*time.Second))
+
+	if fired != 0 {
+		t.Fatalf("hook fired %d times, want none", fired)
+	}
+}
+
+func TestCriticalAlertWebhookIsNotReported(t *testing.T) {
+	settings := configSettings(serverConfig{CriticalAlertWebhook: "https://hooks.example.com/secret-token"})
+	if _, ok := settings["CriticalAlertWebhook"]; ok {
+		t.Fatal("the webhook URL is reported among the settings")
+	}
+}
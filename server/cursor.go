@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Page sizes for cursor pagination of the agent list
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// listCursor is the position of the last agent on a page, in the order the
+// page was listed in. It is sent to clients as an opaque token.
+type listCursor struct {
+	Sort        string     `json:"s"`
+	Order       string     `json:"o"`
+	Query       string     `json:"q,omitempty"`
+	Name        string     `json:"n"`
+	Environment string     `json:"e,omitempty"`
+	Time        *time.Time `json:"t,omitempty"`
+}
+
+// encodeListCursor builds the cursor for the page ending at agent
+func encodeListCursor(agent sharewoodapi.Agent, sortBy, order, query string) string {
+	cursor := listCursor{
+		Sort:        sortBy,
+		Order:       order,
+		Query:       query,
+		Name:        agent.Name,
+		Environment: agent.Environment,
+	}
+	switch sortBy {
+	case "createdAt":
+		cursor.Time = agent.CreatedAt
+	case "updatedAt":
+		cursor.Time = agent.UpdatedAt
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor parses a cursor and checks it was issued for a listing
+// in the same order. The agent returned stands for the cursor's position.
+func decodeListCursor(token, sortBy, order, query string) (sharewoodapi.Agent, error) {
+	var cursor listCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		err = json.Unmarshal(data, &cursor)
+	}
+	if err != nil {
+		return sharewoodapi.Agent{}, fmt.Errorf("cursor is malformed")
+	}
+	if cursor.Sort != sortBy || cursor.Order != order || cursor.Query != query {
+		return sharewoodapi.Agent{}, fmt.Errorf("cursor was issued for a different sort, order or query")
+	}
+
+	position := sharewoodapi.Agent{Name: cursor.Name, Environment: cursor.Environment}
+	switch sortBy {
+	case "createdAt":
+		position.CreatedAt = cursor.Time
+	case "updatedAt":
+		position.UpdatedAt = cursor.Time
+	}
+	return position, nil
+}
+
+// parsePageLimit reads the limit query parameter of a paginated listing
+func parsePageLimit(raw string) (int, error) {
+	if raw == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	return limit, nil
+}
+
+// agentsPage returns the agents after position, which are sorted by less, up
+// to limit of them, and the cursor for the next page if any remain. Agents
+// added or removed between pages do not shift the remaining ones, because the
+// position is a sort key rather than an offset.
+func agentsPage(agents []sharewoodapi.Agent, position *sharewoodapi.Agent, limit int, less func(a, b sharewoodapi.Agent) bool, cursorFor func(sharewoodapi.Agent) string) ([]sharewoodapi.Agent, string) {
+	start := 0
+	if position != nil {
+		for start < len(agents) && !less(*position, agents[start]) {
+			start++
+		}
+	}
+
+	end := start + limit
+	if end >= len(agents) {
+		return agents[start:], ""
+	}
+	return agents[start:end], cursorFor(agents[end-1])
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// agentDefaults returns the values applyAgentDefaults gives omitted fields
+func agentDefaults() sharewoodapi.AgentDefaults {
+	return sharewoodapi.AgentDefaults{
+		Lifecycle:         sharewoodapi.LifecycleActive,
+		ExpirationSeconds: int64(cfg.DefaultExpiration / time.Second),
+		Weight:            sharewoodapi.DefaultAgentWeight,
+	}
+}
+
+// applyAgentDefaults fills in server-side defaults for fields a new agent
+// omitted, as reported by the defaults endpoint: the lifecycle, the weight,
+// and an expiration DEFAULT_EXPIRATION from now. The owner defaults to the caller,
+// which only the handler knows.
+func applyAgentDefaults(agent *sharewoodapi.Agent) {
+	if agent.Lifecycle == "" {
+		agent.Lifecycle = sharewoodapi.LifecycleActive
+	}
+	if agent.Weight == 0 {
+		agent.Weight = sharewoodapi.DefaultAgentWeight
+	}
+	if agent.Expiration.IsZero() && cfg.DefaultExpiration > 0 {
+		agent.Expiration = timestampNow().Add(cfg.DefaultExpiration)
+	}
+	normalizeBaseURLs(agent)
+}
+
+// Get Agent Defaults endpoint - reports the values registration gives
+// omitted fields, so clients need not hard-code them
+func getAgentDefaults(c *gin.Context) {
+	c.JSON(http.StatusOK, agentDefaults())
+}
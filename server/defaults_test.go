@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestMinimalAgentGetsTheReportedDefaults(t *testing.T) {
+	_, router := newTestRegistry(t)
+
+	resp := serve(router, http.MethodGet, "/api/v1/agents/defaults", nil, nil)
+	var defaults sharewoodapi.AgentDefaults
+	if resp.Code != http.StatusOK || json.Unmarshal(resp.Body.Bytes(), &defaults) != nil {
+		t.Fatalf("reading defaults: got %d: %s", resp.Code, resp.Body)
+	}
+	if defaults.Lifecycle != sharewoodapi.LifecycleActive || defaults.Weight != sharewoodapi.DefaultAgentWeight || defaults.ExpirationSeconds != int64((365*24*time.Hour).Seconds()) {
+		t.Fatalf("got defaults %+v", defaults)
+	}
+
+	minimal := sharewoodapi.Agent{
+		Name:        "weather",
+		Description: "Forecasts the weather",
+		BaseURL:     "http://localhost:8080",
+		HowToUse:    "GET /forecast",
+	}
+	registered := time.Now()
+	resp = serve(router, http.MethodPost, "/api/v1/agents", minimal, nil)
+	var created sharewoodapi.AgentRegistrationResponse
+	if resp.Code != http.StatusCreated || json.Unmarshal(resp.Body.Bytes(), &created) != nil {
+		t.Fatalf("registering: got %d: %s", resp.Code, resp.Body)
+	}
+
+	// The defaults are in the registration response and stored with the agent
+	for source, agent := range map[string]sharewoodapi.Agent{"response": created.Agent, "read": getTestAgent(t, router, "weather")} {
+		if agent.Lifecycle != defaults.Lifecycle || agent.Weight != defaults.Weight {
+			t.Errorf("%s: lifecycle %q and weight %d, want %q and %d", source, agent.Lifecycle, agent.Weight, defaults.Lifecycle, defaults.Weight)
+		}
+		wantExpiration := registered.Add(time.Duration(defaults.ExpirationSeconds) * time.Second)
+		if diff := agent.Expiration.Sub(wantExpiration); diff < -2*time.Second || diff > 2*time.Second {
+			t.Errorf("%s: expiration %v, want about %v", source, agent.Expiration, wantExpiration)
+		}
+	}
+}
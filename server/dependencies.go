@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// agentDirectory indexes the agents of one environment by primary name and
+// alias, for resolving dependency references
+type agentDirectory struct {
+	// primary name of the agent claiming each name or alias
+	names map[string]string
+	// services keyed by primary name, every instance of a scaled agent
+	services map[string][]*api.AgentService
+}
+
+// loadAgentDirectory reads the agents registered in the given environment
+func loadAgentDirectory(ctx context.Context, environment string) (*agentDirectory, error) {
+	services, err := consulServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agents: %w", err)
+	}
+
+	dir := &agentDirectory{
+		names:    make(map[string]string),
+		services: make(map[string][]*api.AgentService),
+	}
+	for _, instances := range groupAgentInstances(services) {
+		if instances[0].Meta["environment"] != environment {
+			continue
+		}
+		name := instances[0].Service
+		dir.services[name] = instances
+		dir.names[name] = name
+		for _, service := range instances {
+			for _, alias := range metaStringList(service.Meta, "aliases") {
+				dir.names[alias] = name
+			}
+		}
+	}
+	return dir, nil
+}
+
+// findDependencyCycle looks for a chain of dependencies leading from the
+// agent back to itself, taking the agent's new dependencies in place of any
+// it has registered. It returns the names along the cycle, or nil.
+func (dir *agentDirectory) findDependencyCycle(agent sharewoodapi.Agent) []string {
+	dependencies := func(name string) []string {
+		if name == agent.Name {
+			return agent.DependsOn
+		}
+		if instances, ok := dir.services[name]; ok {
+			return instancesToAgent(instances, nil).DependsOn
+		}
+		return nil
+	}
+
+	visited := make(map[string]bool)
+	var path []string
+	var visit func(name string) bool
+	visit = func(name string) bool {
+		path = append(path, name)
+		for _, dep := range dependencies(name) {
+			target := dir.names[dep]
+			if dep == agent.Name || containsString(agent.Aliases, dep) {
+				target = agent.Name
+			}
+			if target == "" {
+				continue
+			}
+			if target == agent.Name {
+				path = append(path, agent.Name)
+				return true
+			}
+			if !visited[target] {
+				visited[target] = true
+				if visit(target) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+
+	if visit(agent.Name) {
+		return path
+	}
+	return nil
+}
+
+// checkAgentDependencies verifies an agent's dependencies before it is
+// stored. Dependencies on unregistered agents are rejected when
+// STRICT_DEPENDENCIES is set and otherwise returned as warnings; cycles are
+// always rejected. It writes the error response and returns false on failure.
+func checkAgentDependencies(c *gin.Context, agent sharewoodapi.Agent) ([]string, bool) {
+	warnings, errResp, err := agentDependencyProblems(c.Request.Context(), agent)
+	if err != nil {
+		log.Printf("Error checking agent dependencies: %v", err)
+		respondConsulError(c, "Failed to check agent dependencies", err)
+		return nil, false
+	}
+	if errResp != nil {
+		respondError(c, http.StatusBadRequest, *errResp)
+		return nil, false
+	}
+	return warnings, true
+}
+
+// agentDependencyProblems does the work of checkAgentDependencies, returning
+// warnings for the lenient case or the error response to send
+func agentDependencyProblems(ctx context.Context, agent sharewoodapi.Agent) ([]string, *sharewoodapi.ErrorResponse, error) {
+	if len(agent.DependsOn) == 0 {
+		return nil, nil, nil
+	}
+
+	dir, err := loadAgentDirectory(ctx, agent.Environment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	missing := make([]string, 0)
+	for _, dep := range agent.DependsOn {
+		if _, ok := dir.names[dep]; !ok {
+			missing = append(missing, dep)
+		}
+	}
+	if len(missing) > 0 && cfg.StrictDependencies {
+		return nil, &sharewoodapi.ErrorResponse{
+			Error:   "Unknown dependencies",
+			Details: fmt.Sprintf("No agents are registered for: %s", strings.Join(missing, ", ")),
+			Fields:  map[string]string{"dependsOn": "references unregistered agents"},
+		}, nil
+	}
+
+	if cycle := dir.findDependencyCycle(agent); cycle != nil {
+		return nil, &sharewoodapi.ErrorResponse{
+			Error:   "Dependency cycle",
+			Details: fmt.Sprintf("Dependencies form a cycle: %s", strings.Join(cycle, " -> ")),
+			Fields:  map[string]string{"dependsOn": "forms a cycle"},
+		}, nil
+	}
+
+	warnings := make([]string, 0, len(missing))
+	for _, dep := range missing {
+		warnings = append(warnings, fmt.Sprintf("dependency '%s' is not registered", dep))
+	}
+	return warnings, nil, nil
+}
+
+// Agent Dependencies endpoint - returns the agents the named agent depends
+// on, resolved in its environment. Dependencies that are not registered are
+// left out.
+func getAgentDependencies(c *gin.Context) {
+	name := c.Param("name")
+	environment := c.Query("env")
+
+	service, err := resolveAgentService(c.Request.Context(), name, environment)
+	if err != nil {
+		log.Printf("Error getting agent: %v", err)
+		respondConsulError(c, "Failed to get agent", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	dir, err := loadAgentDirectory(c.Request.Context(), environment)
+	if err != nil {
+		log.Printf("Error resolving agent dependencies: %v", err)
+		respondConsulError(c, "Failed to resolve agent dependencies", err)
+		return
+	}
+
+	statuses, err := agentHealthStatuses(c.Request.Context())
+	if err != nil {
+		// Health is informational; still return the agents
+		log.Printf("Error reading agent health: %v", err)
+	}
+
+	agents := make([]sharewoodapi.Agent, 0)
+	seen := make(map[string]bool)
+	for _, dep := range instancesToAgent(dir.services[service.Service], nil).DependsOn {
+		target, ok := dir.names[dep]
+		if !ok || seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		agents = append(agents, instancesToAgent(dir.services[target], statuses))
+	}
+
+	c.JSON(http.StatusOK, agents)
+}
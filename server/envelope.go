@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Context key under which handlers leave envelope metadata for a response
+const envelopeMetaKey = "envelopeMeta"
+
+// envelopeMiddleware wraps successful JSON responses in a
+// sharewoodapi.Envelope, {"data": ..., "meta": ...}, when the request asks
+// for it with ?envelope=true. Without the flag responses keep their
+// historical shapes: a bare array for lists, an object for single agents.
+// Error responses are never wrapped, as they already share the
+// ErrorResponse shape, and neither are non-JSON or streamed responses.
+//
+// The envelope is opt-in for compatibility. A future version will make it
+// the default, with envelope=false keeping the old shapes for one release.
+// Clients should send envelope=true now and read the data field.
+func envelopeMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("envelope") != "true" {
+			c.Next()
+			return
+		}
+
+		writer := &envelopeResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		writer.finish(c)
+	}
+}
+
+// envelopeRequested reports whether the response to c will be enveloped, for
+// handlers that shape their data differently inside an envelope
+func envelopeRequested(c *gin.Context) bool {
+	_, ok := c.Writer.(*envelopeResponseWriter)
+	return ok
+}
+
+// setEnvelopeMeta records the metadata to send alongside an enveloped response
+func setEnvelopeMeta(c *gin.Context, meta sharewoodapi.EnvelopeMeta) {
+	c.Set(envelopeMetaKey, meta)
+}
+
+// envelopeResponseWriter buffers a successful JSON response so it can be
+// wrapped once complete. Any other response is passed through as it is
+// written.
+type envelopeResponseWriter struct {
+	gin.ResponseWriter
+	buf         bytes.Buffer
+	decided     bool
+	passThrough bool
+}
+
+func (w *envelopeResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		status := w.ResponseWriter.Status()
+		contentType := w.Header().Get("Content-Type")
+		w.passThrough = status < 200 || status >= 300 || !strings.HasPrefix(contentType, "application/json")
+	}
+	if w.passThrough {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.buf.Write(data)
+}
+
+func (w *envelopeResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *envelopeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush only reaches the client for passed-through responses; a buffered
+// response is sent whole by finish
+func (w *envelopeResponseWriter) Flush() {
+	if w.passThrough {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// finish writes the buffered response inside an envelope
+func (w *envelopeResponseWriter) finish(c *gin.Context) {
+	if w.passThrough || w.buf.Len() == 0 {
+		return
+	}
+
+	envelope := sharewoodapi.Envelope{Data: json.RawMessage(w.buf.Bytes())}
+	if meta, ok := c.Get(envelopeMetaKey); ok {
+		envelope.Meta, _ = meta.(sharewoodapi.EnvelopeMeta)
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		// The buffered body was not valid JSON; send it unchanged
+		w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+	w.Header().Set(sharewoodapi.EnvelopeHeader, "true")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// agentETag derives an entity tag from the stored state of an agent. Any
+// change to the agent's fields yields a different tag; a change in health,
+// which is not stored with the agent, does not.
+func agentETag(agent sharewoodapi.Agent) string {
+	// The content hash is derived from the other fields, and may not be
+	// filled in yet
+	agent.ContentHash = ""
+	agent.Health = ""
+	if agent.Instances != nil {
+		instances := make([]sharewoodapi.AgentInstance, len(agent.Instances))
+		for i, instance := range agent.Instances {
+			instance.Health = ""
+			instances[i] = instance
+		}
+		agent.Instances = instances
+	}
+	data, _ := json.Marshal(agent)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// storedAgent returns agent as reads report it once registered under
+// serviceID, by passing its registration through serviceToAgent as Consul
+// would hand it back. Responses to updates report this form, so the ETag they
+// carry matches the one the next read or conditional update computes: the
+// stored agent differs from the one sent in tag order, derived fields and
+// defaults, and timestamp precision.
+func storedAgent(serviceID string, agent sharewoodapi.Agent) sharewoodapi.Agent {
+	registration := agentRegistration(agent)
+	weights := api.AgentWeights{Passing: 1, Warning: 1}
+	if registration.Weights != nil {
+		weights = *registration.Weights
+	}
+	return serviceToAgent(&api.AgentService{
+		ID:      serviceID,
+		Service: registration.Name,
+		Tags:    registration.Tags,
+		Meta:    registration.Meta,
+		Weights: weights,
+	})
+}
+
+// checkIfMatch enforces the If-Match request header against the current state
+// of an agent. Requests without the header are allowed through. On mismatch
+// it responds with 412 Precondition Failed and returns false.
+func checkIfMatch(c *gin.Context, current sharewoodapi.Agent) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+
+	etag := agentETag(current)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	c.Header("ETag", etag)
+	respondError(c, http.StatusPreconditionFailed, sharewoodapi.ErrorResponse{
+		Error:   "Precondition failed",
+		Details: "The agent has changed since it was read; fetch it again and retry",
+	})
+	return false
+}
+
+// lockConditionalUpdate serializes conditional updates of an agent across
+// servers. A request carrying If-Match holds the agent's lock from the read
+// its ETag is checked against until its write is done, so of two writers
+// holding the same ETag only the first succeeds. A request finding the lock
+// held is answered with 412, as the agent is being changed. It returns the
+// release function, or false if it responded.
+func lockConditionalUpdate(c *gin.Context, serviceID string) (func(), bool) {
+	release, acquired, err := lockAgentName(c.Request.Context(), serviceID)
+	if err != nil {
+		log.Printf("Error locking agent for a conditional update: %v", err)
+		respondConsulError(c, "Failed to lock agent", err)
+		return nil, false
+	}
+	if !acquired {
+		respondError(c, http.StatusPreconditionFailed, sharewoodapi.ErrorResponse{
+			Error:   "Precondition failed",
+			Details: "The agent is being changed by another request; fetch it again and retry",
+		})
+		return nil, false
+	}
+	return release, true
+}
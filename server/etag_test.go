@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSecondWriterGetsPreconditionFailed(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("geo"))
+
+	read := serve(router, http.MethodGet, "/api/v1/agents/geo", nil, nil)
+	etag := read.Header().Get("ETag")
+	if read.Code != http.StatusOK || etag == "" {
+		t.Fatalf("get: got %d with ETag %q", read.Code, etag)
+	}
+
+	// Both writers read the same state; the first to write wins
+	first := serve(router, http.MethodPatch, "/api/v1/agents/geo", map[string]interface{}{"description": "First"}, http.Header{"If-Match": {etag}})
+	if first.Code != http.StatusOK {
+		t.Fatalf("first writer: got %d: %s", first.Code, first.Body)
+	}
+	second := serve(router, http.MethodPatch, "/api/v1/agents/geo", map[string]interface{}{"description": "Second"}, http.Header{"If-Match": {etag}})
+	if second.Code != http.StatusPreconditionFailed {
+		t.Fatalf("second writer: got %d, want 412: %s", second.Code, second.Body)
+	}
+
+	// The ETag returned by an update is the one the stored agent now has, so
+	// the first writer can update again without reading first
+	updated := first.Header().Get("ETag")
+	if reread := serve(router, http.MethodGet, "/api/v1/agents/geo", nil, nil); reread.Header().Get("ETag") != updated {
+		t.Fatalf("update returned ETag %s, but the agent now reads as %s", updated, reread.Header().Get("ETag"))
+	}
+	again := serve(router, http.MethodPatch, "/api/v1/agents/geo", map[string]interface{}{"tags": []string{"z", "a"}}, http.Header{"If-Match": {updated}})
+	if again.Code != http.StatusOK {
+		t.Fatalf("update with the returned ETag: got %d: %s", again.Code, again.Body)
+	}
+	if reread := serve(router, http.MethodGet, "/api/v1/agents/geo", nil, nil); reread.Header().Get("ETag") != again.Header().Get("ETag") {
+		t.Fatal("the ETag returned after reordering tags does not match the stored agent")
+	}
+}
+
+func TestConcurrentWritersWithTheSameETag(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("geo"))
+	etag := serve(router, http.MethodGet, "/api/v1/agents/geo", nil, nil).Header().Get("ETag")
+
+	// Slow the write down so the writers overlap between the If-Match check
+	// and the registration
+	consul.onRequest = func(r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/agent/service/register") {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	const writers = 8
+	codes := make(chan int, writers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			changes := map[string]interface{}{"description": fmt.Sprintf("Writer %d", i)}
+			codes <- serve(router, http.MethodPatch, "/api/v1/agents/geo", changes, http.Header{"If-Match": {etag}}).Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusPreconditionFailed:
+		default:
+			t.Errorf("got status %d, want 200 or 412", code)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d writers succeeded, want exactly 1", succeeded)
+	}
+}
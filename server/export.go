@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Export endpoint - returns every agent with all the fields needed to
+// recreate it, including TTL and expiration, ordered by name. With
+// stream=ndjson the agents are streamed one per line as they are converted,
+// so a backup of a large registry is never held in memory as one document;
+// each line is an agent as accepted by the import endpoint.
+func exportAgents(c *gin.Context) {
+	stream, ok := streamRequested(c)
+	if !ok {
+		return
+	}
+
+	byKey, err := agentServicesByKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Error exporting agents: %v", err)
+		respondConsulError(c, "Failed to export agents", err)
+		return
+	}
+
+	services := make([]*api.AgentService, 0, len(byKey))
+	for _, service := range byKey {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Service != services[j].Service {
+			return services[i].Service < services[j].Service
+		}
+		return services[i].Meta["environment"] < services[j].Meta["environment"]
+	})
+
+	if stream {
+		streamAgentsNDJSON(c, len(services), func(i int) sharewoodapi.Agent { return serviceToAgent(services[i]) })
+		return
+	}
+
+	agents := make([]sharewoodapi.Agent, 0, len(services))
+	for _, service := range services {
+		agents = append(agents, serviceToAgent(service))
+	}
+
+	c.JSON(http.StatusOK, sharewoodapi.AgentList{
+		Agents: agents,
+	})
+}
+
+// Import endpoint - registers a set of agents, reporting the outcome for each.
+// Existing agents are left alone unless overwrite is set.
+func importAgents(c *gin.Context) {
+	var request sharewoodapi.ImportRequest
+	if !decodeJSONBody(c, &request) {
+		return
+	}
+
+	services, err := agentServicesByKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Error importing agents: %v", err)
+		respondConsulError(c, "Failed to import agents", err)
+		return
+	}
+
+	results := make([]sharewoodapi.BatchResult, 0, len(request.Agents))
+	for _, agent := range request.Agents {
+		results = append(results, withErrorCode(importAgent(c.Request.Context(), agent, services[agentInstanceServiceID(agent.Name, agent.Environment, agent.InstanceID)], request.Overwrite)))
+	}
+
+	c.JSON(http.StatusOK, sharewoodapi.BatchResponse{
+		Results: results,
+	})
+}
+
+// importAgent registers a single imported agent. existing is the service
+// currently registered under the agent's name, if any.
+func importAgent(ctx context.Context, agent sharewoodapi.Agent, existing *api.AgentService, overwrite bool) sharewoodapi.BatchResult {
+	result := sharewoodapi.BatchResult{Name: agent.Name}
+	applyAgentDefaults(&agent)
+
+	if errResp := validateAgent(agent); errResp != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = fmt.Sprintf("%s: %s", errResp.Error, errResp.Details)
+		return result
+	}
+
+	selfID := ""
+	if existing != nil {
+		selfID = existing.ID
+	}
+	contested, holder, err := findNameClaimConflict(ctx, agent.Name, agent.Aliases, agent.Environment, selfID)
+	if err != nil {
+		result.Status = consulErrorStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+	if contested != "" {
+		result.Status = http.StatusConflict
+		result.Code = sharewoodapi.CodeAgentConflict
+		result.Error = fmt.Sprintf("'%s' is already used as a name or alias by agent '%s'", contested, holder)
+		return result
+	}
+
+	// Agents may depend on others later in the same import, so only cycles
+	// and, in strict mode, unknown dependencies are rejected
+	if _, errResp, err := agentDependencyProblems(ctx, agent); err != nil {
+		result.Status = consulErrorStatus(err)
+		result.Error = err.Error()
+		return result
+	} else if errResp != nil {
+		result.Status = http.StatusBadRequest
+		result.Error = fmt.Sprintf("%s: %s", errResp.Error, errResp.Details)
+		return result
+	}
+
+	// Keep the original creation time of agents being restored or replaced
+	if existing != nil {
+		if createdAt := metaTime(existing.Meta, "createdAt"); createdAt != nil {
+			agent.CreatedAt = createdAt
+		}
+	}
+	if agent.CreatedAt == nil {
+		agent.CreatedAt = timestampNow()
+	}
+	agent.UpdatedAt = timestampNow()
+
+	if existing != nil {
+		if !overwrite {
+			result.Status = http.StatusConflict
+			result.Code = sharewoodapi.CodeAgentConflict
+			result.Error = fmt.Sprintf("An agent with the name '%s' is already registered", agent.Name)
+			return result
+		}
+
+		if err := reregisterAgent(ctx, existing.ID, agent); err != nil {
+			log.Printf("Error importing agent %s: %v", agent.Name, err)
+			result.Status = consulErrorStatus(err)
+			result.Error = err.Error()
+			return result
+		}
+		agentChanged(ctx, existing, existing.ID, &agent)
+
+		result.Status = http.StatusOK
+		return result
+	}
+
+	registration := agentRegistration(agent)
+	if err := consulServiceRegister(ctx, registration); err != nil {
+		log.Printf("Error importing agent %s: %v", agent.Name, err)
+		result.Status = consulErrorStatus(err)
+		result.Error = err.Error()
+		return result
+	}
+	agentChanged(ctx, nil, registration.ID, &agent)
+
+	result.Status = http.StatusCreated
+	return result
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStreamedExportRoundTrips(t *testing.T) {
+	_, router := newTestRegistry(t)
+	weather := testAgent("weather")
+	weather.Owner = "forecasts"
+	weather.Aliases = []string{"forecast"}
+	weather.Weight = 3
+	weather.Metadata = map[string]string{"region": "eu"}
+	maps := testAgent("maps")
+	maps.DependsOn = []string{"weather"}
+	maps.Environment = "staging"
+	registerTestAgent(t, router, weather)
+	registerTestAgent(t, router, maps)
+
+	var exported bytes.Buffer
+	if err := newTestClient(t, router).ExportTo(&exported); err != nil {
+		t.Fatalf("ExportTo: %v", err)
+	}
+	if lines := bytes.Count(exported.Bytes(), []byte("\n")); lines != 2 {
+		t.Fatalf("exported %d lines, want 2:\n%s", lines, exported.String())
+	}
+
+	// Import the stream into an empty registry and export it again
+	_, router = newTestRegistry(t)
+	client := newTestClient(t, router)
+	results, err := client.ImportFrom(bytes.NewReader(exported.Bytes()), false)
+	if err != nil {
+		t.Fatalf("ImportFrom: %v", err)
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			t.Fatalf("importing %s: %s", result.Name, result.Error)
+		}
+	}
+	var reexported bytes.Buffer
+	if err := client.ExportTo(&reexported); err != nil {
+		t.Fatalf("ExportTo after import: %v", err)
+	}
+
+	if exported.String() != reexported.String() {
+		t.Fatalf("export changed across import:\n%s\nwant:\n%s", reexported.String(), exported.String())
+	}
+}
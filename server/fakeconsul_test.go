@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeConsul is an in-memory stand-in for the parts of the Consul HTTP API
+// the registry uses: the local agent's services and checks, KV with sessions
+// and transactions, and the catalog service list. It does not enforce ACLs
+// but records the token each request carried.
+type fakeConsul struct {
+	*httptest.Server
+
+	// onRequest, if set, is called before each request is served, e.g. to
+	// hold it until the caller gives up
+	onRequest func(r *http.Request)
+
+	mu       sync.Mutex
+	services map[string]*api.AgentService
+	checks   map[string]*api.AgentCheck
+	kv       map[string]*api.KVPair
+	sessions map[string]bool
+	index    uint64
+	tokens   []string
+}
+
+func newFakeConsul() *fakeConsul {
+	f := &fakeConsul{
+		services: make(map[string]*api.AgentService),
+		checks:   make(map[string]*api.AgentCheck),
+		kv:       make(map[string]*api.KVPair),
+		sessions: make(map[string]bool),
+		index:    1,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/agent/services", f.listServices)
+	mux.HandleFunc("GET /v1/agent/checks", f.listChecks)
+	mux.HandleFunc("GET /v1/agent/self", f.agentSelf)
+	mux.HandleFunc("PUT /v1/agent/service/register", f.registerService)
+	mux.HandleFunc("PUT /v1/agent/service/deregister/{id}", f.deregisterService)
+	mux.HandleFunc("PUT /v1/agent/check/deregister/{id}", f.deregisterCheck)
+	mux.HandleFunc("PUT /v1/agent/check/update/{id}", f.updateCheck)
+	mux.HandleFunc("GET /v1/catalog/services", f.catalogServices)
+	mux.HandleFunc("PUT /v1/session/create", f.createSession)
+	mux.HandleFunc("PUT /v1/session/destroy/{id}", f.destroySession)
+	mux.HandleFunc("GET /v1/kv/{key...}", f.getKV)
+	mux.HandleFunc("PUT /v1/kv/{key...}", f.putKV)
+	mux.HandleFunc("DELETE /v1/kv/{key...}", f.deleteKV)
+	mux.HandleFunc("PUT /v1/txn", f.txn)
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.tokens = append(f.tokens, r.Header.Get("X-Consul-Token"))
+		hook := f.onRequest
+		f.mu.Unlock()
+		if hook != nil {
+			hook(r)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return f
+}
+
+// client returns a Consul API client talking to the fake
+func (f *fakeConsul) client(token string) *api.Client {
+	config := api.DefaultConfig()
+	config.Address = f.URL
+	config.Token = token
+	client, err := api.NewClient(config)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+// requestTokens returns the ACL token sent with each request so far
+func (f *fakeConsul) requestTokens() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.tokens...)
+}
+
+// setCheckStatus sets the status of a check, as Consul does when a TTL lapses
+func (f *fakeConsul) setCheckStatus(checkID, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if check, ok := f.checks[checkID]; ok {
+		check.Status = status
+	}
+}
+
+func (f *fakeConsul) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Consul-Index", fmt.Sprint(f.index))
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeConsul) listServices(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeJSON(w, f.services)
+}
+
+func (f *fakeConsul) listChecks(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeJSON(w, f.checks)
+}
+
+func (f *fakeConsul) agentSelf(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeJSON(w, map[string]map[string]interface{}{
+		"Config": {"Datacenter": "dc1", "Version": "1.20.0"},
+	})
+}
+
+func (f *fakeConsul) registerService(w http.ResponseWriter, r *http.Request) {
+	var registration api.AgentServiceRegistration
+	if err := json.NewDecoder(r.Body).Decode(&registration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if registration.ID == "" {
+		registration.ID = registration.Name
+	}
+	weights := api.AgentWeights{Passing: 1, Warning: 1}
+	if registration.Weights != nil {
+		weights = *registration.Weights
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.services[registration.ID] = &api.AgentService{
+		ID:          registration.ID,
+		Service:     registration.Name,
+		Tags:        registration.Tags,
+		Meta:        registration.Meta,
+		Weights:     weights,
+		CreateIndex: f.index,
+		ModifyIndex: f.index,
+	}
+
+	// Registering replaces the service's checks; new checks start critical
+	// unless given a status
+	for id, check := range f.checks {
+		if check.ServiceID == registration.ID {
+			delete(f.checks, id)
+		}
+	}
+	checks := registration.Checks
+	if registration.Check != nil {
+		checks = append(checks, registration.Check)
+	}
+	for _, check := range checks {
+		checkID := check.CheckID
+		if checkID == "" {
+			checkID = "service:" + registration.ID
+		}
+		status := check.Status
+		if status == "" {
+			status = api.HealthCritical
+		}
+		checkType := "http"
+		if check.TTL != "" {
+			checkType = "ttl"
+		}
+		f.checks[checkID] = &api.AgentCheck{
+			CheckID:     checkID,
+			Name:        check.Name,
+			Status:      status,
+			ServiceID:   registration.ID,
+			ServiceName: registration.Name,
+			Type:        checkType,
+		}
+	}
+}
+
+func (f *fakeConsul) deregisterService(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.services[id]; !ok {
+		http.Error(w, "Unknown service ID "+id, http.StatusNotFound)
+		return
+	}
+	f.index++
+	delete(f.services, id)
+	for checkID, check := range f.checks {
+		if check.ServiceID == id {
+			delete(f.checks, checkID)
+		}
+	}
+}
+
+func (f *fakeConsul) deregisterCheck(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.checks, r.PathValue("id"))
+}
+
+func (f *fakeConsul) updateCheck(w http.ResponseWriter, r *http.Request) {
+	var update struct{ Status, Output string }
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	check, ok := f.checks[r.PathValue("id")]
+	if !ok {
+		http.Error(w, "Unknown check ID", http.StatusNotFound)
+		return
+	}
+	check.Status = update.Status
+	check.Output = update.Output
+}
+
+func (f *fakeConsul) catalogServices(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	services := make(map[string][]string)
+	for _, service := range f.services {
+		services[service.Service] = service.Tags
+	}
+	f.writeJSON(w, services)
+}
+
+func (f *fakeConsul) createSession(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	id := fmt.Sprintf("session-%d", f.index)
+	f.sessions[id] = true
+	f.writeJSON(w, map[string]string{"ID": id})
+}
+
+func (f *fakeConsul) destroySession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.sessions, id)
+	// Sessions are created with the delete behavior
+	for key, pair := range f.kv {
+		if pair.Session == id {
+			delete(f.kv, key)
+		}
+	}
+	f.writeJSON(w, true)
+}
+
+func (f *fakeConsul) getKV(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	query := r.URL.Query()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if query.Has("keys") {
+		keys := make([]string, 0)
+		for k := range f.kv {
+			if strings.HasPrefix(k, key) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		if len(keys) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		f.writeJSON(w, keys)
+		return
+	}
+
+	pairs := make(api.KVPairs, 0)
+	for k, pair := range f.kv {
+		if k == key || (query.Has("recurse") && strings.HasPrefix(k, key)) {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+	f.writeJSON(w, pairs)
+}
+
+func (f *fakeConsul) putKV(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	query := r.URL.Query()
+	value, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pair := f.kv[key]
+	switch {
+	case query.Has("acquire"):
+		session := query.Get("acquire")
+		if !f.sessions[session] || (pair != nil && pair.Session != "" && pair.Session != session) {
+			f.writeJSON(w, false)
+			return
+		}
+		f.setKV(key, value, session)
+	case query.Has("release"):
+		if pair == nil || pair.Session != query.Get("release") {
+			f.writeJSON(w, false)
+			return
+		}
+		pair.Session = ""
+	default:
+		session := ""
+		if pair != nil {
+			session = pair.Session
+		}
+		f.setKV(key, value, session)
+	}
+	f.writeJSON(w, true)
+}
+
+// setKV stores a value; f.mu must be held
+func (f *fakeConsul) setKV(key string, value []byte, session string) {
+	f.index++
+	pair, ok := f.kv[key]
+	if !ok {
+		pair = &api.KVPair{Key: key, CreateIndex: f.index}
+		f.kv[key] = pair
+	}
+	pair.Value = value
+	pair.Session = session
+	pair.ModifyIndex = f.index
+}
+
+func (f *fakeConsul) deleteKV(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	recurse := r.URL.Query().Has("recurse")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for k := range f.kv {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			delete(f.kv, k)
+		}
+	}
+	f.writeJSON(w, true)
+}
+
+func (f *fakeConsul) txn(w http.ResponseWriter, r *http.Request) {
+	var ops api.TxnOps
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, op := range ops {
+		if op.KV == nil {
+			http.Error(w, "only KV operations are supported", http.StatusBadRequest)
+			return
+		}
+		switch op.KV.Verb {
+		case api.KVSet:
+			f.setKV(op.KV.Key, op.KV.Value, "")
+		case api.KVDelete:
+			delete(f.kv, op.KV.Key)
+		default:
+			http.Error(w, "unsupported verb "+string(op.KV.Verb), http.StatusBadRequest)
+			return
+		}
+	}
+	f.writeJSON(w, api.TxnResponse{})
+}
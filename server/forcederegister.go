@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// forceDeregisterAgent serves DELETE /agents/:name?force=true (admin). It
+// deregisters the agent's service if there still is one, then removes by ID
+// every check left behind for it: checks bound to the service ID and checks
+// with the IDs the registry gives an agent's checks. This cleans up drift
+// such as orphaned checks outliving their service, which a plain
+// deregistration cannot reach.
+func forceDeregisterAgent(c *gin.Context, name string) {
+	if sharewoodapi.Role(c.GetString("role")) != sharewoodapi.RoleAdmin {
+		respondError(c, http.StatusForbidden, sharewoodapi.ErrorResponse{
+			Error:   "Insufficient permissions",
+			Details: "Only an admin can force a deregistration",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	service, err := findAgentInstance(ctx, name, c.Query("env"), c.Query("instance"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+	serviceID := agentInstanceServiceID(name, c.Query("env"), c.Query("instance"))
+	if service != nil {
+		serviceID = service.ID
+	}
+	// A record left without a service would otherwise be restored
+	if err := removeRegistrationRecord(ctx, serviceID); err != nil {
+		log.Printf("Error force-deregistering agent: %v", err)
+		respondConsulError(c, "Failed to unregister agent", err)
+		return
+	}
+	if service != nil {
+		if err := consulServiceDeregister(ctx, serviceID); err != nil {
+			log.Printf("Error force-deregistering agent: %v", err)
+			respondConsulError(c, "Failed to unregister agent", err)
+			return
+		}
+		log.Printf("Force deregistration of %s removed service %s", name, serviceID)
+	}
+
+	// Consul removes a service's checks with it, so whatever is still
+	// there now is orphaned
+	checks, err := consulChecks(ctx)
+	if err != nil {
+		log.Printf("Error reading agent checks: %v", err)
+		respondConsulError(c, "Failed to read agent checks", err)
+		return
+	}
+	removed := make([]string, 0)
+	for _, check := range orphanedChecks(checks, serviceID) {
+		if err := consulCheckDeregister(ctx, check.CheckID); err != nil {
+			log.Printf("Error removing check %s: %v", check.CheckID, err)
+			respondConsulError(c, "Failed to remove agent check", err)
+			return
+		}
+		log.Printf("Force deregistration of %s removed check %s", name, check.CheckID)
+		removed = append(removed, check.CheckID)
+	}
+
+	if service == nil && len(removed) == 0 {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent or check for the name '%s' was found", name),
+		})
+		return
+	}
+	agentChanged(ctx, service, serviceID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Agent force-unregistered successfully",
+		"serviceRemoved": service != nil,
+		"removedChecks":  removed,
+	})
+}
+
+// orphanedChecks returns the checks belonging to serviceID, by their binding
+// to it or by the check IDs agentChecks gives it, ordered by check ID
+func orphanedChecks(checks map[string]*api.AgentCheck, serviceID string) []*api.AgentCheck {
+	orphans := make([]*api.AgentCheck, 0)
+	for id, check := range checks {
+		if check.ServiceID == serviceID || id == ttlCheckID(serviceID) || id == httpCheckID(serviceID) {
+			orphans = append(orphans, check)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].CheckID < orphans[j].CheckID })
+	return orphans
+}
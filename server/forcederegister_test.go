@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestForceDeregisterRemovesOrphanedChecks(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	admin, publisher := roleHeaders(t)
+	if resp := serve(router, http.MethodPost, "/api/v1/agents", testAgent("weather"), admin); resp.Code != http.StatusCreated {
+		t.Fatalf("registering: got %d: %s", resp.Code, resp.Body)
+	}
+
+	// The service is lost but its check lingers
+	consul.mu.Lock()
+	delete(consul.services, "weather")
+	orphans := len(consul.checks)
+	consul.mu.Unlock()
+	if orphans == 0 {
+		t.Fatal("the registration created no checks")
+	}
+
+	if resp := serve(router, http.MethodDelete, "/api/v1/agents/weather?force=true", nil, publisher); resp.Code != http.StatusForbidden {
+		t.Fatalf("force deregistration by a publisher: got %d, want 403: %s", resp.Code, resp.Body)
+	}
+
+	resp := serve(router, http.MethodDelete, "/api/v1/agents/weather?force=true", nil, admin)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("force deregistration: got %d: %s", resp.Code, resp.Body)
+	}
+	var body struct {
+		ServiceRemoved bool
+		RemovedChecks  []string
+	}
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if body.ServiceRemoved || len(body.RemovedChecks) != orphans {
+		t.Errorf("got %s, want %d removed checks and no service", resp.Body, orphans)
+	}
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	if len(consul.checks) != 0 {
+		t.Errorf("checks left after force deregistration: %v", consul.checks)
+	}
+}
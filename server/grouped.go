@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Group Agents endpoint - returns the AI agents grouped by tag, owner or
+// lifecycle (by=tag|owner|lifecycle), for faceted dashboards. An agent
+// appears under each of its tags; agents without tags or an owner are left
+// out of those groupings. The groups come from one read of the agents and
+// omit health. With no agents the result is an empty object.
+func groupAgents(c *gin.Context) {
+	by := c.Query("by")
+	if by != "tag" && by != "owner" && by != "lifecycle" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid by",
+			Details: "by must be one of tag, owner, lifecycle",
+		})
+		return
+	}
+
+	services, err := consulServices(c.Request.Context())
+	if err != nil {
+		log.Printf("Error grouping agents: %v", err)
+		respondConsulError(c, "Failed to group agents", err)
+		return
+	}
+
+	groups := make(map[string][]sharewoodapi.Agent)
+	for _, instances := range groupAgentInstances(services) {
+		agent := instancesToAgent(instances, nil)
+		var keys []string
+		switch by {
+		case "tag":
+			// serviceToAgent already drops the discriminator tag and duplicates
+			keys = agent.Tags
+		case "owner":
+			if agent.Owner != "" {
+				keys = []string{agent.Owner}
+			}
+		case "lifecycle":
+			keys = []string{agent.Lifecycle}
+		}
+		for _, key := range keys {
+			groups[key] = append(groups[key], agent)
+		}
+	}
+	for _, agents := range groups {
+		sortAgents(agents, "name", false)
+	}
+
+	c.JSON(http.StatusOK, groups)
+}
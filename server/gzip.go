@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMiddleware compresses responses for clients that accept gzip. Bodies
+// are buffered up to cfg.GzipMinBytes so small responses, which gain nothing
+// from compression, are sent as they are. Responses that already carry a
+// Content-Encoding, such as compressed metrics, are left alone.
+func gzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.GzipMinBytes < 0 || !acceptsGzip(c.Request) {
+			c.Next()
+			return
+		}
+
+		writer := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		defer writer.finish()
+		c.Header("Vary", "Accept-Encoding")
+
+		c.Next()
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip,
+// i.e. lists it without a zero quality
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+		for _, param := range params[1:] {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the start of a response until it is known
+// whether it is large enough to compress, then either streams it through a
+// gzip writer or writes it unchanged
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	buf     bytes.Buffer
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf.Write(data)
+		if int64(w.buf.Len()) < cfg.GzipMinBytes {
+			return len(data), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController, which
+// extends write deadlines on long responses
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what has been written so far, compressed if the response is
+// being compressed, so streamed responses still reach the client promptly
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide chooses whether to compress, based on the body buffered so far,
+// and writes the buffer out accordingly
+func (w *gzipResponseWriter) decide() error {
+	w.decided = true
+	header := w.Header()
+	if int64(w.buf.Len()) >= cfg.GzipMinBytes && w.buf.Len() > 0 && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+		_, err := w.gz.Write(w.buf.Bytes())
+		w.buf.Reset()
+		return err
+	}
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish writes out a response that stayed below the threshold, or ends
+// the gzip stream of a compressed one
+func (w *gzipResponseWriter) finish() {
+	if !w.decided {
+		if w.buf.Len() == 0 {
+			return
+		}
+		w.decide()
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestLargeResponsesAreGzipped(t *testing.T) {
+	_, router := newTestRegistry(t)
+	for i := 0; i < 30; i++ {
+		registerTestAgent(t, router, testAgent(fmt.Sprintf("agent-%02d", i)))
+	}
+
+	// Record how each response was encoded on the wire; the client's
+	// transport removes Content-Encoding once it has decompressed a body
+	var mu sync.Mutex
+	encodings := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r)
+		mu.Lock()
+		encodings[r.URL.Path] = w.Header().Get("Content-Encoding")
+		mu.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	options := sharewoodapi.DefaultOptions()
+	options.ServerURL = server.URL
+	client := sharewoodapi.NewClient(options)
+	t.Cleanup(func() { client.Close() })
+
+	agents, err := client.ListAgents()
+	if err != nil || len(agents) != 30 {
+		t.Fatalf("ListAgents: got %d agents, %v", len(agents), err)
+	}
+	if _, err := client.GetAgent("agent-00"); err != nil {
+		t.Fatalf("GetAgent: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if encodings["/api/v1/agents"] != "gzip" {
+		t.Errorf("the list was sent with Content-Encoding %q, want gzip", encodings["/api/v1/agents"])
+	}
+	if encodings["/api/v1/agents/agent-00"] != "" {
+		t.Errorf("a small response was sent with Content-Encoding %q", encodings["/api/v1/agents/agent-00"])
+	}
+}
+
+func TestResponsesAreUncompressedUnlessAccepted(t *testing.T) {
+	_, router := newTestRegistry(t)
+	for i := 0; i < 30; i++ {
+		registerTestAgent(t, router, testAgent(fmt.Sprintf("agent-%02d", i)))
+	}
+
+	resp := serve(router, http.MethodGet, "/api/v1/agents", nil, nil)
+	if encoding := resp.Header().Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("got Content-Encoding %q without Accept-Encoding", encoding)
+	}
+
+	resp = serve(router, http.MethodGet, "/api/v1/agents", nil, http.Header{"Accept-Encoding": {"br;q=1.0, gzip;q=0.5"}})
+	if resp.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got Content-Encoding %q, want gzip", resp.Header().Get("Content-Encoding"))
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("the body is not gzip: %v", err)
+	}
+	var agents []sharewoodapi.Agent
+	if err := json.NewDecoder(gz).Decode(&agents); err != nil || len(agents) != 30 {
+		t.Fatalf("decoding the gzipped list: got %d agents, %v", len(agents), err)
+	}
+
+	if resp := serve(router, http.MethodGet, "/api/v1/agents", nil, http.Header{"Accept-Encoding": {"gzip;q=0"}}); resp.Header().Get("Content-Encoding") != "" {
+		t.Errorf("gzip;q=0 was answered with Content-Encoding %q", resp.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Largest number of updates accepted by one batch health request
+const maxHealthBatchUpdates = 500
+
+// Batch Health endpoint - applies several TTL check updates in one request.
+// Each update is validated and applied on its own; failures are reported in
+// its result and do not stop the remaining updates.
+func updateHealthBatch(c *gin.Context) {
+	var request sharewoodapi.HealthBatchRequest
+	if !decodeJSONBody(c, &request) {
+		return
+	}
+
+	if len(request.Updates) > maxHealthBatchUpdates {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Too many updates",
+			Details: fmt.Sprintf("A batch health request accepts at most %d updates", maxHealthBatchUpdates),
+		})
+		return
+	}
+
+	services, err := agentServicesByKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Error updating agent health: %v", err)
+		respondConsulError(c, "Failed to update agent health", err)
+		return
+	}
+
+	results := make([]sharewoodapi.BatchResult, 0, len(request.Updates))
+	for _, update := range request.Updates {
+		result := sharewoodapi.BatchResult{Name: update.Name}
+
+		service, ok := services[agentInstanceServiceID(update.Name, update.Environment, update.InstanceID)]
+		switch {
+		case !sharewoodapi.IsValidHealthStatus(update.Status):
+			result.Status = http.StatusBadRequest
+			result.Error = "Invalid status. Must be 'passing', 'warning', or 'critical'"
+		case !ok:
+			result.Status = http.StatusNotFound
+			result.Code = sharewoodapi.CodeAgentNotFound
+			result.Error = "Agent not found"
+		default:
+			if err := consulUpdateTTL(c.Request.Context(), "service:"+service.ID, "", string(update.Status)); err != nil {
+				log.Printf("Error updating health of agent %s: %v", service.ID, err)
+				result.Status = consulErrorStatus(err)
+				result.Error = err.Error()
+			} else {
+				recordHealthUpdate(service.ID)
+				result.Status = http.StatusOK
+			}
+		}
+
+		results = append(results, withErrorCode(result))
+	}
+
+	c.JSON(http.StatusOK, sharewoodapi.BatchResponse{
+		Results: results,
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Health Summary endpoint - rolls the health of every agent up into counts
+// and the percentage passing, for status pages and alerting. It costs one
+// read of the services and one of the checks however many agents there are.
+// Agents in maintenance count as critical, since they are not serving, and
+// agents without a TTL check count as passing, as everywhere else. A scaled
+// agent counts once, as healthy as its healthiest instance.
+func agentsHealthSummary(c *gin.Context) {
+	services, err := consulServices(c.Request.Context())
+	if err != nil {
+		log.Printf("Error summarizing agent health: %v", err)
+		respondConsulError(c, "Failed to summarize agent health", err)
+		return
+	}
+
+	statuses, err := agentHealthStatuses(c.Request.Context())
+	if err != nil {
+		log.Printf("Error summarizing agent health: %v", err)
+		respondConsulError(c, "Failed to summarize agent health", err)
+		return
+	}
+
+	var summary sharewoodapi.HealthSummary
+	for _, instances := range groupAgentInstances(services) {
+		summary.Total++
+		switch instancesToAgent(instances, statuses).Health {
+		case sharewoodapi.HealthPassing:
+			summary.Passing++
+		case sharewoodapi.HealthWarning:
+			summary.Warning++
+		default:
+			summary.Critical++
+		}
+	}
+
+	// An empty registry has nothing unhealthy in it
+	summary.PercentHealthy = 100
+	if summary.Total > 0 {
+		summary.PercentHealthy = math.Round(float64(summary.Passing)/float64(summary.Total)*10000) / 100
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Largest value Consul accepts for a KV entry
+const maxConsulKVValueBytes = 512 << 10
+
+// Route of the agent icon endpoints, relative to the API base path. Icon
+// uploads are the one request body that is not JSON.
+const agentIconRoute = "/agents/:name/icon"
+
+// Uploaded icons are stored in Consul KV under
+//
+//	<KV_PREFIX>/icons/<service ID>
+//
+// holding the image itself. The KV entry's flags record the content type, as
+// the position in iconContentTypes plus one.
+var iconContentTypes = []string{"image/png", "image/jpeg", "image/svg+xml"}
+
+func iconKey(serviceID string) string {
+	return cfg.KVPrefix + "/icons/" + url.PathEscape(serviceID)
+}
+
+// iconFlags returns the KV flags recording contentType, or 0 if it is not an
+// accepted icon type
+func iconFlags(contentType string) uint64 {
+	for i, t := range iconContentTypes {
+		if t == contentType {
+			return uint64(i + 1)
+		}
+	}
+	return 0
+}
+
+// iconMatchesContentType checks that an image's bytes are what its declared
+// content type claims, so a mislabelled upload is not served as an image
+func iconMatchesContentType(data []byte, contentType string) bool {
+	if contentType == "image/svg+xml" {
+		return bytes.Contains(data, []byte("<svg"))
+	}
+	return http.DetectContentType(data) == contentType
+}
+
+// deleteAgentIcon removes the uploaded icon of a deregistered agent, if any
+func deleteAgentIcon(ctx context.Context, serviceID string) {
+	if err := consulKVDelete(ctx, iconKey(serviceID)); err != nil {
+		log.Printf("Error removing icon for %s: %v", serviceID, err)
+	}
+}
+
+// Put Agent Icon endpoint - stores a small PNG, JPEG or SVG image for an
+// agent, sent as the raw request body with its Content-Type. The image
+// replaces any previous upload and is removed with the agent.
+func putAgentIcon(c *gin.Context) {
+	name := c.Param("name")
+
+	mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if err != nil || iconFlags(mediaType) == 0 {
+		respondError(c, http.StatusUnsupportedMediaType, sharewoodapi.ErrorResponse{
+			Error:   "Unsupported icon type",
+			Details: "Icons must be sent with Content-Type image/png, image/jpeg or image/svg+xml",
+		})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, cfg.MaxIconBytes+1))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || int64(len(data)) > cfg.MaxIconBytes {
+		respondError(c, http.StatusRequestEntityTooLarge, sharewoodapi.ErrorResponse{
+			Error:   "Icon too large",
+			Details: fmt.Sprintf("Icons are limited to %d bytes", min(cfg.MaxIconBytes, cfg.MaxBodyBytes)),
+		})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid request body",
+			Details: err.Error(),
+		})
+		return
+	}
+	if len(data) == 0 || !iconMatchesContentType(data, mediaType) {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid icon",
+			Details: fmt.Sprintf("The request body is not a %s image", mediaType),
+		})
+		return
+	}
+
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	pair := &api.KVPair{Key: iconKey(service.ID), Flags: iconFlags(mediaType), Value: data}
+	if err := consulKVPut(c.Request.Context(), pair); err != nil {
+		log.Printf("Error storing agent icon: %v", err)
+		respondConsulError(c, "Failed to store agent icon", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Agent icon updated successfully"})
+}
+
+// Get Agent Icon endpoint - serves the image uploaded for an agent. The agent
+// may be named by an alias. SVG icons are served with a content security
+// policy that keeps any script in them from running.
+func getAgentIcon(c *gin.Context) {
+	name := c.Param("name")
+
+	service, err := resolveAgentService(c.Request.Context(), name, c.Query("env"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	pair, err := consulKVGet(c.Request.Context(), iconKey(service.ID))
+	if err != nil {
+		log.Printf("Error reading agent icon: %v", err)
+		respondConsulError(c, "Failed to read agent icon", err)
+		return
+	}
+	if pair == nil || pair.Flags == 0 || pair.Flags > uint64(len(iconContentTypes)) {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Error:   "Icon not found",
+			Details: fmt.Sprintf("No icon has been uploaded for agent '%s'", name),
+		})
+		return
+	}
+
+	contentType := iconContentTypes[pair.Flags-1]
+	c.Header("X-Content-Type-Options", "nosniff")
+	if contentType == "image/svg+xml" {
+		c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	}
+	c.Data(http.StatusOK, contentType, pair.Value)
+}
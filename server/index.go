@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Consul limits a transaction to 64 operations
+const maxTxnOps = 64
+
+// The KV index maps tags and owners to the IDs of the agent services that
+// carry them, one empty key per pair:
+//
+//	<KV_PREFIX>/index/tags/<tag>/<service ID>
+//	<KV_PREFIX>/index/owners/<owner>/<service ID>
+//
+// Each path segment is escaped. The index is maintained only when KV_INDEX is
+// enabled, and list filters fall back to a full scan if it cannot be read.
+func indexPrefix() string {
+	return cfg.KVPrefix + "/index/"
+}
+
+// agentIndexKeys returns the index keys for an agent registered under serviceID
+func agentIndexKeys(serviceID string, agent sharewoodapi.Agent) map[string]bool {
+	keys := make(map[string]bool)
+	id := url.PathEscape(serviceID)
+	for _, tag := range agent.Tags {
+		keys[indexPrefix()+"tags/"+url.PathEscape(tag)+"/"+id] = true
+	}
+	if agent.Owner != "" {
+		keys[indexPrefix()+"owners/"+url.PathEscape(agent.Owner)+"/"+id] = true
+	}
+	return keys
+}
+
+// updateAgentIndex brings the KV index in line with a change to one agent.
+// previous is the service before the change, nil for a new agent; agent is
+// the agent after it, nil for a deregistration. Failures are logged rather
+// than returned, since the change itself has already been applied; the
+// reindex endpoint repairs any drift.
+func updateAgentIndex(ctx context.Context, previous *api.AgentService, serviceID string, agent *sharewoodapi.Agent) {
+	if !cfg.KVIndex {
+		return
+	}
+
+	oldKeys := make(map[string]bool)
+	if previous != nil {
+		oldKeys = agentIndexKeys(previous.ID, serviceToAgent(previous))
+	}
+	newKeys := make(map[string]bool)
+	if agent != nil {
+		newKeys = agentIndexKeys(serviceID, *agent)
+	}
+
+	ops := make(api.TxnOps, 0)
+	for key := range oldKeys {
+		if !newKeys[key] {
+			ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVDelete, Key: key}})
+		}
+	}
+	for key := range newKeys {
+		if !oldKeys[key] {
+			ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVSet, Key: key, Value: []byte{}}})
+		}
+	}
+
+	if err := applyIndexOps(ctx, ops); err != nil {
+		log.Printf("Error updating agent index for %s: %v", serviceID, err)
+	}
+}
+
+// applyIndexOps runs index changes in Consul transactions. Changes to one
+// agent fit a single transaction unless it has more than 64 tags.
+func applyIndexOps(ctx context.Context, ops api.TxnOps) error {
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxTxnOps {
+			n = maxTxnOps
+		}
+		if err := consulKVTxn(ctx, ops[:n]); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// indexedServiceIDs looks up the services carrying tag and owned by owner in
+// the KV index. An empty tag or owner does not restrict the result; at least
+// one must be set.
+func indexedServiceIDs(ctx context.Context, tag, owner string) (map[string]bool, error) {
+	var result map[string]bool
+	lookups := []struct{ kind, value string }{{"tags", tag}, {"owners", owner}}
+	for _, lookup := range lookups {
+		if lookup.value == "" {
+			continue
+		}
+
+		prefix := indexPrefix() + lookup.kind + "/" + url.PathEscape(lookup.value) + "/"
+		keys, err := consulKVKeys(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read agent index: %w", err)
+		}
+
+		ids := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			id, err := url.PathUnescape(strings.TrimPrefix(key, prefix))
+			if err != nil {
+				continue
+			}
+			if result == nil || result[id] {
+				ids[id] = true
+			}
+		}
+		result = ids
+	}
+	return result, nil
+}
+
+// Reindex endpoint - rebuilds the KV index from the registered agents,
+// recovering from drift such as changes made while the index was disabled
+func reindexAgents(c *gin.Context) {
+	if !cfg.KVIndex {
+		respondError(c, http.StatusConflict, sharewoodapi.ErrorResponse{
+			Error:   "Index disabled",
+			Details: "Set KV_INDEX=true to maintain the agent index",
+		})
+		return
+	}
+
+	services, err := agentServicesByKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Error rebuilding agent index: %v", err)
+		respondConsulError(c, "Failed to rebuild agent index", err)
+		return
+	}
+
+	wanted := make(map[string]bool)
+	for _, service := range services {
+		for key := range agentIndexKeys(service.ID, serviceToAgent(service)) {
+			wanted[key] = true
+		}
+	}
+	existing, err := consulKVKeys(c.Request.Context(), indexPrefix())
+	if err != nil {
+		log.Printf("Error rebuilding agent index: %v", err)
+		respondConsulError(c, "Failed to rebuild agent index", err)
+		return
+	}
+
+	// Only the missing and stale entries are written, so list filters keep
+	// finding every correctly indexed agent while the rebuild runs, and a
+	// failure part way leaves the index no worse than before
+	ops := make(api.TxnOps, 0)
+	found := make(map[string]bool, len(existing))
+	for _, key := range existing {
+		found[key] = true
+		if !wanted[key] {
+			ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVDelete, Key: key}})
+		}
+	}
+	for key := range wanted {
+		if !found[key] {
+			ops = append(ops, &api.TxnOp{KV: &api.KVTxnOp{Verb: api.KVSet, Key: key, Value: []byte{}}})
+		}
+	}
+	if err := applyIndexOps(c.Request.Context(), ops); err != nil {
+		log.Printf("Error rebuilding agent index: %v", err)
+		respondConsulError(c, "Failed to rebuild agent index", err)
+		return
+	}
+
+	log.Printf("Agent index rebuilt by %s: %d agents, %d entries, %d changed", c.GetString("role"), len(services), len(wanted), len(ops))
+	c.JSON(http.StatusOK, sharewoodapi.ReindexResult{
+		Agents:  len(services),
+		Entries: len(wanted),
+	})
+}
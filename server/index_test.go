@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// BenchmarkTagFilter filters 2000 agents by a tag carried by 40 of them,
+// scanning every agent and through the KV index
+func BenchmarkTagFilter(b *testing.B) {
+	b.Setenv("KV_INDEX", "true")
+	_, router := newBenchmarkRegistry(b, 2000)
+
+	for _, indexed := range []bool{false, true} {
+		name := "scan"
+		if indexed {
+			name = "index"
+		}
+		b.Run(name, func(b *testing.B) {
+			cfg.KVIndex = indexed
+			for i := 0; i < b.N; i++ {
+				resp := serve(router, http.MethodGet, "/api/v1/agents?tag=team-7", nil, nil)
+				if resp.Code != http.StatusOK {
+					b.Fatalf("got %d: %s", resp.Code, resp.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestReindexRepairsOnlyDrift(t *testing.T) {
+	t.Setenv("KV_INDEX", "true")
+	consul, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+	registerTestAgent(t, router, testAgent("maps"))
+
+	keep := indexPrefix() + "tags/geo/weather"
+	missing := indexPrefix() + "tags/geo/maps"
+	stale := indexPrefix() + "tags/geo/retired"
+	consul.mu.Lock()
+	kept := consul.kv[keep].ModifyIndex
+	delete(consul.kv, missing)
+	consul.setKV(stale, nil, "")
+	consul.mu.Unlock()
+
+	resp := serve(router, http.MethodPost, "/api/v1/admin/reindex", nil, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("reindex: got %d: %s", resp.Code, resp.Body)
+	}
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	if consul.kv[missing] == nil {
+		t.Errorf("missing entry %s was not restored", missing)
+	}
+	if consul.kv[stale] != nil {
+		t.Errorf("stale entry %s was not removed", stale)
+	}
+	// Correct entries are never cleared, so lists keep finding them
+	if consul.kv[keep] == nil || consul.kv[keep].ModifyIndex != kept {
+		t.Errorf("entry %s was rewritten", keep)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// A horizontally scaled agent registers each of its instances with an
+// instance ID. Every instance is a Consul service of its own, with the ID
+//
+//	<agentServiceID>#<instance ID>
+//
+// and the instance ID in its instanceId meta, sharing the agent's name and
+// environment. Reads report the instances together as one logical agent.
+
+// errInstanceRequired is returned when a request addresses a single service
+// of an agent that is registered as several instances without naming one
+var errInstanceRequired = errors.New("the agent is registered as several instances; select one with the instance query parameter")
+
+// agentInstanceServiceID returns the Consul service ID of one instance of an
+// agent. Without an instance ID it is the agent's own service ID.
+func agentInstanceServiceID(name, environment, instanceID string) string {
+	if instanceID == "" {
+		return agentServiceID(name, environment)
+	}
+	return agentServiceID(name, environment) + "#" + instanceID
+}
+
+// findAgentInstances returns the services registered for the agent with the
+// given name in the given environment, ordered by service ID. An agent
+// registered without an instance ID has exactly one.
+func findAgentInstances(ctx context.Context, name, environment string) ([]*api.AgentService, error) {
+	services, err := consulServices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up agent: %w", err)
+	}
+
+	instances := make([]*api.AgentService, 0)
+	for _, service := range services {
+		if service.Service == name && service.Meta["environment"] == environment && isAIAgent(service) {
+			instances = append(instances, service)
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	return instances, nil
+}
+
+// findAgentInstance looks up the single service a request about an agent
+// acts on. instanceID selects one instance of a scaled agent and must be
+// given for such agents, which otherwise yield errInstanceRequired. Returns
+// nil if no such agent or instance is registered.
+func findAgentInstance(ctx context.Context, name, environment, instanceID string) (*api.AgentService, error) {
+	instances, err := findAgentInstances(ctx, name, environment)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range instances {
+		if service.Meta["instanceId"] == instanceID {
+			return service, nil
+		}
+	}
+	if instanceID == "" && len(instances) > 0 {
+		return nil, errInstanceRequired
+	}
+	return nil, nil
+}
+
+// groupAgentInstances groups AI agent services by the logical agent they
+// belong to, keyed by agentServiceID of its name and environment, each group
+// ordered by service ID
+func groupAgentInstances(services map[string]*api.AgentService) map[string][]*api.AgentService {
+	groups := make(map[string][]*api.AgentService)
+	for _, service := range services {
+		if isAIAgent(service) {
+			key := agentServiceID(service.Service, service.Meta["environment"])
+			groups[key] = append(groups[key], service)
+		}
+	}
+	for _, group := range groups {
+		sort.Slice(group, func(i, j int) bool { return group[i].ID < group[j].ID })
+	}
+	return groups
+}
+
+// instancesToAgent builds the logical agent registered as the given services,
+// as returned by findAgentInstances. A scaled agent takes its definition from
+// its most recently updated instance and lists every instance with its own
+// base URL and health; the agent is as healthy as its healthiest instance.
+// Health is left out when statuses is nil.
+func instancesToAgent(instances []*api.AgentService, statuses map[string]sharewoodapi.HealthStatus) sharewoodapi.Agent {
+	if len(instances) == 1 && instances[0].Meta["instanceId"] == "" {
+		agent := serviceToAgent(instances[0])
+		if statuses != nil {
+			agent.Health = serviceHealth(statuses, instances[0].ID)
+		}
+		return agent
+	}
+
+	var agent sharewoodapi.Agent
+	var latest *api.AgentService
+	for _, service := range instances {
+		instance := serviceToAgent(service)
+		if latest == nil || timestampAfter(instance.UpdatedAt, metaTime(latest.Meta, "updatedAt")) {
+			latest = service
+			agent = instance
+		}
+	}
+	agent.InstanceID = ""
+	agent.Health = ""
+
+	agent.Instances = make([]sharewoodapi.AgentInstance, 0, len(instances))
+	for _, service := range instances {
+		instance := sharewoodapi.AgentInstance{
+			ID:      service.Meta["instanceId"],
+			BaseURL: service.Meta["baseurl"],
+		}
+		if statuses != nil {
+			instance.Health = serviceHealth(statuses, service.ID)
+			if agent.Health == "" || healthSeverity[instance.Health] < healthSeverity[agent.Health] {
+				agent.Health = instance.Health
+			}
+		}
+		agent.Instances = append(agent.Instances, instance)
+	}
+
+	agent.ContentHash = sharewoodapi.AgentContentHash(agent)
+	return agent
+}
+
+// timestampAfter reports whether a is later than b, where a missing time is
+// earlier than any other
+func timestampAfter(a, b *time.Time) bool {
+	if a == nil {
+		return false
+	}
+	return b == nil || a.After(*b)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// registerTestInstances registers two instances, a and b, of one agent
+func registerTestInstances(t *testing.T, router http.Handler, name string) {
+	for _, instance := range []string{"a", "b"} {
+		agent := testAgent(name)
+		agent.InstanceID = instance
+		agent.BaseURL = "http://" + instance + ".example.com"
+		registerTestAgent(t, router, agent)
+	}
+}
+
+// setInstanceHealth sets the status of every check of one instance
+func setInstanceHealth(consul *fakeConsul, instance, status string) {
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	for _, check := range consul.checks {
+		if consul.services[check.ServiceID].Meta["instanceId"] == instance {
+			check.Status = status
+		}
+	}
+}
+
+func TestInstancesAreOneLogicalAgent(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	registerTestInstances(t, router, "weather")
+	setInstanceHealth(consul, "a", api.HealthPassing)
+	setInstanceHealth(consul, "b", api.HealthCritical)
+
+	agent := getTestAgent(t, router, "weather")
+	if len(agent.Instances) != 2 {
+		t.Fatalf("got instances %+v, want a and b", agent.Instances)
+	}
+	if agent.Health != sharewoodapi.HealthPassing {
+		t.Errorf("agent is %s, want passing while one instance is", agent.Health)
+	}
+
+	resp := serve(router, http.MethodGet, "/api/v1/agents", nil, nil)
+	var agents []sharewoodapi.Agent
+	if err := json.Unmarshal(resp.Body.Bytes(), &agents); err != nil || len(agents) != 1 || len(agents[0].Instances) != 2 {
+		t.Fatalf("listing: got %d agents: %s", len(agents), resp.Body)
+	}
+
+	resp = serve(router, http.MethodGet, "/api/v1/health/agents", nil, nil)
+	var summary sharewoodapi.HealthSummary
+	json.Unmarshal(resp.Body.Bytes(), &summary)
+	if summary.Total != 1 || summary.Passing != 1 {
+		t.Errorf("got summary %+v, want one passing agent", summary)
+	}
+}
+
+func TestCriticalAlertsCountLogicalAgents(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	registerTestInstances(t, router, "weather")
+	setInstanceHealth(consul, "a", api.HealthPassing)
+	setInstanceHealth(consul, "b", api.HealthPassing)
+
+	var alerts []criticalAlert
+	watcher := &criticalWatcher{
+		debounce: cfg.CriticalAlertDebounce,
+		alert: func(alert criticalAlert) error {
+			alerts = append(alerts, alert)
+			return nil
+		},
+	}
+	pass := func() {
+		if err := watchCriticalAgents(context.Background(), watcher); err != nil {
+			t.Fatalf("watchCriticalAgents: %v", err)
+		}
+	}
+
+	pass()
+	setInstanceHealth(consul, "a", api.HealthCritical)
+	pass()
+	if len(alerts) != 0 {
+		t.Fatalf("losing one instance raised %d alerts, want none", len(alerts))
+	}
+	setInstanceHealth(consul, "b", api.HealthCritical)
+	pass()
+	if len(alerts) != 1 || alerts[0].Name != "weather" {
+		t.Fatalf("got alerts %+v, want one for weather", alerts)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Media type of RFC 6902 JSON Patch documents
+const jsonPatchMediaType = "application/json-patch+json"
+
+// jsonPatchOp is one RFC 6902 operation as received. Value stays raw so a
+// missing value can be told apart from null.
+type jsonPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// errJSONPatchTestFailed is returned when a test operation does not hold
+var errJSONPatchTestFailed = errors.New("test operation failed")
+
+// checkJSONPatchOps validates the operations of a patch against the agent
+// fields that may change, returning the top-level fields it touches. Test
+// operations may inspect any field, including immutable ones.
+func checkJSONPatchOps(ops []jsonPatchOp) (map[string]interface{}, error) {
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("the patch contains no operations")
+	}
+
+	touched := make(map[string]interface{})
+	for i, op := range ops {
+		paths := []string{op.Path}
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d (%s) requires a value", i, op.Op)
+			}
+		case "remove":
+		case "move", "copy":
+			paths = append(paths, op.From)
+		default:
+			return nil, fmt.Errorf("operation %d has unknown op '%s'", i, op.Op)
+		}
+		if op.Op == "copy" {
+			paths = paths[:1]
+		}
+
+		for _, path := range paths {
+			tokens, err := parseJSONPointer(path)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+			if op.Op == "test" {
+				continue
+			}
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("operation %d cannot replace the whole agent", i)
+			}
+			if tokens[0] == "name" || !patchableFields[tokens[0]] {
+				return nil, fmt.Errorf("operation %d: field '%s' cannot be changed", i, tokens[0])
+			}
+			touched[tokens[0]] = true
+		}
+	}
+	return touched, nil
+}
+
+// applyJSONPatch applies RFC 6902 operations to the agent's JSON form and
+// returns the resulting document, ready for fieldsToAgent
+func applyJSONPatch(agent sharewoodapi.Agent, ops []jsonPatchOp) (map[string]interface{}, error) {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode agent: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to decode agent: %w", err)
+	}
+
+	for i, op := range ops {
+		doc, err = applyJSONPatchOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	fields, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the patched agent is not a JSON object")
+	}
+	return fields, nil
+}
+
+func applyJSONPatchOp(doc interface{}, op jsonPatchOp) (interface{}, error) {
+	path, _ := parseJSONPointer(op.Path)
+
+	var value interface{}
+	if op.Value != nil {
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid value: %w", err)
+		}
+	}
+
+	switch op.Op {
+	case "add", "replace", "remove":
+		return patchJSONNode(doc, path, op.Op, value)
+	case "move", "copy":
+		from, _ := parseJSONPointer(op.From)
+		if op.Op == "move" && isJSONPointerPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("cannot move a value into itself")
+		}
+		moved, err := getJSONNode(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, err = patchJSONNode(doc, from, "remove", nil); err != nil {
+				return nil, err
+			}
+		} else if moved, err = copyJSONValue(moved); err != nil {
+			return nil, err
+		}
+		return patchJSONNode(doc, path, "add", moved)
+	case "test":
+		actual, err := getJSONNode(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return nil, errJSONPatchTestFailed
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unknown op '%s'", op.Op)
+}
+
+// parseJSONPointer splits an RFC 6901 JSON Pointer into its unescaped
+// reference tokens. The empty pointer refers to the whole document.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path '%s' must be empty or start with '/'", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// isJSONPointerPrefix reports whether prefix refers to path or one of its
+// ancestors
+func isJSONPointerPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonArrayIndex parses an array index token. When allowEnd is set, "-"
+// refers to the position after the last element.
+func jsonArrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("'%s' is not a valid array index", token)
+	}
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("array index %d is out of range", index)
+	}
+	return index, nil
+}
+
+// getJSONNode returns the value path refers to
+func getJSONNode(node interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			child, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member '%s' does not exist", token)
+			}
+			node = child
+		case []interface{}:
+			index, err := jsonArrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			node = container[index]
+		default:
+			return nil, fmt.Errorf("'%s' does not refer into an object or array", token)
+		}
+	}
+	return node, nil
+}
+
+// patchJSONNode adds, replaces or removes the value path refers to within
+// node, returning the updated node. Arrays grow and shrink, so the updated
+// node replaces the old one in its parent.
+func patchJSONNode(node interface{}, path []string, op string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		if op == "remove" {
+			return nil, fmt.Errorf("cannot remove the whole agent")
+		}
+		return value, nil
+	}
+
+	token := path[0]
+	switch container := node.(type) {
+	case map[string]interface{}:
+		current, exists := container[token]
+		if len(path) > 1 {
+			if !exists {
+				return nil, fmt.Errorf("member '%s' does not exist", token)
+			}
+			child, err := patchJSONNode(current, path[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			container[token] = child
+			return container, nil
+		}
+		if op != "add" && !exists {
+			return nil, fmt.Errorf("member '%s' does not exist", token)
+		}
+		if op == "remove" {
+			delete(container, token)
+		} else {
+			container[token] = value
+		}
+		return container, nil
+
+	case []interface{}:
+		if len(path) > 1 {
+			index, err := jsonArrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			child, err := patchJSONNode(container[index], path[1:], op, value)
+			if err != nil {
+				return nil, err
+			}
+			container[index] = child
+			return container, nil
+		}
+		index, err := jsonArrayIndex(token, len(container), op == "add")
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "add":
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+		case "replace":
+			container[index] = value
+		default:
+			container = append(container[:index], container[index+1:]...)
+		}
+		return container, nil
+	}
+	return nil, fmt.Errorf("'%s' does not refer into an object or array", token)
+}
+
+// copyJSONValue deep-copies a decoded JSON value, so a copied value does not
+// share maps or slices with its source
+func copyJSONValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var copied interface{}
+	err = json.Unmarshal(data, &copied)
+	return copied, err
+}
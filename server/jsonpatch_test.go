@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// jsonPatch sends a JSON Patch to the named agent
+func jsonPatch(router http.Handler, name string, ops ...map[string]interface{}) *httptest.ResponseRecorder {
+	return serve(router, http.MethodPatch, "/api/v1/agents/"+name, ops, http.Header{"Content-Type": {jsonPatchMediaType}})
+}
+
+func TestJSONPatchOperations(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	resp := jsonPatch(router, "weather",
+		map[string]interface{}{"op": "test", "path": "/description", "value": "Answers geography questions"},
+		map[string]interface{}{"op": "add", "path": "/tags/-", "value": "forecast"},
+		map[string]interface{}{"op": "add", "path": "/owner", "value": "forecasts"},
+		map[string]interface{}{"op": "replace", "path": "/description", "value": "Forecasts the weather"},
+	)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("add and replace: got %d: %s", resp.Code, resp.Body)
+	}
+	agent := getTestAgent(t, router, "weather")
+	if agent.Owner != "forecasts" || agent.Description != "Forecasts the weather" || !hasTag(agent, "forecast") || !hasTag(agent, "geo") {
+		t.Fatalf("after add and replace: %+v", agent)
+	}
+
+	if resp := jsonPatch(router, "weather", map[string]interface{}{"op": "remove", "path": "/owner"}); resp.Code != http.StatusOK {
+		t.Fatalf("remove: got %d: %s", resp.Code, resp.Body)
+	}
+	if agent := getTestAgent(t, router, "weather"); agent.Owner != "" {
+		t.Fatalf("owner %q was not removed", agent.Owner)
+	}
+}
+
+func TestJSONPatchFailures(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	tests := []struct {
+		name     string
+		op       map[string]interface{}
+		want     int
+		wantCode string
+	}{
+		{"failed test", map[string]interface{}{"op": "test", "path": "/description", "value": "Something else"}, http.StatusConflict, sharewoodapi.CodePatchTestFailed},
+		{"missing target", map[string]interface{}{"op": "replace", "path": "/metadata/region", "value": "eu"}, http.StatusBadRequest, ""},
+		{"relative path", map[string]interface{}{"op": "replace", "path": "description", "value": "x"}, http.StatusBadRequest, ""},
+		{"immutable name", map[string]interface{}{"op": "replace", "path": "/name", "value": "storm"}, http.StatusBadRequest, ""},
+		{"immutable createdAt", map[string]interface{}{"op": "remove", "path": "/createdAt"}, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := jsonPatch(router, "weather", tt.op)
+		var body sharewoodapi.ErrorResponse
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != tt.want || (tt.wantCode != "" && body.Code != tt.wantCode) {
+			t.Errorf("%s: got %d with code %q, want %d %q: %s", tt.name, resp.Code, body.Code, tt.want, tt.wantCode, resp.Body)
+		}
+	}
+
+	// Failed patches leave the agent as it was
+	if agent := getTestAgent(t, router, "weather"); agent.Description != "Answers geography questions" {
+		t.Errorf("description changed to %q", agent.Description)
+	}
+}
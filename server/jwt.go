@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Signing algorithms accepted in JWT_ALGORITHM. "none" is never accepted.
+var supportedJWTAlgorithms = map[string]bool{
+	"HS256": true, "HS384": true, "HS512": true,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// jwtKeyFunc returns the key verifying a token, set at startup by
+// initJWTVerifier according to the configured algorithm
+var jwtKeyFunc jwt.Keyfunc
+
+// initJWTVerifier prepares the key used to verify bearer tokens: the
+// JWT_SECRET for HMAC algorithms, otherwise the public key in the
+// JWT_PUBLIC_KEY PEM file or the keys published at JWKS_URL
+func initJWTVerifier() error {
+	if strings.HasPrefix(cfg.JWTAlgorithm, "HS") {
+		secret := []byte(os.Getenv("JWT_SECRET"))
+		jwtKeyFunc = func(*jwt.Token) (interface{}, error) {
+			return secret, nil
+		}
+		return nil
+	}
+
+	if cfg.JWKSURL != "" {
+		jwks := &jwksCache{url: cfg.JWKSURL, ttl: cfg.JWKSCacheTTL}
+		jwtKeyFunc = func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return jwks.key(kid)
+		}
+		return nil
+	}
+
+	data, err := os.ReadFile(cfg.JWTPublicKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+	var key interface{}
+	if strings.HasPrefix(cfg.JWTAlgorithm, "ES") {
+		key, err = jwt.ParseECPublicKeyFromPEM(data)
+	} else {
+		key, err = jwt.ParseRSAPublicKeyFromPEM(data)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid JWT public key: %w", err)
+	}
+	jwtKeyFunc = func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	}
+	return nil
+}
+
+// Reasons a bearer token is rejected, reported to the caller
+var (
+	errTokenMalformed    = errors.New("token is malformed")
+	errTokenSignature    = errors.New("token signature is invalid")
+	errTokenExpired      = errors.New("token expired, please re-authenticate")
+	errTokenNotYetValid  = errors.New("token is not valid yet")
+	errTokenUnverifiable = errors.New("token could not be verified")
+)
+
+// validateJWT verifies a bearer token and returns its claims, or one of the
+// errToken errors saying why it was rejected. The token's alg header must
+// equal the configured algorithm, so a token signed with an HMAC algorithm
+// using the public key as secret, or with alg none, is rejected. Expiry and
+// not-before times are checked with cfg.JWTLeeway of tolerance for clock
+// skew.
+func validateJWT(tokenString string) (*sharewoodapi.JWTClaims, error) {
+	claims := &sharewoodapi.JWTClaims{}
+	// Time-based claims are checked below, with leeway
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{cfg.JWTAlgorithm}), jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(tokenString, claims, jwtKeyFunc); err != nil {
+		var validationErr *jwt.ValidationError
+		if !errors.As(err, &validationErr) {
+			return nil, errTokenMalformed
+		}
+		switch {
+		case validationErr.Errors&jwt.ValidationErrorMalformed != 0:
+			return nil, errTokenMalformed
+		case validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+			return nil, errTokenSignature
+		default:
+			return nil, errTokenUnverifiable
+		}
+	}
+
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-cfg.JWTLeeway).Unix(), false) {
+		return nil, errTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(cfg.JWTLeeway).Unix(), false) || !claims.VerifyIssuedAt(now.Add(cfg.JWTLeeway).Unix(), false) {
+		return nil, errTokenNotYetValid
+	}
+	return claims, nil
+}
+
+// jwksCache holds the public keys published at a JWKS URL, keyed by key ID.
+// Keys are fetched again once the cache is older than ttl, or when a token
+// names an unknown key, at most once a minute, so a rotated key is picked up
+// without letting bogus key IDs trigger a fetch per request.
+type jwksCache struct {
+	url string
+	ttl time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// Minimum time between fetches triggered by unknown key IDs
+const jwksMinRefresh = time.Minute
+
+func (j *jwksCache) key(kid string) (interface{}, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	age := time.Since(j.fetchedAt)
+	_, known := j.keys[kid]
+	if j.keys == nil || age > j.ttl || (!known && age > jwksMinRefresh) {
+		if err := j.refresh(); err != nil {
+			// Keep verifying with the keys already known
+			log.Printf("Error fetching JWKS: %v", err)
+		}
+	}
+
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	// Tokens without a key ID are accepted when the set holds a single key
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// jsonWebKey is the subset of an RFC 7517 JSON Web Key used for RSA and EC
+// signature verification
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j *jwksCache) refresh() error {
+	j.fetchedAt = time.Now()
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS document: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	j.keys = keys
+	return nil
+}
+
+// publicKey decodes the key material of an RSA or EC key
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// useRS256 configures RS256 verification with a new key pair's public key in
+// a PEM file, returning the private key and the PEM data
+func useRS256(t *testing.T) (*rsa.PrivateKey, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("encoding public key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing public key: %v", err)
+	}
+
+	cfg = loadServerConfig()
+	cfg.JWTAlgorithm = "RS256"
+	cfg.JWTPublicKeyFile = path
+	if err := initJWTVerifier(); err != nil {
+		t.Fatalf("initJWTVerifier: %v", err)
+	}
+	return key, data
+}
+
+// testClaims returns the claims of a publisher token valid for an hour
+func testClaims() *sharewoodapi.JWTClaims {
+	return &sharewoodapi.JWTClaims{
+		UserID: "publisher-1",
+		Role:   sharewoodapi.RoleAgentPublisher,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, claims jwt.Claims, key interface{}) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return token
+}
+
+func TestRS256TokenIsVerified(t *testing.T) {
+	key, _ := useRS256(t)
+
+	claims, err := validateJWT(signToken(t, jwt.SigningMethodRS256, testClaims(), key))
+	if err != nil {
+		t.Fatalf("validateJWT: %v", err)
+	}
+	if claims.UserID != "publisher-1" || claims.Role != sharewoodapi.RoleAgentPublisher {
+		t.Fatalf("got claims %+v", claims)
+	}
+}
+
+func TestTamperedRS256TokenIsRejected(t *testing.T) {
+	key, _ := useRS256(t)
+	token := signToken(t, jwt.SigningMethodRS256, testClaims(), key)
+
+	// Swap in a payload claiming the admin role, keeping the signature
+	admin := testClaims()
+	admin.Role = sharewoodapi.RoleAdmin
+	forged := strings.Split(signToken(t, jwt.SigningMethodRS256, admin, key), ".")
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + forged[1] + "." + parts[2]
+
+	if _, err := validateJWT(tampered); !errors.Is(err, errTokenSignature) {
+		t.Fatalf("got %v, want %v", err, errTokenSignature)
+	}
+}
+
+func TestRS256RejectsOtherAlgorithms(t *testing.T) {
+	_, publicPEM := useRS256(t)
+
+	// An HMAC token keyed with the public key, the algorithm confusion attack
+	hmacToken := signToken(t, jwt.SigningMethodHS256, testClaims(), publicPEM)
+	noneToken := signToken(t, jwt.SigningMethodNone, testClaims(), jwt.UnsafeAllowNoneSignatureType)
+
+	for name, token := range map[string]string{"HS256": hmacToken, "none": noneToken} {
+		if _, err := validateJWT(token); err == nil {
+			t.Errorf("%s token was accepted", name)
+		}
+	}
+}
+
+func TestTokenFailureReasons(t *testing.T) {
+	_, router := newTestRegistry(t)
+	t.Setenv("DEV_MODE", "")
+	cfg.JWTAlgorithm = "HS256"
+	cfg.JWTLeeway = 30 * time.Second
+	t.Setenv("JWT_SECRET", "test-secret")
+	if err := initJWTVerifier(); err != nil {
+		t.Fatalf("initJWTVerifier: %v", err)
+	}
+	secret := []byte("test-secret")
+
+	withTimes := func(expires, notBefore time.Duration) *sharewoodapi.JWTClaims {
+		claims := testClaims()
+		claims.ExpiresAt = time.Now().Add(expires).Unix()
+		if notBefore != 0 {
+			claims.NotBefore = time.Now().Add(notBefore).Unix()
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", signToken(t, jwt.SigningMethodHS256, testClaims(), secret), nil},
+		{"expired within leeway", signToken(t, jwt.SigningMethodHS256, withTimes(-10*time.Second, 0), secret), nil},
+		{"expired", signToken(t, jwt.SigningMethodHS256, withTimes(-time.Minute, 0), secret), errTokenExpired},
+		{"not yet valid", signToken(t, jwt.SigningMethodHS256, withTimes(time.Hour, time.Minute), secret), errTokenNotYetValid},
+		{"malformed", "not.a-token", errTokenMalformed},
+		{"bad signature", signToken(t, jwt.SigningMethodHS256, testClaims(), []byte("other-secret")), errTokenSignature},
+	}
+	for _, tt := range tests {
+		if _, err := validateJWT(tt.token); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+		if tt.want == nil {
+			continue
+		}
+
+		// The middleware reports the reason to the caller
+		resp := serve(router, http.MethodGet, "/api/v1/agents", nil, http.Header{"Authorization": {"Bearer " + tt.token}})
+		var body sharewoodapi.ErrorResponse
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != http.StatusUnauthorized || body.Details != tt.want.Error() {
+			t.Errorf("%s: got %d with details %q, want 401 with %q", tt.name, resp.Code, body.Details, tt.want)
+		}
+	}
+}
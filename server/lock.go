@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// TTL of the Consul session backing an agent name lock. If the server dies
+// while holding a lock, Consul releases it once the session expires.
+const agentLockTTL = "15s"
+
+// lockAgentName takes a cluster-wide lock on an agent name using a Consul
+// session and a KV acquire, which succeeds for exactly one holder at a time.
+// It reports false if another request holds the lock. When the lock is
+// acquired, the returned function releases it. Cleanup is not cancelled with
+// ctx, so a lock is released even when the request that took it has ended.
+func lockAgentName(ctx context.Context, name string) (func(), bool, error) {
+	sessionID, err := consulSessionCreate(ctx, &api.SessionEntry{
+		Name:     "sharewood-register-" + name,
+		TTL:      agentLockTTL,
+		Behavior: api.SessionBehaviorDelete,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create lock session: %w", err)
+	}
+
+	pair := &api.KVPair{
+		Key:     cfg.KVPrefix + "/locks/agents/" + url.PathEscape(name),
+		Session: sessionID,
+	}
+	acquired, err := consulKVAcquire(ctx, pair)
+	cleanupCtx := context.WithoutCancel(ctx)
+	if err != nil || !acquired {
+		if destroyErr := consulSessionDestroy(cleanupCtx, sessionID); destroyErr != nil {
+			log.Printf("Error destroying lock session: %v", destroyErr)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to acquire lock: %w", err)
+		}
+		return nil, false, nil
+	}
+
+	release := func() {
+		if err := consulKVRelease(cleanupCtx, pair); err != nil {
+			log.Printf("Error releasing lock on agent %s: %v", name, err)
+		}
+		// Destroying the session deletes the lock key
+		if err := consulSessionDestroy(cleanupCtx, sessionID); err != nil {
+			log.Printf("Error destroying lock session: %v", err)
+		}
+	}
+	return release, true, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentCreateIfAbsent(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	// Slow registration down so the creates overlap between the existence
+	// check and the registration
+	consul.onRequest = func(r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/agent/service/register") {
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	const writers = 8
+	codes := make(chan int, writers)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			codes <- serve(router, http.MethodPost, "/api/v1/agents?ifNotExists=true", testAgent("weather"), nil).Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+
+	created := 0
+	for code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("got status %d, want 201 or 409", code)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d creates succeeded, want exactly 1", created)
+	}
+}
@@ -1,17 +1,30 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/hashicorp/consul/api"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rdhillbb/sharewood/sharewoodapi" // Import the sharewoodapi package
 )
 
@@ -25,48 +38,166 @@ func loadConfig() {
 
 func main() {
 	loadConfig()
+	cfg = loadServerConfig()
+	initAgentSchema(cfg.RequiredFields)
+	if err := initJWTVerifier(); err != nil {
+		log.Fatalf("Error initializing JWT verification: %v", err)
+	}
+	readOnly.Store(cfg.ReadOnly)
 	var err error
 	consulClient, err = initConsulClient()
 	if err != nil {
 		log.Fatalf("Error initializing Consul client: %v", err)
 	}
 
+	handler := newHandler()
+
+	if cfg.ReassertRegistrations {
+		go runRegistrationReassertion()
+	}
+	if len(cfg.CriticalAlertHooks) > 0 {
+		go runCriticalAlerts()
+	}
+
+	server := newServer(":"+listenPort(), handler)
+
+	// The gin engine is only the handler; the http.Server owns the listener,
+	// so TLS and HTTP/2 apply to every route without gin being involved.
+	// HTTP/2 is negotiated automatically over TLS.
+	keepAlives := "on"
+	if cfg.DisableKeepAlives {
+		keepAlives = "off"
+	}
+	if cfg.TLSCertFile != "" {
+		log.Printf("Listening and serving HTTPS (HTTP/2 and HTTP/1.1) on %s, keep-alives %s", server.Addr, keepAlives)
+		err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		log.Printf("Listening and serving HTTP/1.1 on %s, keep-alives %s", server.Addr, keepAlives)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+}
+
+// newServer returns the HTTP server for handler, with the timeouts and
+// keep-alive setting from the configuration
+func newServer(addr string, handler http.Handler) *http.Server {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	server.SetKeepAlivesEnabled(!cfg.DisableKeepAlives)
+	return server
+}
+
+// newHandler returns the registry's HTTP handler: the router built by
+// setupRouter behind trimTrailingSlash. Serve the registry through it rather
+// than the bare router, so a path with a trailing slash is handled the same
+// everywhere.
+func newHandler() http.Handler {
+	return trimTrailingSlash(setupRouter())
+}
+
+// trimTrailingSlash serves a path with a trailing slash, e.g. /api/v1/agents/,
+// exactly as the path without it, before gin routes the request. gin would
+// otherwise answer with a 301 or 307 redirect, which some HTTP clients follow
+// without the Authorization and X-API-Key headers.
+func trimTrailingSlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Path) > 1 && strings.HasSuffix(r.URL.Path, "/") {
+			r.URL.Path = strings.TrimRight(r.URL.Path, "/")
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+			if r.URL.RawPath != "" {
+				r.URL.RawPath = strings.TrimRight(r.URL.RawPath, "/")
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setupRouter builds the gin engine with all middleware and routes. Paths
+// are matched exactly: gin's trailing slash and path-fixing redirects are
+// off, and trailing slashes are instead trimmed by trimTrailingSlash, which
+// newHandler puts in front of the router.
+func setupRouter() *gin.Engine {
 	r := gin.Default()
+	r.RedirectTrailingSlash = false
+	r.RedirectFixedPath = false
 	r.Use(corsMiddleware())
+	r.Use(gzipMiddleware())
+	if cfg.RequireHTTPS {
+		r.Use(requireHTTPSMiddleware())
+	}
 	
 	// Public endpoints
-	r.GET("/health", healthCheck)
+	r.GET(cfg.HealthPath, healthCheck)
+	r.GET(cfg.MetricsPath, gin.WrapH(promhttp.Handler()))
+	openAPIDoc, openAPIPaths := buildOpenAPIDocument()
+	r.GET(openAPIPath, openAPIHandler(openAPIDoc))
+	r.GET(versionPath, getVersion)
 
 	// API group secured with authentication middleware
-	api := r.Group("/api/v1")
-	api.Use(authMiddleware())
+	api := r.Group(cfg.BasePath)
+	api.Use(envelopeMiddleware(), authMiddleware(), bodyLimitMiddleware(), requireJSONMiddleware())
 	{
 		// Agent endpoints
 		agents := api.Group("/agents")
 		{
 			agents.GET("", listAgents)
+			agents.GET("/select", selectAgent)
+			agents.GET("/defaults", getAgentDefaults)
+			agents.GET("/grouped", groupAgents)
+			agents.POST("/get", getAgents)
+			agents.POST("/health", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), updateHealthBatch)
 			agents.GET("/:name", getAgent)
-			agents.POST("", authorize("admin", "agent-publisher"), registerAgent)
-			agents.DELETE("/:name", authorize("admin", "agent-publisher"), unregisterAgent)
-			agents.PUT("/:name/health", authorize("admin", "agent-publisher"), updateAgentHealth)
+			agents.GET("/:name/ping", pingAgent)
+			agents.GET("/:name/dependencies", getAgentDependencies)
+			agents.GET("/:name/health", getAgentHealth)
+			agents.GET("/:name/icon", getAgentIcon)
+			agents.POST("", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), registerAgent)
+			agents.PATCH("/:name", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), patchAgent)
+			agents.DELETE("", authorize(sharewoodapi.RoleAdmin), rejectWhenReadOnly(), deregisterByOwner)
+			agents.DELETE("/:name", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), unregisterAgent)
+			agents.PUT("/:name/health", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), updateAgentHealth)
+			agents.PUT("/:name/icon", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), putAgentIcon)
+			agents.POST("/:name/tags", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), updateAgentTags)
+			agents.POST("/:name/renew", authorize(sharewoodapi.RoleAdmin, sharewoodapi.RoleAgentPublisher), rejectWhenReadOnly(), renewAgent)
 		}
-	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
-	}
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+		api.GET("/whoami", whoAmI)
+		api.GET("/health/agents", agentsHealthSummary)
+		api.GET("/schema/agent", getAgentSchema)
+		api.GET("/tags", listTags)
+		api.GET("/tags/allowed", listAllowedTags)
+
+		// Backup and migration endpoints
+		api.GET("/export", authorize(sharewoodapi.RoleAdmin), exportAgents)
+		api.POST("/import", authorize(sharewoodapi.RoleAdmin), rejectWhenReadOnly(), importAgents)
+
+		// Administration endpoints
+		api.POST("/admin/readonly", authorize(sharewoodapi.RoleAdmin), setReadOnlyMode)
+		api.GET("/admin/config", authorize(sharewoodapi.RoleAdmin), getEffectiveConfig)
+		api.POST("/admin/reindex", authorize(sharewoodapi.RoleAdmin), rejectWhenReadOnly(), reindexAgents)
 	}
+
+	checkOpenAPICoverage(r.Routes(), openAPIPaths)
+	return r
 }
 
 // Middleware functions
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, If-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, "+sharewoodapi.EnvelopeHeader)
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(http.StatusNoContent)
 			return
@@ -75,11 +206,142 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// requireHTTPSMiddleware rejects requests that did not arrive over TLS,
+// either directly or at a trusted proxy reporting it through
+// X-Forwarded-Proto, so credentials are never accepted in plaintext. The
+// header is ignored from other peers, which could otherwise claim HTTPS over
+// plain HTTP. The health endpoint stays reachable for probes.
+func requireHTTPSMiddleware() gin.HandlerFunc {
+	proxies, _ := parseTrustedProxies(cfg.TrustedProxies)
+	return func(c *gin.Context) {
+		if c.Request.URL.Path == cfg.HealthPath {
+			c.Next()
+			return
+		}
+
+		secure := c.Request.TLS != nil
+		if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" && fromTrustedProxy(c.Request, proxies) {
+			// Behind a proxy the first listed protocol is the client's
+			proto, _, _ = strings.Cut(proto, ",")
+			secure = strings.EqualFold(strings.TrimSpace(proto), "https")
+		}
+
+		if !secure {
+			c.Header("Upgrade", "TLS/1.2, HTTP/1.1")
+			c.Header("Connection", "Upgrade")
+			abortWithError(c, http.StatusUpgradeRequired, sharewoodapi.ErrorResponse{
+				Error:   "HTTPS required",
+				Details: "This registry only accepts requests over HTTPS",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// parseTrustedProxies parses TRUSTED_PROXIES entries, each an IP address or a
+// CIDR range
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// fromTrustedProxy reports whether the request's peer is one of proxies
+func fromTrustedProxy(r *http.Request, proxies []netip.Prefix) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyLimitMiddleware caps the size of request bodies on mutating endpoints
+func bodyLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxBodyBytes)
+		}
+		c.Next()
+	}
+}
+
+// requireJSONMiddleware rejects request bodies that are not declared as JSON
+// with 415, instead of failing later with a confusing parse error. Requests
+// without a body, such as a renewal, need no Content-Type. Icon uploads,
+// whose bodies are images, check their own Content-Type.
+func requireJSONMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength == 0 || c.FullPath() == strings.TrimSuffix(cfg.BasePath, "/")+agentIconRoute {
+			c.Next()
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+		if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+			abortWithError(c, http.StatusUnsupportedMediaType, sharewoodapi.ErrorResponse{
+				Error:   "Unsupported media type",
+				Details: "Request bodies must be sent with Content-Type: application/json",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// decodeJSONBody decodes the request body into v, writing the error response
+// and returning false if the body is too large or not valid JSON
+func decodeJSONBody(c *gin.Context, v interface{}) bool {
+	err := json.NewDecoder(c.Request.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		respondError(c, http.StatusRequestEntityTooLarge, sharewoodapi.ErrorResponse{
+			Error:   "Request body too large",
+			Details: fmt.Sprintf("Request bodies are limited to %d bytes", maxBytesErr.Limit),
+		})
+		return false
+	}
+
+	respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+		Error:   "Invalid request body",
+		Details: err.Error(),
+	})
+	return false
+}
+
 func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// For development/testing, you can bypass auth
 		if os.Getenv("DEV_MODE") == "true" {
-			c.Set("role", "admin")
+			c.Set("role", string(sharewoodapi.RoleAdmin))
+			c.Set("auth_method", authMethodDev)
 			c.Next()
 			return
 		}
@@ -88,25 +350,50 @@ func authMiddleware() gin.HandlerFunc {
 		if apiKey != "" {
 			role, valid := validateAPIKey(apiKey)
 			if valid {
-				c.Set("role", role)
+				c.Set("role", string(role))
+				c.Set("auth_method", authMethodAPIKey)
 				c.Next()
 				return
 			}
 		}
 
 		authHeader := c.GetHeader("Authorization")
+		var tokenErr error
 		if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
 			tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-			claims, valid := validateJWT(tokenString)
-			if valid {
+			var claims *sharewoodapi.JWTClaims
+			claims, tokenErr = validateJWT(tokenString)
+			if tokenErr == nil {
 				c.Set("user_id", claims.UserID)
-				c.Set("role", claims.Role)
+				c.Set("role", string(claims.Role))
+				c.Set("auth_method", authMethodJWT)
+				if claims.ExpiresAt != 0 {
+					c.Set("token_expires_at", time.Unix(claims.ExpiresAt, 0).UTC())
+				}
 				c.Next()
 				return
 			}
 		}
 
-		c.JSON(http.StatusUnauthorized, sharewoodapi.ErrorResponse{
+		// Never log credentials in full
+		if apiKey != "" {
+			log.Printf("Rejected API key %s for %s %s", sharewoodapi.RedactCredential(apiKey), c.Request.Method, c.Request.URL.Path)
+		} else if authHeader != "" {
+			log.Printf("Rejected Authorization header %s for %s %s", sharewoodapi.RedactCredential(authHeader), c.Request.Method, c.Request.URL.Path)
+		}
+
+		// Say why a bearer token was rejected, unless a valid API key was
+		// expected instead
+		if tokenErr != nil && apiKey == "" {
+			respondError(c, http.StatusUnauthorized, sharewoodapi.ErrorResponse{
+				Error:   "Invalid token",
+				Details: tokenErr.Error(),
+			})
+			c.Abort()
+			return
+		}
+
+		respondError(c, http.StatusUnauthorized, sharewoodapi.ErrorResponse{
 			Error:   "Authentication required",
 			Details: "Provide a valid API key or Bearer token",
 		})
@@ -114,24 +401,24 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
-func authorize(allowedRoles ...string) gin.HandlerFunc {
+func authorize(allowedRoles ...sharewoodapi.Role) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		role, exists := c.Get("role")
 		if !exists {
-			c.JSON(http.StatusForbidden, sharewoodapi.ErrorResponse{
+			respondError(c, http.StatusForbidden, sharewoodapi.ErrorResponse{
 				Error: "Role information missing",
 			})
 			c.Abort()
 			return
 		}
-		roleStr := role.(string)
+		callerRole := sharewoodapi.Role(role.(string))
 		for _, allowedRole := range allowedRoles {
-			if roleStr == allowedRole || roleStr == "admin" {
+			if callerRole == allowedRole || callerRole == sharewoodapi.RoleAdmin {
 				c.Next()
 				return
 			}
 		}
-		c.JSON(http.StatusForbidden, sharewoodapi.ErrorResponse{
+		respondError(c, http.StatusForbidden, sharewoodapi.ErrorResponse{
 			Error: "Insufficient permissions",
 		})
 		c.Abort()
@@ -139,62 +426,177 @@ func authorize(allowedRoles ...string) gin.HandlerFunc {
 }
 
 // Authentication functions
-func validateAPIKey(apiKey string) (string, bool) {
+func validateAPIKey(apiKey string) (sharewoodapi.Role, bool) {
 	// In production, implement secure API key validation
 	if apiKey == "test-api-key" {
-		return "agent-publisher", true
+		return sharewoodapi.RoleAgentPublisher, true
 	}
 	return "", false
 }
 
-type JWTClaims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
-	jwt.StandardClaims
-}
-
-func validateJWT(tokenString string) (*JWTClaims, bool) {
-	secret := os.Getenv("JWT_SECRET")
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-	if err != nil {
-		return nil, false
-	}
-	if claims, ok := token.Claims.(*JWTClaims); ok && token.Valid {
-		return claims, true
+// listenPort returns the port the server listens on, from PORT
+func listenPort() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return port
 	}
-	return nil, false
+	return "3000"
 }
 
-// Consul client initialization
-func initConsulClient() (*api.Client, error) {
+// consulConfig returns the settings of the Consul client, from the Consul
+// API's own environment variables overridden by CONSUL_ADDR, CONSUL_TOKEN
+// and CONSUL_NAMESPACE
+func consulConfig() *api.Config {
 	config := api.DefaultConfig()
 	consulAddr := os.Getenv("CONSUL_ADDR")
 	if consulAddr != "" {
 		config.Address = consulAddr
 	}
-	client, err := api.NewClient(config)
+	// ACL token and (Enterprise) namespace for ACL-enabled Consul clusters
+	if token := os.Getenv("CONSUL_TOKEN"); token != "" {
+		config.Token = token
+	}
+	if namespace := os.Getenv("CONSUL_NAMESPACE"); namespace != "" {
+		config.Namespace = namespace
+	}
+	return config
+}
+
+// Consul client initialization
+func initConsulClient() (*api.Client, error) {
+	client, err := api.NewClient(consulConfig())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Consul client: %w", err)
 	}
+
+	// Fail fast if the configured token cannot read services, rather than
+	// surfacing the ACL problem on the first request
+	if _, err := client.Agent().Services(); err != nil {
+		if isConsulPermissionDenied(err) {
+			return nil, fmt.Errorf("consul denied access; check that CONSUL_TOKEN has service read/write permissions: %w", err)
+		}
+		log.Printf("Warning: Consul is not reachable yet: %v", err)
+	}
 	return client, nil
 }
 
-// API endpoints
-func healthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+// Helper function to detect ACL permission errors returned by Consul
+func isConsulPermissionDenied(err error) bool {
+	if err == nil {
+		return false
+	}
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusForbidden {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "Permission denied") || strings.Contains(msg, "ACL not found")
+}
+
+// Helper function to detect that Consul could not be reached or could not
+// serve the request: refused or dropped connections, timeouts, and Consul's
+// own unavailability responses such as a missing cluster leader. A request
+// cancelled by its caller is not an outage.
+func isConsulUnavailable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return strings.Contains(statusErr.Body, "No cluster leader")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || strings.Contains(err.Error(), "No cluster leader")
+}
+
+// consulErrorStatus is the HTTP status reporting a failed Consul operation:
+// 503 when Consul is unavailable, 502 when it denied the operation, and 500
+// otherwise
+func consulErrorStatus(err error) int {
+	switch {
+	case isConsulUnavailable(err):
+		return http.StatusServiceUnavailable
+	case isConsulPermissionDenied(err):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// withErrorCode gives a failed batch result the general code of its status,
+// unless it carries a more specific one, as respondError does for responses
+func withErrorCode(result sharewoodapi.BatchResult) sharewoodapi.BatchResult {
+	if result.Status >= http.StatusBadRequest && result.Code == "" {
+		result.Code = sharewoodapi.ErrorCodeForStatus(result.Status)
+	}
+	return result
 }
 
-// Helper function to encode arrays to string for Consul metadata
-func encodeArrayToString(arr []string) string {
-	if len(arr) == 0 {
-		return ""
+// Helper function to report a failed Consul operation. An unreachable Consul
+// and ACL permission problems are reported distinctly so they are not
+// mistaken for a bad request or a server bug.
+func respondConsulError(c *gin.Context, message string, err error) {
+	if errors.Is(err, errInstanceRequired) {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeInstanceRequired,
+			Error:   "Instance required",
+			Details: err.Error(),
+		})
+		return
+	}
+	if isConsulUnavailable(err) {
+		log.Printf("Consul unavailable for %s: %v", c.FullPath(), err)
+		if cfg.BackendRetryAfter > 0 {
+			// Retry-After counts whole seconds, so round up
+			c.Header("Retry-After", strconv.Itoa(int((cfg.BackendRetryAfter+time.Second-1)/time.Second)))
+		}
+		respondError(c, http.StatusServiceUnavailable, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeBackendUnavailable,
+			Error:   sharewoodapi.BackendUnavailableError,
+			Details: fmt.Sprintf("%s: Consul could not be reached", message),
+		})
+		return
+	}
+	if isConsulPermissionDenied(err) {
+		log.Printf("Consul denied %s: %v", c.FullPath(), err)
+		respondError(c, http.StatusBadGateway, sharewoodapi.ErrorResponse{
+			Error:   "Registry backend denied the operation",
+			Details: "The Consul ACL token (CONSUL_TOKEN) lacks the permissions required for this operation",
+		})
+		return
+	}
+	respondError(c, http.StatusInternalServerError, sharewoodapi.ErrorResponse{
+		Error:   message,
+		Details: err.Error(),
+	})
+}
+
+// respondError sends an error response, with the general code of its status
+// unless it carries a more specific one
+func respondError(c *gin.Context, status int, resp sharewoodapi.ErrorResponse) {
+	if resp.Code == "" {
+		resp.Code = sharewoodapi.ErrorCodeForStatus(status)
 	}
-	return strings.Join(arr, ",")
+	c.JSON(status, resp)
+}
+
+// abortWithError is respondError for middleware, stopping the handler chain
+func abortWithError(c *gin.Context, status int, resp sharewoodapi.ErrorResponse) {
+	respondError(c, status, resp)
+	c.Abort()
 }
 
-// Helper function to decode string to array from Consul metadata
+// API endpoints
+func healthCheck(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Helper function to decode the legacy comma-separated tags metadata
 func decodeStringToArray(str string) []string {
 	if str == "" {
 		return []string{}
@@ -202,15 +604,31 @@ func decodeStringToArray(str string) []string {
 	return strings.Split(str, ",")
 }
 
-// Helper function to check if an agent with the given name already exists
-func agentExists(name string) (bool, error) {
-	services, err := consulClient.Agent().Services()
+// agentServiceID returns the Consul service ID of an agent. Agents are unique
+// per name and environment; agents without an environment keep the plain name
+// as their ID, as before environments existed.
+func agentServiceID(name, environment string) string {
+	if environment == "" {
+		return name
+	}
+	return name + "@" + environment
+}
+
+// Helper function to check if an agent with the given name already exists in
+// the given environment. A new instance of a scaled agent only collides with
+// an instance of the same ID, or with an agent registered without instances.
+func agentExists(ctx context.Context, name, environment, instanceID string) (bool, error) {
+	services, err := consulServices(ctx)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if agent exists: %w", err)
 	}
 
 	for _, service := range services {
-		if service.Service == name {
+		if service.Service != name || service.Meta["environment"] != environment {
+			continue
+		}
+		existing := service.Meta["instanceId"]
+		if instanceID == "" || existing == "" || existing == instanceID {
 			return true, nil
 		}
 	}
@@ -218,366 +636,1348 @@ func agentExists(name string) (bool, error) {
 	return false, nil
 }
 
-// Agent Registration endpoint - Updated to use sharewoodapi.Agent
-func registerAgent(c *gin.Context) {
-	var agent sharewoodapi.Agent
-	if err := c.ShouldBindJSON(&agent); err != nil {
-		c.JSON(http.StatusBadRequest, sharewoodapi.ErrorResponse{
-			Error:   "Invalid request body", 
-			Details: err.Error(),
-		})
-		return
+// metaTime parses an RFC3339 timestamp stored under key in service metadata,
+// returning nil if it is missing or malformed
+func metaTime(meta map[string]string, key string) *time.Time {
+	t, err := time.Parse(time.RFC3339, meta[key])
+	if err != nil {
+		return nil
 	}
+	return &t
+}
 
-	// Validate required fields
-	if agent.Name == "" || agent.Description == "" || agent.BaseURL == "" || agent.HowToUse == "" {
-		c.JSON(http.StatusBadRequest, sharewoodapi.ErrorResponse{
-			Error:   "Missing required fields",
-			Details: "name, description, baseurl, and howtouse are required",
-		})
-		return
+// metaStringList decodes a list stored under key in service metadata as a
+// JSON array, returning nil if it is missing or malformed
+func metaStringList(meta map[string]string, key string) []string {
+	var list []string
+	if val := meta[key]; val != "" {
+		if err := json.Unmarshal([]byte(val), &list); err != nil {
+			return nil
+		}
 	}
-	
-	// Check if an agent with this name already exists
-	exists, err := agentExists(agent.Name)
-	if err != nil {
-		log.Printf("Error checking existing agents: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to check if agent already exists",
-			Details: err.Error(),
-		})
-		return
+	return list
+}
+
+// setMetaStringList stores a non-empty list under key in service metadata as
+// a JSON array, so entries round-trip exactly
+func setMetaStringList(meta map[string]string, key string, list []string) {
+	if len(list) > 0 {
+		if data, err := json.Marshal(list); err == nil {
+			meta[key] = string(data)
+		}
 	}
+}
 
-	if exists {
-		c.JSON(http.StatusConflict, sharewoodapi.ErrorResponse{
-			Error:   "Agent already exists",
-			Details: fmt.Sprintf("An agent with the name '%s' is already registered", agent.Name),
-		})
-		return
+// timestampNow returns the current time at the second precision kept in
+// metadata, for stamping createdAt and updatedAt
+func timestampNow() *time.Time {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &now
+}
+
+// Helper function to check if a Consul service is tagged as an AI agent, i.e.
+// carries the configured discriminator tag
+func isAIAgent(service *api.AgentService) bool {
+	for _, tag := range service.Tags {
+		if tag == cfg.AgentTag {
+			return true
+		}
 	}
-	
-	// Create metadata map with essential fields only
-	metadata := map[string]string{
-		"Description": agent.Description,
-		"howtouse":    agent.HowToUse,
-		"baseurl":     agent.BaseURL,
+	return false
+}
+
+// Helper function to build a sharewoodapi.Agent from a Consul service entry
+func serviceToAgent(service *api.AgentService) sharewoodapi.Agent {
+	agent := sharewoodapi.Agent{
+		Name:        service.Service,
+		Description: service.Meta["Description"],
+		BaseURL:     service.Meta["baseurl"],
+		HowToUse:    service.Meta["howtouse"],
+		Owner:       service.Meta["owner"],
+		Lifecycle:   service.Meta["lifecycle"],
+		Contact:     service.Meta["contact"],
+		DocsURL:     service.Meta["docsUrl"],
+		IconURL:     service.Meta["iconUrl"],
+		Environment: service.Meta["environment"],
+		InstanceID:  service.Meta["instanceId"],
+		Aliases:     metaStringList(service.Meta, "aliases"),
+		DependsOn:   metaStringList(service.Meta, "dependsOn"),
+		Metadata:    metadataFromMeta(service.Meta),
 	}
-	
-	// Add expiration if present
-	if !agent.Expiration.IsZero() {
-		metadata["expiration"] = agent.Expiration.Format(time.RFC3339)
+
+	// Report the primary base URL first among all of them
+	if agent.BaseURL != "" {
+		agent.BaseURLs = append([]string{agent.BaseURL}, metaStringList(service.Meta, "baseurls")...)
 	}
-	
-	// Add release if present
-	if agent.Release != "" {
-		metadata["release"] = agent.Release
+
+	// Agents registered before lifecycles existed are active
+	if agent.Lifecycle == "" {
+		agent.Lifecycle = sharewoodapi.LifecycleActive
 	}
-	
-	// Store OpenAPI spec
-	if agent.OpenAPI != "" {
-		metadata["openapi"] = agent.OpenAPI
+	agent.Deprecated = agent.Lifecycle == sharewoodapi.LifecycleDeprecated
+
+	// Add release if available
+	if val, ok := service.Meta["release"]; ok && val != "" {
+		agent.Release = val
 	}
-	
-	// Store tags in metadata for easier retrieval
-	if len(agent.Tags) > 0 {
-		metadata["tags"] = encodeArrayToString(agent.Tags)
+
+	// Add OpenAPI if available
+	if val, ok := service.Meta["openapi"]; ok && val != "" {
+		agent.OpenAPI = val
 	}
 
-	// Prepare service registration
-	registration := &api.AgentServiceRegistration{
-		Name: agent.Name,
-		Tags: append([]string{"ai-agent"}, agent.Tags...),
-		Meta: metadata,
+	// Add expiration if available
+	if val, ok := service.Meta["expiration"]; ok && val != "" {
+		if t, err := time.Parse(time.RFC3339, val); err == nil {
+			agent.Expiration = t
+		}
 	}
 
-	// Handle TTL
-	if agent.TTL > 0 {
-		ttlDuration := time.Duration(agent.TTL) * time.Second
-		registration.Check = &api.AgentServiceCheck{
-			TTL:   ttlDuration.String(),
-			Notes: "TTL for the AI agent service",
+	// Add registration timestamps if available
+	agent.CreatedAt = metaTime(service.Meta, "createdAt")
+	agent.UpdatedAt = metaTime(service.Meta, "updatedAt")
+
+	// Add TTL if available
+	if val, ok := service.Meta["ttl"]; ok && val != "" {
+		if ttl, err := strconv.ParseInt(val, 10, 64); err == nil {
+			agent.TTL = ttl
 		}
 	}
+	agent.Weight = service.Weights.Passing
+	agent.HealthCheckURL = service.Meta["healthCheckUrl"]
+	agent.AllowMultipleChecks = service.Meta["allowMultipleChecks"] == "true"
 
-	if err := consulClient.Agent().ServiceRegister(registration); err != nil {
-		log.Printf("Error registering agent: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to register agent",
-			Details: err.Error(),
-		})
-		return
+	// Add tags. They are stored as native Consul service tags, which keeps
+	// them exact (commas included) and free of metadata size limits.
+	agent.Tags = make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range service.Tags {
+		if tag != cfg.AgentTag && !seen[tag] {
+			seen[tag] = true
+			agent.Tags = append(agent.Tags, tag)
+		}
+	}
+	// Services registered by other tools may only carry the legacy
+	// comma-separated tags metadata
+	if len(agent.Tags) == 0 && service.Meta["tags"] != "" {
+		agent.Tags = decodeStringToArray(service.Meta["tags"])
 	}
+	// Sort so list and get responses report tags in a stable order
+	sort.Strings(agent.Tags)
 
-	// Return the response in the expected format
-	c.JSON(http.StatusCreated, sharewoodapi.AgentRegistrationResponse{
-		Agent:   agent,
-		Message: "Agent registered successfully",
-	})
+	agent.ContentHash = sharewoodapi.AgentContentHash(agent)
+
+	return agent
 }
 
-// List Agents endpoint - Updated to return format expected by client
-func listAgents(c *gin.Context) {
-	services, err := consulClient.Agent().Services()
+// Helper function to fetch the Consul services backing AI agents, keyed by
+// agentInstanceServiceID of their name, environment and instance ID. Each
+// instance of a scaled agent is listed on its own.
+func agentServicesByKey(ctx context.Context) (map[string]*api.AgentService, error) {
+	services, err := consulServices(ctx)
 	if err != nil {
-		log.Printf("Error listing agents: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to list agents",
-			Details: err.Error(),
-		})
-		return
+		return nil, fmt.Errorf("failed to list agents: %w", err)
 	}
 
-	agents := make([]sharewoodapi.Agent, 0)
+	byKey := make(map[string]*api.AgentService)
 	for _, service := range services {
-		// Filter for AI agents only
-		isAIAgent := false
-		for _, tag := range service.Tags {
-			if tag == "ai-agent" {
-				isAIAgent = true
-				break
-			}
-		}
-
-		if isAIAgent {
-			// Build sharewoodapi.Agent object
-			agent := sharewoodapi.Agent{
-				Name:        service.Service,
-				Description: service.Meta["Description"],
-				BaseURL:     service.Meta["baseurl"],
-				HowToUse:    service.Meta["howtouse"],
-			}
-			
-			// Add release if available
-			if val, ok := service.Meta["release"]; ok && val != "" {
-				agent.Release = val
-			}
-			
-			// Add OpenAPI if available
-			if val, ok := service.Meta["openapi"]; ok && val != "" {
-				agent.OpenAPI = val
-			}
-			
-			// Add expiration if available
-			if val, ok := service.Meta["expiration"]; ok && val != "" {
-				if t, err := time.Parse(time.RFC3339, val); err == nil {
-					agent.Expiration = t
-				}
-			}
-			
-			// Add tags
-			agent.Tags = make([]string, 0)
-			// First add tags from meta if present
-			if val, ok := service.Meta["tags"]; ok && val != "" {
-				agent.Tags = append(agent.Tags, decodeStringToArray(val)...)
-			}
-			// Then add any tags from service that aren't the "ai-agent" tag
-			for _, tag := range service.Tags {
-				if tag != "ai-agent" {
-					// Check if tag is already in the list
-					found := false
-					for _, existingTag := range agent.Tags {
-						if existingTag == tag {
-							found = true
-							break
-						}
-					}
-					if !found {
-						agent.Tags = append(agent.Tags, tag)
-					}
-				}
-			}
-			
-			agents = append(agents, agent)
+		if isAIAgent(service) {
+			byKey[agentInstanceServiceID(service.Service, service.Meta["environment"], service.Meta["instanceId"])] = service
 		}
 	}
+	return byKey, nil
+}
 
-	// Return the agents array directly to match client expectations
-	c.JSON(http.StatusOK, agents)
+// canonicalAgentName folds case and drops separators, so that names such as
+// "Geo-Agent" and "geo_agent" compare equal
+func canonicalAgentName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', '.', ' ':
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
 }
 
-// Get Agent endpoint - Updated to return format expected by client
-func getAgent(c *gin.Context) {
-	name := c.Param("name")
-	
-	// Check if the agent exists first
-	exists, err := agentExists(name)
+// similarAgentNames returns the registered agent names, other than name
+// itself, whose canonical form matches that of name
+func similarAgentNames(ctx context.Context, name string) ([]string, error) {
+	services, err := agentServicesByKey(ctx)
 	if err != nil {
-		log.Printf("Error checking agent existence: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to check agent existence",
-			Details: err.Error(),
-		})
-		return
+		return nil, err
 	}
-	
-	if !exists {
-		c.JSON(http.StatusNotFound, sharewoodapi.ErrorResponse{
-			Error: "Agent not found",
-		})
+
+	canonical := canonicalAgentName(name)
+	seen := make(map[string]bool)
+	var similar []string
+	for _, service := range services {
+		existing := service.Service
+		if existing != name && !seen[existing] && canonicalAgentName(existing) == canonical {
+			seen[existing] = true
+			similar = append(similar, existing)
+		}
+	}
+	sort.Strings(similar)
+	return similar, nil
+}
+
+// Helper function returning the identity of the authenticated caller, if known
+func callerIdentity(c *gin.Context) string {
+	return c.GetString("user_id")
+}
+
+// agentLocation returns the URL path of an agent's resource, with the name
+// escaped and the environment, if any, as the env query parameter
+func agentLocation(agent sharewoodapi.Agent) string {
+	location := cfg.BasePath + "/agents/" + url.PathEscape(agent.Name)
+	if agent.Environment != "" {
+		location += "?env=" + url.QueryEscape(agent.Environment)
+	}
+	return location
+}
+
+// Accepted form of an agent's environment, e.g. dev, stage or prod
+var environmentPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Names that collide with fixed routes under /agents and cannot be used for agents
+var reservedAgentNames = map[string]bool{
+	"select":   true,
+	"get":      true,
+	"health":   true,
+	"defaults": true,
+	"grouped":  true,
+}
+
+// missingRequiredFields returns the configured required fields that are empty on agent
+func missingRequiredFields(agent sharewoodapi.Agent) []string {
+	value := reflect.ValueOf(agent)
+	missing := make([]string, 0)
+	for i := 0; i < value.NumField(); i++ {
+		name := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		if containsString(cfg.RequiredFields, name) && value.Field(i).IsZero() {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// Longest contact accepted on an agent
+const maxContactLength = 256
+
+// Helper function to validate an agent definition before it is stored.
+// Returns nil if the agent is valid.
+func validateAgent(agent sharewoodapi.Agent) *sharewoodapi.ErrorResponse {
+	// Validate required fields, as configured by REQUIRED_FIELDS
+	if missing := missingRequiredFields(agent); len(missing) > 0 {
+		fields := make(map[string]string, len(missing))
+		for _, field := range missing {
+			fields[field] = "is required"
+		}
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Missing required fields",
+			Details: strings.Join(cfg.RequiredFields, ", ") + " are required",
+			Fields:  fields,
+		}
+	}
+
+	// Validate the base URL is absolute so consumers can call it
+	if agent.BaseURL != "" {
+		if err := sharewoodapi.ValidateBaseURL(agent.BaseURL, cfg.AllowAnyURLScheme); err != nil {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid base URL",
+				Details: err.Error(),
+				Fields:  map[string]string{"baseurl": err.Error()},
+			}
+		}
+	}
+	if errResp := validateBaseURLs(agent); errResp != nil {
+		return errResp
+	}
+
+	if strings.Contains(agent.Name, "@") {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid name",
+			Details: "Agent names cannot contain '@'",
+			Fields:  map[string]string{"name": "must not contain '@'"},
+		}
+	}
+
+	if agent.Environment != "" && !environmentPattern.MatchString(agent.Environment) {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid environment",
+			Details: "environment may only contain letters, digits, '-' and '_'",
+			Fields:  map[string]string{"environment": "may only contain letters, digits, '-' and '_'"},
+		}
+	}
+
+	if agent.InstanceID != "" && !environmentPattern.MatchString(agent.InstanceID) {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid instance ID",
+			Details: "instanceId may only contain letters, digits, '-' and '_'",
+			Fields:  map[string]string{"instanceId": "may only contain letters, digits, '-' and '_'"},
+		}
+	}
+
+	if reservedAgentNames[agent.Name] {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid name",
+			Details: fmt.Sprintf("'%s' is reserved and cannot be used as an agent name", agent.Name),
+		}
+	}
+
+	// Validate aliases, which are looked up like names
+	seenAliases := make(map[string]bool)
+	for _, alias := range agent.Aliases {
+		if alias == "" || alias == agent.Name || seenAliases[alias] || strings.Contains(alias, "@") || reservedAgentNames[alias] {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid alias",
+				Details: fmt.Sprintf("'%s' cannot be used as an alias: aliases must be non-empty, distinct from the name and each other, and not contain '@'", alias),
+				Fields:  map[string]string{"aliases": fmt.Sprintf("invalid alias '%s'", alias)},
+			}
+		}
+		seenAliases[alias] = true
+	}
+
+	// Validate dependency references; whether they exist is checked separately
+	seenDependencies := make(map[string]bool)
+	for _, dep := range agent.DependsOn {
+		if dep == "" || dep == agent.Name || containsString(agent.Aliases, dep) || seenDependencies[dep] {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid dependency",
+				Details: fmt.Sprintf("'%s' cannot be a dependency: dependencies must be non-empty, distinct and name other agents", dep),
+				Fields:  map[string]string{"dependsOn": fmt.Sprintf("invalid dependency '%s'", dep)},
+			}
+		}
+		seenDependencies[dep] = true
+	}
+
+	// Validate tags against the configured vocabulary, if any
+	if len(cfg.AllowedTags) > 0 {
+		fields := make(map[string]string)
+		var disallowed []string
+		for i, tag := range agent.Tags {
+			if !containsString(cfg.AllowedTags, tag) {
+				fields[fmt.Sprintf("tags/%d", i)] = fmt.Sprintf("'%s' is not an allowed tag", tag)
+				disallowed = append(disallowed, tag)
+			}
+		}
+		if len(disallowed) > 0 {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Tags not allowed",
+				Details: fmt.Sprintf("Tags outside the allowed vocabulary: %s", strings.Join(disallowed, ", ")),
+				Fields:  fields,
+			}
+		}
+	}
+
+	// Validate support details; contact is free-form, e.g. an email address
+	// or chat channel, so only its length is checked
+	if agent.Contact != "" && (strings.TrimSpace(agent.Contact) == "" || utf8.RuneCountInString(agent.Contact) > maxContactLength) {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid contact",
+			Details: fmt.Sprintf("contact must not be blank or longer than %d characters", maxContactLength),
+			Fields:  map[string]string{"contact": "must not be blank or too long"},
+		}
+	}
+	if agent.DocsURL != "" {
+		if err := sharewoodapi.ValidateBaseURL(agent.DocsURL, false); err != nil {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid docs URL",
+				Details: err.Error(),
+				Fields:  map[string]string{"docsUrl": err.Error()},
+			}
+		}
+	}
+	if agent.IconURL != "" {
+		if err := sharewoodapi.ValidateBaseURL(agent.IconURL, false); err != nil {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid icon URL",
+				Details: err.Error(),
+				Fields:  map[string]string{"iconUrl": err.Error()},
+			}
+		}
+	}
+
+	if errResp := validateMetadata(agent.Metadata); errResp != nil {
+		return errResp
+	}
+
+	// Validate lifecycle state
+	if agent.Lifecycle != "" && !sharewoodapi.IsValidLifecycle(agent.Lifecycle) {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid lifecycle",
+			Details: "lifecycle must be one of draft, active, deprecated, retired",
+		}
+	}
+
+	// Validate TTL bounds
+	if agent.TTL < 0 {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid TTL",
+			Details: "ttl must not be negative",
+		}
+	}
+	if agent.TTL > 0 && (agent.TTL < int64(cfg.MinTTL/time.Second) || agent.TTL > int64(cfg.MaxTTL/time.Second)) {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid TTL",
+			Details: fmt.Sprintf("ttl must be between %d and %d seconds", int64(cfg.MinTTL/time.Second), int64(cfg.MaxTTL/time.Second)),
+		}
+	}
+
+	// Zero leaves the weight at its default
+	if agent.Weight < 0 || agent.Weight > sharewoodapi.MaxAgentWeight {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid weight",
+			Details: fmt.Sprintf("weight must be between 1 and %d", sharewoodapi.MaxAgentWeight),
+			Fields:  map[string]string{"weight": "out of range"},
+		}
+	}
+
+	// Validate the HTTP check. Alongside a TTL each creates a check of its
+	// own, so both are only accepted when asked for explicitly.
+	if agent.HealthCheckURL != "" {
+		if err := sharewoodapi.ValidateBaseURL(agent.HealthCheckURL, false); err != nil {
+			return &sharewoodapi.ErrorResponse{
+				Error:   "Invalid health check URL",
+				Details: err.Error(),
+				Fields:  map[string]string{"healthCheckUrl": err.Error()},
+			}
+		}
+	}
+	if agent.TTL > 0 && agent.HealthCheckURL != "" && !agent.AllowMultipleChecks {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Ambiguous health checks",
+			Details: "ttl and healthCheckUrl each create a health check, and the agent's health would be the worse of the two; give only one, or set allowMultipleChecks to register both",
+			Fields:  map[string]string{"healthCheckUrl": "conflicts with ttl unless allowMultipleChecks is set"},
+		}
+	}
+
+	return nil
+}
+
+// Helper function to build the Consul service registration for an agent
+func agentRegistration(agent sharewoodapi.Agent) *api.AgentServiceRegistration {
+	// Create metadata map with essential fields only
+	metadata := map[string]string{
+		"Description": agent.Description,
+		"howtouse":    agent.HowToUse,
+		"baseurl":     agent.BaseURL,
+	}
+
+	// Add owner if known
+	if agent.Owner != "" {
+		metadata["owner"] = agent.Owner
+	}
+
+	// Add lifecycle state if present
+	if agent.Lifecycle != "" {
+		metadata["lifecycle"] = agent.Lifecycle
+	}
+
+	// Add support details if present
+	if agent.Contact != "" {
+		metadata["contact"] = agent.Contact
+	}
+	if agent.DocsURL != "" {
+		metadata["docsUrl"] = agent.DocsURL
+	}
+	if agent.IconURL != "" {
+		metadata["iconUrl"] = agent.IconURL
+	}
+
+	// Add custom metadata under its own prefix
+	for key, value := range agent.Metadata {
+		metadata[metadataMetaPrefix+key] = value
+	}
+
+	// Add environment and instance ID if present; they are part of the
+	// agent's identity
+	if agent.Environment != "" {
+		metadata["environment"] = agent.Environment
+	}
+	if agent.InstanceID != "" {
+		metadata["instanceId"] = agent.InstanceID
+	}
+
+	// Store aliases and dependencies as JSON arrays
+	setMetaStringList(metadata, "aliases", agent.Aliases)
+	setMetaStringList(metadata, "baseurls", fallbackBaseURLs(agent))
+	setMetaStringList(metadata, "dependsOn", agent.DependsOn)
+	
+	// Add expiration if present
+	if !agent.Expiration.IsZero() {
+		metadata["expiration"] = agent.Expiration.Format(time.RFC3339)
+	}
+	
+	// Add release if present
+	if agent.Release != "" {
+		metadata["release"] = agent.Release
+	}
+	
+	// Store OpenAPI spec
+	if agent.OpenAPI != "" {
+		metadata["openapi"] = agent.OpenAPI
+	}
+	
+	// Store registration timestamps
+	if agent.CreatedAt != nil {
+		metadata["createdAt"] = agent.CreatedAt.Format(time.RFC3339)
+	}
+	if agent.UpdatedAt != nil {
+		metadata["updatedAt"] = agent.UpdatedAt.Format(time.RFC3339)
+	}
+
+	// Prepare service registration
+	registration := &api.AgentServiceRegistration{
+		ID:   agentInstanceServiceID(agent.Name, agent.Environment, agent.InstanceID),
+		Name: agent.Name,
+		// The agent's own tags are stored as native service tags
+		Tags: append([]string{cfg.AgentTag}, agent.Tags...),
+		Meta: metadata,
+	}
+
+	// Consul balances by the weight while the agent passes, and by its
+	// default of 1 while it warns
+	if agent.Weight > 0 {
+		registration.Weights = &api.AgentWeights{
+			Passing: agent.Weight,
+			Warning: 1,
+		}
+	}
+
+	// Keep the check settings in metadata so the checks can be recreated on
+	// update
+	if agent.TTL > 0 {
+		metadata["ttl"] = strconv.FormatInt(agent.TTL, 10)
+	}
+	if agent.HealthCheckURL != "" {
+		metadata["healthCheckUrl"] = agent.HealthCheckURL
+	}
+	if agent.AllowMultipleChecks {
+		metadata["allowMultipleChecks"] = "true"
+	}
+	registration.Checks = agentChecks(agent, registration.ID)
+
+	return registration
+}
+
+// agentChecks returns the Consul checks of an agent registered under
+// serviceID: a TTL check, an HTTP check, or both. The TTL check keeps the
+// ID Consul gives the only check of a service, so health updates find it
+// whether or not the agent also has an HTTP check.
+func agentChecks(agent sharewoodapi.Agent, serviceID string) api.AgentServiceChecks {
+	var checks api.AgentServiceChecks
+	if agent.TTL > 0 {
+		ttlDuration := time.Duration(agent.TTL) * time.Second
+		checks = append(checks, &api.AgentServiceCheck{
+			CheckID: ttlCheckID(serviceID),
+			TTL:     ttlDuration.String(),
+			Notes:   "TTL for the AI agent service",
+		})
+	}
+	if agent.HealthCheckURL != "" {
+		checks = append(checks, &api.AgentServiceCheck{
+			CheckID:  httpCheckID(serviceID),
+			HTTP:     agent.HealthCheckURL,
+			Interval: cfg.HealthCheckInterval.String(),
+			Timeout:  cfg.HealthCheckTimeout.String(),
+			Notes:    "HTTP health check of the AI agent service",
+		})
+	}
+	return checks
+}
+
+// ttlCheckID and httpCheckID are the IDs of the checks agentChecks returns
+func ttlCheckID(serviceID string) string {
+	return "service:" + serviceID
+}
+
+func httpCheckID(serviceID string) string {
+	return "service:" + serviceID + ":http"
+}
+
+// Agent Registration endpoint - Updated to use sharewoodapi.Agent
+func registerAgent(c *gin.Context) {
+	var agent sharewoodapi.Agent
+	if !decodeAgentBody(c, &agent) {
+		return
+	}
+	applyAgentDefaults(&agent)
+
+	if errResp := validateAgent(agent); errResp != nil {
+		respondError(c, http.StatusBadRequest, *errResp)
+		return
+	}
+	if !authorizeAgentTags(c, nil, agent.Tags) {
 		return
 	}
 	
-	// If we get here, the agent exists, so we can fetch its details
-	services, err := consulClient.Agent().Services()
+	// With ifNotExists, hold a cluster-wide lock on the name across the
+	// existence check and the registration, so that of several concurrent
+	// creates of the same agent exactly one succeeds
+	if val := c.Query("ifNotExists"); val != "" {
+		ifNotExists, err := strconv.ParseBool(val)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid ifNotExists",
+				Details: "ifNotExists must be true or false",
+			})
+			return
+		}
+		if ifNotExists {
+			release, acquired, err := lockAgentName(c.Request.Context(), agentInstanceServiceID(agent.Name, agent.Environment, agent.InstanceID))
+			if err != nil {
+				log.Printf("Error locking agent name: %v", err)
+				respondConsulError(c, "Failed to lock agent name", err)
+				return
+			}
+			if !acquired {
+				respondError(c, http.StatusConflict, sharewoodapi.ErrorResponse{
+					Code:    sharewoodapi.CodeAgentConflict,
+					Error:   "Agent already exists",
+					Details: fmt.Sprintf("An agent with the name '%s' is being registered by another request", agent.Name),
+				})
+				return
+			}
+			defer release()
+		}
+	}
+
+	// Check if an agent with this name already exists
+	exists, err := agentExists(c.Request.Context(), agent.Name, agent.Environment, agent.InstanceID)
 	if err != nil {
-		log.Printf("Error getting agent: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to get agent",
-			Details: err.Error(),
+		log.Printf("Error checking existing agents: %v", err)
+		respondConsulError(c, "Failed to check if agent already exists", err)
+		return
+	}
+
+	if exists {
+		similar, err := similarAgentNames(c.Request.Context(), agent.Name)
+		if err != nil {
+			// The list is informational; report the conflict without it
+			log.Printf("Error finding similar agent names: %v", err)
+		}
+		details := fmt.Sprintf("An agent with the name '%s' is already registered", agent.Name)
+		if agent.InstanceID != "" {
+			details = fmt.Sprintf("An agent with the name '%s' is already registered without instances, or with the instance '%s'", agent.Name, agent.InstanceID)
+		}
+		respondError(c, http.StatusConflict, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentConflict,
+			Error:   "Agent already exists",
+			Details: details,
+			Similar: similar,
 		})
 		return
 	}
+	
+	// Neither the name nor any alias may already be claimed by another agent
+	if !checkNameClaims(c, agent, "") {
+		return
+	}
 
-	for _, service := range services {
-		if service.Service == name {
-			// Check if it's an AI agent
-			isAIAgent := false
-			for _, tag := range service.Tags {
-				if tag == "ai-agent" {
-					isAIAgent = true
-					break
-				}
+	dependencyWarnings, ok := checkAgentDependencies(c, agent)
+	if !ok {
+		return
+	}
+
+	// Default the owner to the authenticated identity
+	if agent.Owner == "" {
+		agent.Owner = callerIdentity(c)
+	}
+
+	// Timestamps are maintained by the registry, not the caller
+	agent.CreatedAt = timestampNow()
+	agent.UpdatedAt = agent.CreatedAt
+	agent.ContentHash = sharewoodapi.AgentContentHash(agent)
+
+	registration := agentRegistration(agent)
+	warnings := append(registrationWarnings(c.Request.Context(), agent), dependencyWarnings...)
+
+	// A dry run validates the agent and reports the server's limits without registering
+	if c.Query("dryRun") == "true" {
+		c.JSON(http.StatusOK, sharewoodapi.DryRunResponse{
+			Agent:   agent,
+			Message: "Agent is valid and can be registered",
+			TTLBounds: sharewoodapi.TTLBounds{
+				Min: int64(cfg.MinTTL / time.Second),
+				Max: int64(cfg.MaxTTL / time.Second),
+			},
+			RequiredFields: cfg.RequiredFields,
+			Warnings:       warnings,
+		})
+		return
+	}
+
+	if err := consulServiceRegister(c.Request.Context(), registration); err != nil {
+		log.Printf("Error registering agent: %v", err)
+		respondConsulError(c, "Failed to register agent", err)
+		return
+	}
+	agentChanged(c.Request.Context(), nil, registration.ID, &agent)
+	// Report the agent as reads will, e.g. with its tags sorted
+	agent = storedAgent(registration.ID, agent)
+
+	c.Header("Location", agentLocation(agent))
+
+	// Return the response in the expected format
+	c.JSON(http.StatusCreated, sharewoodapi.AgentRegistrationResponse{
+		Agent:    agent,
+		Message:  "Agent registered successfully",
+		Warnings: warnings,
+	})
+}
+
+// Maximum time a blocking list request may wait for changes
+const maxWatchWait = 5 * time.Minute
+
+// extendWriteDeadline pushes the response write deadline d into the future,
+// for handlers that legitimately run longer than the server's WriteTimeout
+func extendWriteDeadline(c *gin.Context, d time.Duration) {
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Now().Add(d)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("Failed to extend write deadline: %v", err)
+	}
+}
+
+// waitForAgentChanges performs a Consul blocking query on the service catalog
+// and returns the catalog index once it moves past waitIndex or wait elapses
+func waitForAgentChanges(ctx context.Context, waitIndex uint64, wait time.Duration) (uint64, error) {
+	_, meta, err := consulCatalogServices(ctx, &api.QueryOptions{
+		WaitIndex: waitIndex,
+		WaitTime:  wait,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to watch agents: %w", err)
+	}
+	return meta.LastIndex, nil
+}
+
+// List Agents endpoint - Updated to return format expected by client.
+//
+// When Consul is degraded and only part of the data can be read, the agents
+// that could be read are still returned with 200. The response is then an
+// AgentList object with degraded set and warnings describing what is missing,
+// instead of the usual bare array, and carries a Warning header. Currently
+// this happens when agent health cannot be read. The endpoint fails with 500
+// only when the agents themselves cannot be read.
+//
+// With preparedQuery the agents are instead those found by a Consul prepared
+// query, see listAgentsByPreparedQuery.
+func listAgents(c *gin.Context) {
+	if name, ok := c.GetQuery("preparedQuery"); ok {
+		listAgentsByPreparedQuery(c, name)
+		return
+	}
+
+	stream, ok := streamRequested(c)
+	if !ok {
+		return
+	}
+
+	// Blocking query support: when an index is supplied, wait until the
+	// registry changes past it and report the new index to the caller
+	if indexStr, ok := c.GetQuery("index"); ok {
+		waitIndex, err := strconv.ParseUint(indexStr, 10, 64)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid index",
+				Details: "index must be a non-negative integer",
+			})
+			return
+		}
+
+		wait := maxWatchWait
+		if waitStr := c.Query("wait"); waitStr != "" {
+			wait, err = time.ParseDuration(waitStr)
+			if err != nil || wait <= 0 {
+				respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+					Error:   "Invalid wait",
+					Details: "wait must be a positive duration such as 30s",
+				})
+				return
+			}
+			if wait > maxWatchWait {
+				wait = maxWatchWait
 			}
+		}
 
-			if isAIAgent {
-				// Build agent with proper sharewoodapi.Agent type
-				agent := sharewoodapi.Agent{
-					Name:        service.Service,
-					Description: service.Meta["Description"],
-					HowToUse:    service.Meta["howtouse"],
-					BaseURL:     service.Meta["baseurl"],
-				}
-				
-				// Add release if it exists
-				if val, ok := service.Meta["release"]; ok && val != "" {
-					agent.Release = val
-				}
-				
-				// Use consistent field name for OpenAPI
-				if val, ok := service.Meta["openapi"]; ok && val != "" {
-					agent.OpenAPI = val
-				}
-				
-				// Add expiration if available
-				if val, ok := service.Meta["expiration"]; ok && val != "" {
-					if t, err := time.Parse(time.RFC3339, val); err == nil {
-						agent.Expiration = t
-					}
-				}
-				
-				// Process tags
-				agent.Tags = make([]string, 0)
-				// First add tags from meta if present
-				if val, ok := service.Meta["tags"]; ok && val != "" {
-					agent.Tags = append(agent.Tags, decodeStringToArray(val)...)
-				}
-				// Then add any tags from service that aren't the "ai-agent" tag
-				for _, tag := range service.Tags {
-					if tag != "ai-agent" {
-						// Check if tag is already in the list
-						found := false
-						for _, existingTag := range agent.Tags {
-							if existingTag == tag {
-								found = true
-								break
-							}
-						}
-						if !found {
-							agent.Tags = append(agent.Tags, tag)
-						}
-					}
-				}
-				
-				// Return in expected AgentResponse format
-				c.JSON(http.StatusOK, sharewoodapi.AgentResponse{
-					Agent: agent,
+		extendWriteDeadline(c, wait+cfg.WriteTimeout)
+		lastIndex, err := waitForAgentChanges(c.Request.Context(), waitIndex, wait)
+		if err != nil {
+			log.Printf("Error watching agents: %v", err)
+			respondConsulError(c, "Failed to watch agents", err)
+			return
+		}
+		c.Header(sharewoodapi.IndexHeader, strconv.FormatUint(lastIndex, 10))
+	}
+
+	services, err := consulServices(c.Request.Context())
+	if err != nil {
+		log.Printf("Error listing agents: %v", err)
+		respondConsulError(c, "Failed to list agents", err)
+		return
+	}
+
+	owner := c.Query("owner")
+	lifecycle := c.Query("lifecycle")
+	if lifecycle != "" && !sharewoodapi.IsValidLifecycle(lifecycle) {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid lifecycle",
+			Details: "lifecycle must be one of draft, active, deprecated, retired",
+		})
+		return
+	}
+
+	environment := c.Query("env")
+	tag := c.Query("tag")
+	metadata := metadataFilters(c)
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	var hasOpenAPI *bool
+	if val := c.Query("hasOpenapi"); val != "" {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid hasOpenapi",
+				Details: "hasOpenapi must be true or false",
+			})
+			return
+		}
+		hasOpenAPI = &b
+	}
+
+	// Release filters compare each agent's release as a semantic version.
+	// Agents whose release is not semver never match them.
+	var releaseConstraint *sharewoodapi.VersionConstraint
+	if val := c.Query("releaseAtLeast"); val != "" {
+		constraint, err := sharewoodapi.ParseVersionConstraint(">=" + val)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid releaseAtLeast",
+				Details: "releaseAtLeast must be a semantic version such as 1.2.0",
+			})
+			return
+		}
+		releaseConstraint = &constraint
+	}
+	if val := c.Query("releaseRange"); val != "" {
+		if releaseConstraint != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid release filter",
+				Details: "releaseAtLeast and releaseRange cannot be combined",
+			})
+			return
+		}
+		constraint, err := sharewoodapi.ParseVersionConstraint(val)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid releaseRange",
+				Details: err.Error(),
+			})
+			return
+		}
+		releaseConstraint = &constraint
+	}
+
+	sortBy := c.Query("sort")
+	if sortBy != "" && sortBy != "name" && sortBy != "createdAt" && sortBy != "updatedAt" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid sort",
+			Details: "sort must be one of name, createdAt, updatedAt",
+		})
+		return
+	}
+	order := c.DefaultQuery("order", "asc")
+	if order != "asc" && order != "desc" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid order",
+			Details: "order must be 'asc' or 'desc'",
+		})
+		return
+	}
+
+	// Cursor pagination pages through the agents in sort order, by name
+	// unless another sort is given. The cursor holds the sort key of the last
+	// agent returned.
+	_, hasCursor := c.GetQuery("cursor")
+	_, hasLimit := c.GetQuery("limit")
+	paginate := hasCursor || hasLimit
+	pageSort := sortBy
+	if pageSort == "" {
+		pageSort = "name"
+	}
+	var limit int
+	var position *sharewoodapi.Agent
+	if paginate {
+		if stream {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid pagination",
+				Details: "cursor and limit cannot be combined with stream",
+			})
+			return
+		}
+		if limit, err = parsePageLimit(c.Query("limit")); err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid limit",
+				Details: err.Error(),
+			})
+			return
+		}
+		if token := c.Query("cursor"); token != "" {
+			cursorAgent, err := decodeListCursor(token, pageSort, order, query)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+					Error:   "Invalid cursor",
+					Details: err.Error(),
 				})
 				return
 			}
+			position = &cursorAgent
+		}
+	}
+
+	// With the KV index enabled, the tag and owner filters narrow the agents
+	// through it rather than inspecting each one. A failed lookup falls back
+	// to the full scan below.
+	var candidates map[string]bool
+	if cfg.KVIndex && (tag != "" || owner != "") {
+		candidates, err = indexedServiceIDs(c.Request.Context(), tag, owner)
+		if err != nil {
+			log.Printf("Error reading agent index, scanning all agents: %v", err)
+		}
+	}
+
+	// Health for every agent comes from a single scan of the checks, joined in
+	// memory, so listing costs the same number of Consul calls for any number
+	// of agents
+	warnings := make([]string, 0)
+	statuses, err := agentHealthStatuses(c.Request.Context())
+	if err != nil {
+		// Health is informational; still list the agents
+		log.Printf("Error reading agent health: %v", err)
+		warnings = append(warnings, "agent health could not be read from Consul and is omitted")
+	}
+	degraded := len(warnings) > 0
+	if degraded {
+		c.Header("Warning", fmt.Sprintf("199 sharewood %q", strings.Join(warnings, "; ")))
+	}
+
+	// Unless the agents must be sorted or ranked first, a stream writes each
+	// agent as soon as it is built instead of collecting them
+	var send func(sharewoodapi.Agent) bool
+	if stream && sortBy == "" && query == "" {
+		send = startNDJSONStream(c)
+	}
+
+	// Only AI agents are listed, the instances of a scaled agent together
+	agents := make([]sharewoodapi.Agent, 0)
+	for _, instances := range groupAgentInstances(services) {
+		if candidates != nil && !anyCandidate(instances, candidates) {
+			continue
+		}
+
+		agent := instancesToAgent(instances, statuses)
+		if owner != "" && agent.Owner != owner {
+			continue
+		}
+		if lifecycle != "" && agent.Lifecycle != lifecycle {
+			continue
+		}
+		if environment != "" && agent.Environment != environment {
+			continue
+		}
+		if tag != "" && !hasTag(agent, tag) {
+			continue
+		}
+		if hasOpenAPI != nil && (agent.OpenAPI != "") != *hasOpenAPI {
+			continue
+		}
+		if releaseConstraint != nil && !sharewoodapi.ReleaseSatisfies(agent, *releaseConstraint) {
+			continue
+		}
+		if !matchesMetadata(agent, metadata) {
+			continue
+		}
+		if query != "" && !agentMatchesQuery(agent, query) {
+			continue
+		}
+
+		if send != nil {
+			if !send(agent) {
+				return
+			}
+			continue
+		}
+		agents = append(agents, agent)
+	}
+	if send != nil {
+		return
+	}
+
+	if sortBy != "" {
+		sortAgents(agents, sortBy, order == "desc")
+	} else if query != "" {
+		sortAgents(agents, "name", false)
+	}
+	if query != "" {
+		rankExactNameMatches(agents, query)
+	}
+
+	if paginate {
+		less := func(a, b sharewoodapi.Agent) bool {
+			if query != "" {
+				exactA, exactB := strings.ToLower(a.Name) == query, strings.ToLower(b.Name) == query
+				if exactA != exactB {
+					return exactA
+				}
+			}
+			return agentLess(a, b, pageSort, order == "desc")
+		}
+		sort.SliceStable(agents, func(i, j int) bool { return less(agents[i], agents[j]) })
+
+		page, nextCursor := agentsPage(agents, position, limit, less, func(last sharewoodapi.Agent) string {
+			return encodeListCursor(last, pageSort, order, query)
+		})
+		if envelopeRequested(c) {
+			setEnvelopeMeta(c, sharewoodapi.EnvelopeMeta{
+				Degraded:   degraded,
+				Warnings:   warnings,
+				NextCursor: nextCursor,
+			})
+			c.JSON(http.StatusOK, page)
+			return
+		}
+		response := sharewoodapi.AgentPage{
+			Agents:     page,
+			NextCursor: nextCursor,
+			Degraded:   degraded,
+		}
+		if degraded {
+			response.Warnings = warnings
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	if stream {
+		streamAgentsNDJSON(c, len(agents), func(i int) sharewoodapi.Agent { return agents[i] })
+		return
+	}
+
+	// Inside an envelope the agents are always a plain array, with any
+	// degradation reported in the metadata
+	if envelopeRequested(c) {
+		if degraded {
+			setEnvelopeMeta(c, sharewoodapi.EnvelopeMeta{Degraded: true, Warnings: warnings})
+		}
+		c.JSON(http.StatusOK, agents)
+		return
+	}
+
+	if degraded {
+		c.JSON(http.StatusOK, sharewoodapi.AgentList{
+			Agents:   agents,
+			Degraded: true,
+			Warnings: warnings,
+		})
+		return
+	}
+
+	// Return the agents array directly to match client expectations
+	c.JSON(http.StatusOK, agents)
+}
+
+// anyCandidate reports whether any of an agent's services is among the
+// candidates found through the KV index
+func anyCandidate(instances []*api.AgentService, candidates map[string]bool) bool {
+	for _, service := range instances {
+		if candidates[service.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+// streamRequested reports whether the request asks for a streamed response
+// with stream=ndjson, the only supported format. An unsupported format is
+// rejected with 400 and ok is false.
+func streamRequested(c *gin.Context) (stream bool, ok bool) {
+	format := c.Query("stream")
+	if format == "" {
+		return false, true
+	}
+	if format != "ndjson" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Unsupported stream format",
+			Details: "stream must be 'ndjson'",
+		})
+		return false, false
+	}
+	return true, true
+}
+
+// streamAgentsNDJSON writes count agents as newline-delimited JSON, producing
+// each with agentAt just before it is sent. Each agent is written and flushed
+// individually instead of encoding the whole array at once.
+func streamAgentsNDJSON(c *gin.Context, count int, agentAt func(int) sharewoodapi.Agent) {
+	send := startNDJSONStream(c)
+	for i := 0; i < count; i++ {
+		if !send(agentAt(i)) {
+			return
+		}
+	}
+}
+
+// startNDJSONStream starts a newline-delimited JSON response and returns a
+// function writing and flushing one agent, which reports false once the
+// agent could not be written
+func startNDJSONStream(c *gin.Context) func(sharewoodapi.Agent) bool {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	return func(agent sharewoodapi.Agent) bool {
+		extendWriteDeadline(c, cfg.WriteTimeout)
+		if err := encoder.Encode(agent); err != nil {
+			log.Printf("Error streaming agents: %v", err)
+			return false
 		}
+		c.Writer.Flush()
+		return true
 	}
+}
 
-	c.JSON(http.StatusNotFound, sharewoodapi.ErrorResponse{
-		Error: "Agent not found",
+// agentMatchesQuery reports whether the lowercase query is a substring of the
+// agent's name, description or usage instructions. This is a plain scan of
+// each agent, not an indexed full-text search.
+func agentMatchesQuery(agent sharewoodapi.Agent, query string) bool {
+	return strings.Contains(strings.ToLower(agent.Name), query) ||
+		strings.Contains(strings.ToLower(agent.Description), query) ||
+		strings.Contains(strings.ToLower(agent.HowToUse), query)
+}
+
+// rankExactNameMatches moves agents whose name equals the lowercase query to
+// the front, keeping the existing order otherwise
+func rankExactNameMatches(agents []sharewoodapi.Agent, query string) {
+	sort.SliceStable(agents, func(i, j int) bool {
+		return strings.ToLower(agents[i].Name) == query && strings.ToLower(agents[j].Name) != query
 	})
 }
 
-// Unregister Agent endpoint - Updated to use standard error responses
+// sortAgents orders agents by name, createdAt or updatedAt. Agents without
+// timestamps, registered before they were recorded, sort as the oldest.
+// Ties are broken by name.
+func sortAgents(agents []sharewoodapi.Agent, sortBy string, descending bool) {
+	sort.SliceStable(agents, func(i, j int) bool {
+		return agentLess(agents[i], agents[j], sortBy, descending)
+	})
+}
+
+// agentLess orders two agents as sortAgents does. Agents are unique per name
+// and environment, so this is a total order.
+func agentLess(a, b sharewoodapi.Agent, sortBy string, descending bool) bool {
+	timestamp := func(agent sharewoodapi.Agent) time.Time {
+		var t *time.Time
+		if sortBy == "createdAt" {
+			t = agent.CreatedAt
+		} else {
+			t = agent.UpdatedAt
+		}
+		if t == nil {
+			return time.Time{}
+		}
+		return *t
+	}
+
+	if descending {
+		a, b = b, a
+	}
+	if sortBy != "name" {
+		ta, tb := timestamp(a), timestamp(b)
+		if !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Environment < b.Environment
+}
+
+// Get Agent endpoint - Updated to return format expected by client. The
+// optional env query parameter selects the agent's environment variant. An
+// alias resolves to its agent, which is returned under its primary name.
+func getAgent(c *gin.Context) {
+	name := c.Param("name")
+
+	service, err := resolveAgentService(c.Request.Context(), name, c.Query("env"))
+	if err != nil {
+		log.Printf("Error getting agent: %v", err)
+		respondConsulError(c, "Failed to get agent", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+		})
+		return
+	}
+
+	// A scaled agent is returned with all its instances
+	instances := []*api.AgentService{service}
+	if service.Meta["instanceId"] != "" {
+		instances, err = findAgentInstances(c.Request.Context(), service.Service, service.Meta["environment"])
+		if err != nil {
+			log.Printf("Error getting agent: %v", err)
+			respondConsulError(c, "Failed to get agent", err)
+			return
+		}
+	}
+
+	statuses, err := agentHealthStatuses(c.Request.Context())
+	if err != nil {
+		// Health is informational; still return the agent
+		log.Printf("Error reading agent health: %v", err)
+	}
+
+	// Return in expected AgentResponse format
+	agent := instancesToAgent(instances, statuses)
+	c.Header("ETag", agentETag(agent))
+
+	c.JSON(http.StatusOK, sharewoodapi.AgentResponse{
+		Agent: agent,
+	})
+}
+
+// Unregister Agent endpoint - Updated to use standard error responses. The
+// instances of a scaled agent are deregistered one at a time, selected with
+// the instance query parameter. Admins can force the deregistration with
+// force=true, see forceDeregisterAgent.
 func unregisterAgent(c *gin.Context) {
 	name := c.Param("name")
+	if val := c.Query("force"); val != "" {
+		force, err := strconv.ParseBool(val)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid force",
+				Details: "force must be true or false",
+			})
+			return
+		}
+		if force {
+			forceDeregisterAgent(c, name)
+			return
+		}
+	}
 	
 	// Verify the agent exists before attempting to deregister
-	exists, err := agentExists(name)
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
 	if err != nil {
 		log.Printf("Error checking agent existence: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to check agent existence",
-			Details: err.Error(),
-		})
+		respondConsulError(c, "Failed to check agent existence", err)
 		return
 	}
 
-	if !exists {
-		c.JSON(http.StatusNotFound, sharewoodapi.ErrorResponse{
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
 			Error:   "Agent not found",
 			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
 		})
 		return
 	}
 
-	if err := consulClient.Agent().ServiceDeregister(name); err != nil {
+	// Optionally restrict deregistration to the agent's owner or an admin
+	if cfg.RestrictDeregisterToOwner && sharewoodapi.Role(c.GetString("role")) != sharewoodapi.RoleAdmin {
+		owner := service.Meta["owner"]
+		if owner != "" && owner != callerIdentity(c) {
+			respondError(c, http.StatusForbidden, sharewoodapi.ErrorResponse{
+				Error:   "Insufficient permissions",
+				Details: fmt.Sprintf("Only the owner '%s' or an admin can deregister this agent", owner),
+			})
+			return
+		}
+	}
+
+	if err := deregisterAgentService(c.Request.Context(), service.ID); err != nil {
 		log.Printf("Error unregistering agent: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to unregister agent",
-			Details: err.Error(),
-		})
+		respondConsulError(c, "Failed to unregister agent", err)
 		return
 	}
+	agentChanged(c.Request.Context(), service, service.ID, nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Agent unregistered successfully"})
 }
 
 // Update Agent Health endpoint - Updated to use standard error responses
+//
+// The status is preferably sent as a JSON body, {"status": "passing"}; the
+// status query parameter is still accepted. If both are given they must agree.
+// Each instance of a scaled agent reports its own health, selected with the
+// instance query parameter.
 func updateAgentHealth(c *gin.Context) {
 	name := c.Param("name")
-	status := c.Query("status")
+	status := sharewoodapi.HealthStatus(c.Query("status"))
+
+	if c.Request.ContentLength != 0 {
+		var report sharewoodapi.HealthReport
+		if !decodeJSONBody(c, &report) {
+			return
+		}
+		if status != "" && report.Status != "" && report.Status != status {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Conflicting status",
+				Details: "The status in the body and the status query parameter differ",
+			})
+			return
+		}
+		if report.Status != "" {
+			status = report.Status
+		}
+	}
 
 	// Validate status
-	if status != "passing" && status != "warning" && status != "critical" {
-		c.JSON(http.StatusBadRequest, sharewoodapi.ErrorResponse{
-			Error: "Invalid status. Must be 'passing', 'warning', or 'critical'",
+	if status == "" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Missing status",
+			Details: "status parameter is required, in the JSON body or the query string",
+			Fields:  map[string]string{"status": "is required"},
+		})
+		return
+	}
+	if !sharewoodapi.IsValidHealthStatus(status) {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid status. Must be 'passing', 'warning', or 'critical'",
+			Details: fmt.Sprintf("status must be passing, warning or critical, not '%s'", status),
+			Fields:  map[string]string{"status": "must be passing, warning or critical"},
 		})
 		return
 	}
 	
 	// Check if the agent exists
-	exists, err := agentExists(name)
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
 	if err != nil {
 		log.Printf("Error checking agent existence: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to check agent existence",
-			Details: err.Error(),
-		})
+		respondConsulError(c, "Failed to check agent existence", err)
 		return
 	}
 	
-	if !exists {
-		c.JSON(http.StatusNotFound, sharewoodapi.ErrorResponse{
-			Error: "Agent not found",
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
 		})
 		return
 	}
 
-	checkID := "service:" + name
-	if err := consulClient.Agent().UpdateTTL(checkID, "", status); err != nil {
+	checkID := "service:" + service.ID
+	if err := consulUpdateTTL(c.Request.Context(), checkID, "", string(status)); err != nil {
 		log.Printf("Error updating agent health: %v", err)
-		c.JSON(http.StatusInternalServerError, sharewoodapi.ErrorResponse{
-			Error:   "Failed to update agent health",
-			Details: err.Error(),
-		})
+		respondConsulError(c, "Failed to update agent health", err)
 		return
 	}
+	recordHealthUpdate(service.ID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Agent health updated successfully"})
 }
@@ -0,0 +1,879 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// newTestRegistry starts a fake Consul and returns it with a registry handler
+// using it, configured from the environment's defaults. Requests are
+// authenticated as an admin through DEV_MODE.
+func newTestRegistry(t testing.TB) (*fakeConsul, http.Handler) {
+	t.Setenv("DEV_MODE", "true")
+	gin.SetMode(gin.TestMode)
+
+	cfg = loadServerConfig()
+	initAgentSchema(cfg.RequiredFields)
+	if err := initJWTVerifier(); err != nil {
+		t.Fatalf("Error initializing JWT verification: %v", err)
+	}
+	readOnly.Store(false)
+
+	consul := newFakeConsul()
+	t.Cleanup(consul.Close)
+	consulClient = consul.client("")
+	return consul, newHandler()
+}
+
+// roleHeaders turns off the DEV_MODE bypass and returns headers
+// authenticating as an admin and as an agent publisher, with HS256 tokens
+func roleHeaders(t *testing.T) (admin, publisher http.Header) {
+	t.Setenv("DEV_MODE", "")
+	t.Setenv("JWT_SECRET", "test-secret")
+	cfg.JWTAlgorithm = "HS256"
+	if err := initJWTVerifier(); err != nil {
+		t.Fatalf("initJWTVerifier: %v", err)
+	}
+
+	header := func(role sharewoodapi.Role) http.Header {
+		claims := testClaims()
+		claims.UserID = string(role) + "-1"
+		claims.Role = role
+		return http.Header{"Authorization": {"Bearer " + signToken(t, jwt.SigningMethodHS256, claims, []byte("test-secret"))}}
+	}
+	return header(sharewoodapi.RoleAdmin), header(sharewoodapi.RoleAgentPublisher)
+}
+
+// serve sends a request with an optional JSON body to the router and returns
+// the recorded response
+func serve(router http.Handler, method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// newTestClient returns a client of a registry router served over HTTP
+func newTestClient(t *testing.T, router http.Handler) *sharewoodapi.ConsulClient {
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	options := sharewoodapi.DefaultOptions()
+	options.ServerURL = server.URL
+	client := sharewoodapi.NewClient(options)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// testAgent returns a valid agent definition named name
+func testAgent(name string) sharewoodapi.Agent {
+	return sharewoodapi.Agent{
+		Name:        name,
+		Description: "Answers geography questions",
+		BaseURL:     "http://localhost:8080",
+		HowToUse:    "POST a question to /ask",
+		TTL:         60,
+		Tags:        []string{"geo", "demo"},
+	}
+}
+
+// registerTestAgent registers agent through the router, failing the test
+// unless it is created
+func registerTestAgent(t testing.TB, router http.Handler, agent sharewoodapi.Agent) {
+	t.Helper()
+	if resp := serve(router, http.MethodPost, "/api/v1/agents", agent, nil); resp.Code != http.StatusCreated {
+		t.Fatalf("registering %s: got %d: %s", agent.Name, resp.Code, resp.Body)
+	}
+}
+
+func TestOversizedBodyIsRejected(t *testing.T) {
+	_, router := newTestRegistry(t)
+	cfg.MaxBodyBytes = 1024
+	registerTestAgent(t, router, testAgent("small"))
+
+	large := testAgent("large")
+	large.HowToUse = strings.Repeat("x", 2048)
+	tests := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "/api/v1/agents", large},
+		{http.MethodPatch, "/api/v1/agents/small", map[string]string{"howtouse": large.HowToUse}},
+		{http.MethodPost, "/api/v1/agents/small/tags", map[string][]string{"add": {large.HowToUse}}},
+	}
+	for _, tt := range tests {
+		resp := serve(router, tt.method, tt.path, tt.body, nil)
+		if resp.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s: got %d, want 413: %s", tt.method, tt.path, resp.Code, resp.Body)
+			continue
+		}
+		var body sharewoodapi.ErrorResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil || body.Error != "Request body too large" {
+			t.Errorf("%s %s: got error body %s", tt.method, tt.path, resp.Body)
+		}
+	}
+}
+
+func TestConsulTokenIsSent(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	t.Setenv("CONSUL_ADDR", consul.URL)
+	t.Setenv("CONSUL_TOKEN", "registry-token")
+
+	client, err := initConsulClient()
+	if err != nil {
+		t.Fatalf("initConsulClient: %v", err)
+	}
+	consulClient = client
+	registerTestAgent(t, router, testAgent("weather"))
+
+	tokens := consul.requestTokens()
+	if len(tokens) == 0 {
+		t.Fatal("no requests reached Consul")
+	}
+	for i, token := range tokens {
+		if token != "registry-token" {
+			t.Fatalf("request %d sent token %q, want %q", i, token, "registry-token")
+		}
+	}
+}
+
+func TestConsulPermissionDenied(t *testing.T) {
+	_, router := newTestRegistry(t)
+	denied := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Permission denied: token lacks service:write", http.StatusForbidden)
+	}))
+	defer denied.Close()
+	t.Setenv("CONSUL_ADDR", denied.URL)
+	t.Setenv("CONSUL_TOKEN", "read-only-token")
+
+	if _, err := initConsulClient(); err == nil || !strings.Contains(err.Error(), "CONSUL_TOKEN") {
+		t.Fatalf("initConsulClient: got %v, want an error naming CONSUL_TOKEN", err)
+	}
+
+	client, err := api.NewClient(consulConfig())
+	if err != nil {
+		t.Fatalf("creating Consul client: %v", err)
+	}
+	consulClient = client
+	resp := serve(router, http.MethodPost, "/api/v1/agents", testAgent("weather"), nil)
+	if resp.Code != http.StatusBadGateway {
+		t.Fatalf("registering with a denied token: got %d, want 502: %s", resp.Code, resp.Body)
+	}
+}
+
+// newBenchmarkRegistry returns a registry holding n agents, with request and
+// server logging discarded for the duration of the benchmark
+func newBenchmarkRegistry(b *testing.B, n int) (*fakeConsul, http.Handler) {
+	ginWriter, logWriter := gin.DefaultWriter, log.Writer()
+	gin.DefaultWriter = io.Discard
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() {
+		gin.DefaultWriter = ginWriter
+		log.SetOutput(logWriter)
+	})
+
+	consul, router := newTestRegistry(b)
+	for i := 0; i < n; i++ {
+		agent := testAgent(fmt.Sprintf("agent-%04d", i))
+		agent.Tags = []string{fmt.Sprintf("team-%d", i%50), "demo"}
+		registerTestAgent(b, router, agent)
+	}
+	return consul, router
+}
+
+// BenchmarkListAgents lists 500 agents with their health, which is read with
+// one scan of the checks
+func BenchmarkListAgents(b *testing.B) {
+	_, router := newBenchmarkRegistry(b, 500)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if resp := serve(router, http.MethodGet, "/api/v1/agents", nil, nil); resp.Code != http.StatusOK {
+			b.Fatalf("got %d: %s", resp.Code, resp.Body)
+		}
+	}
+}
+
+// BenchmarkListAgentsHealthPerAgent builds the same list reading each agent's
+// health with a Consul call of its own, the N+1 approach listAgents avoids
+func BenchmarkListAgentsHealthPerAgent(b *testing.B) {
+	newBenchmarkRegistry(b, 500)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		services, err := consulServices(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		agents := make([]sharewoodapi.Agent, 0, len(services))
+		for _, service := range services {
+			statuses, err := agentHealthStatuses(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			agent := serviceToAgent(service)
+			agent.Health = serviceHealth(statuses, service.ID)
+			agents = append(agents, agent)
+		}
+		if _, err := json.Marshal(agents); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// getTestAgent reads an agent back through the router
+func getTestAgent(t testing.TB, router http.Handler, name string) sharewoodapi.Agent {
+	t.Helper()
+	resp := serve(router, http.MethodGet, "/api/v1/agents/"+url.PathEscape(name), nil, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("reading %s: got %d: %s", name, resp.Code, resp.Body)
+	}
+	var body sharewoodapi.AgentResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding %s: %v", name, err)
+	}
+	return body.Agent
+}
+
+func TestUpdateChangesOnlyUpdatedAt(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+	before := getTestAgent(t, router, "weather")
+	if before.CreatedAt == nil || before.UpdatedAt == nil {
+		t.Fatalf("timestamps missing after registration: %+v", before)
+	}
+
+	// Timestamps are kept to the second
+	time.Sleep(1100 * time.Millisecond)
+	resp := serve(router, http.MethodPatch, "/api/v1/agents/weather", map[string]string{"description": "Forecasts the weather"}, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("patching: got %d: %s", resp.Code, resp.Body)
+	}
+
+	after := getTestAgent(t, router, "weather")
+	if after.CreatedAt == nil || !after.CreatedAt.Equal(*before.CreatedAt) {
+		t.Errorf("createdAt changed from %v to %v", before.CreatedAt, after.CreatedAt)
+	}
+	if after.UpdatedAt == nil || !after.UpdatedAt.After(*before.UpdatedAt) {
+		t.Errorf("updatedAt did not advance from %v: %v", before.UpdatedAt, after.UpdatedAt)
+	}
+}
+
+func TestListStreamsOneAgentPerLine(t *testing.T) {
+	_, router := newTestRegistry(t)
+	for _, name := range []string{"weather", "maps", "news"} {
+		registerTestAgent(t, router, testAgent(name))
+	}
+
+	for _, path := range []string{"/api/v1/agents?stream=ndjson", "/api/v1/agents?stream=ndjson&sort=name"} {
+		resp := serve(router, http.MethodGet, path, nil, nil)
+		if resp.Code != http.StatusOK || resp.Header().Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("%s: got %d with %q", path, resp.Code, resp.Header().Get("Content-Type"))
+		}
+		names := make(map[string]bool)
+		decoder := json.NewDecoder(resp.Body)
+		for decoder.More() {
+			var agent sharewoodapi.Agent
+			if err := decoder.Decode(&agent); err != nil {
+				t.Fatalf("%s: decoding: %v", path, err)
+			}
+			names[agent.Name] = true
+		}
+		if len(names) != 3 {
+			t.Errorf("%s: streamed %v, want 3 agents", path, names)
+		}
+	}
+}
+
+func TestTrailingSlashIsServedLikeThePathWithout(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	for _, path := range []string{"/api/v1/agents", "/api/v1/agents/", "/api/v1/agents/weather", "/api/v1/agents/weather/"} {
+		resp := serve(router, http.MethodGet, path, nil, nil)
+		if resp.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want 200 without a redirect", path, resp.Code)
+			continue
+		}
+		if !strings.Contains(resp.Body.String(), `"name":"weather"`) {
+			t.Errorf("GET %s: weather missing from %s", path, resp.Body)
+		}
+	}
+}
+
+func TestPlainHTTPIsRejectedWhenHTTPSIsRequired(t *testing.T) {
+	t.Setenv("REQUIRE_HTTPS", "true")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+	_, router := newTestRegistry(t)
+
+	tests := []struct {
+		name, remoteAddr, proto, path string
+		want                          int
+	}{
+		{"plain http", "192.0.2.1:4000", "", "/api/v1/agents", http.StatusUpgradeRequired},
+		{"proxy forwarding http", "10.0.0.5:4000", "http", "/api/v1/agents", http.StatusUpgradeRequired},
+		{"proxy forwarding https", "10.0.0.5:4000", "https", "/api/v1/agents", http.StatusOK},
+		{"untrusted peer claiming https", "192.0.2.1:4000", "https", "/api/v1/agents", http.StatusUpgradeRequired},
+		{"health probe", "192.0.2.1:4000", "", "/health", http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		if resp.Code != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, resp.Code, tt.want)
+		}
+	}
+}
+
+func TestUnreachableConsulIsReportedAsUnavailable(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	// Requests to a closed server have their connection refused
+	consul.Close()
+
+	resp := serve(router, http.MethodGet, "/api/v1/agents/weather", nil, nil)
+	var body sharewoodapi.ErrorResponse
+	json.Unmarshal(resp.Body.Bytes(), &body)
+	if resp.Code != http.StatusServiceUnavailable || body.Error != sharewoodapi.BackendUnavailableError || body.Code != sharewoodapi.CodeBackendUnavailable {
+		t.Fatalf("got %d: %s, want 503 with the backend unavailable error", resp.Code, resp.Body)
+	}
+	if got := resp.Header().Get("Retry-After"); got != "5" {
+		t.Errorf("got Retry-After %q, want 5", got)
+	}
+
+	// Without a Retry-After the client reports the outage without retrying
+	cfg.BackendRetryAfter = 0
+	if _, err := newTestClient(t, router).GetAgent("weather"); !errors.Is(err, sharewoodapi.ErrBackendUnavailable) {
+		t.Fatalf("GetAgent: got %v, want ErrBackendUnavailable", err)
+	}
+}
+
+func TestHealthStatusFromBodyOrQuery(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+	checkStatus := func() string {
+		consul.mu.Lock()
+		defer consul.mu.Unlock()
+		return consul.checks["service:weather"].Status
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		body      interface{}
+		want      int
+		wantError string
+		status    string
+	}{
+		{"body", "", map[string]string{"status": "warning"}, http.StatusOK, "", "warning"},
+		{"query", "?status=passing", nil, http.StatusOK, "", "passing"},
+		{"missing", "", nil, http.StatusBadRequest, "Missing status", ""},
+		{"empty body", "", map[string]string{}, http.StatusBadRequest, "Missing status", ""},
+		{"invalid", "", map[string]string{"status": "sunny"}, http.StatusBadRequest, "Invalid status. Must be 'passing', 'warning', or 'critical'", ""},
+		{"conflicting", "?status=passing", map[string]string{"status": "critical"}, http.StatusBadRequest, "Conflicting status", ""},
+	}
+	for _, tt := range tests {
+		resp := serve(router, http.MethodPut, "/api/v1/agents/weather/health"+tt.query, tt.body, nil)
+		if resp.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, resp.Code, tt.want, resp.Body)
+			continue
+		}
+		if tt.wantError != "" {
+			var body sharewoodapi.ErrorResponse
+			json.Unmarshal(resp.Body.Bytes(), &body)
+			if body.Error != tt.wantError {
+				t.Errorf("%s: got error %q, want %q", tt.name, body.Error, tt.wantError)
+			}
+		}
+		if tt.status != "" && checkStatus() != tt.status {
+			t.Errorf("%s: check is %s, want %s", tt.name, checkStatus(), tt.status)
+		}
+	}
+}
+
+func TestContentHashFollowsDefiningFields(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	hash := getTestAgent(t, router, "weather").ContentHash
+	if hash == "" {
+		t.Fatal("no content hash reported")
+	}
+	if again := getTestAgent(t, router, "weather").ContentHash; again != hash {
+		t.Fatalf("hash changed between reads: %s, then %s", hash, again)
+	}
+
+	// Health and the order of tags do not define the agent
+	unchanged := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPut, "/api/v1/agents/weather/health", map[string]string{"status": "warning"}},
+		{http.MethodPatch, "/api/v1/agents/weather", map[string]interface{}{"tags": []string{"demo", "geo"}}},
+	}
+	for _, update := range unchanged {
+		if resp := serve(router, update.method, update.path, update.body, nil); resp.Code != http.StatusOK {
+			t.Fatalf("%s %s: got %d: %s", update.method, update.path, resp.Code, resp.Body)
+		}
+		if got := getTestAgent(t, router, "weather").ContentHash; got != hash {
+			t.Errorf("%s %s changed the hash", update.method, update.path)
+		}
+	}
+
+	patchTestAgent(t, router, "weather", map[string]interface{}{"description": "Forecasts the weather"})
+	if got := getTestAgent(t, router, "weather").ContentHash; got == hash {
+		t.Error("changing the description left the hash unchanged")
+	}
+}
+
+func TestTTLAndHTTPChecksNeedAllowMultipleChecks(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	checkTypes := func(serviceID string) map[string]bool {
+		consul.mu.Lock()
+		defer consul.mu.Unlock()
+		types := make(map[string]bool)
+		for _, check := range consul.checks {
+			if check.ServiceID == serviceID {
+				types[check.Type] = true
+			}
+		}
+		return types
+	}
+
+	tests := []struct {
+		name      string
+		ttl       int64
+		url       string
+		allow     bool
+		want      int
+		wantTypes []string
+	}{
+		{"ttl-only", 60, "", false, http.StatusCreated, []string{"ttl"}},
+		{"http-only", 0, "http://localhost:8080/health", false, http.StatusCreated, []string{"http"}},
+		{"both", 60, "http://localhost:8080/health", false, http.StatusBadRequest, nil},
+		{"both-allowed", 60, "http://localhost:8080/health", true, http.StatusCreated, []string{"ttl", "http"}},
+	}
+	for _, tt := range tests {
+		agent := testAgent(tt.name)
+		agent.TTL = tt.ttl
+		agent.HealthCheckURL = tt.url
+		agent.AllowMultipleChecks = tt.allow
+		resp := serve(router, http.MethodPost, "/api/v1/agents", agent, nil)
+		if resp.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, resp.Code, tt.want, resp.Body)
+			continue
+		}
+		if tt.want != http.StatusCreated {
+			var body sharewoodapi.ErrorResponse
+			json.Unmarshal(resp.Body.Bytes(), &body)
+			if body.Fields["healthCheckUrl"] == "" {
+				t.Errorf("%s: the error does not point at healthCheckUrl: %s", tt.name, resp.Body)
+			}
+			continue
+		}
+		types := checkTypes(tt.name)
+		if len(types) != len(tt.wantTypes) {
+			t.Errorf("%s: got checks %v, want %v", tt.name, types, tt.wantTypes)
+		}
+		for _, checkType := range tt.wantTypes {
+			if !types[checkType] {
+				t.Errorf("%s: no %s check among %v", tt.name, checkType, types)
+			}
+		}
+	}
+}
+
+func TestErrorsCarryStableCodes(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+	invalid := testAgent("storm")
+	invalid.Description = ""
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         interface{}
+		header       http.Header
+		want         string
+	}{
+		{"agent not found", http.MethodGet, "/api/v1/agents/nosuch", nil, nil, sharewoodapi.CodeAgentNotFound},
+		{"agent conflict", http.MethodPost, "/api/v1/agents", testAgent("weather"), nil, sharewoodapi.CodeAgentConflict},
+		{"validation failed", http.MethodPost, "/api/v1/agents", invalid, nil, sharewoodapi.CodeValidationFailed},
+		{"patch test failed", http.MethodPatch, "/api/v1/agents/weather",
+			[]map[string]string{{"op": "test", "path": "/description", "value": "Something else"}},
+			http.Header{"Content-Type": {jsonPatchMediaType}}, sharewoodapi.CodePatchTestFailed},
+		{"precondition failed", http.MethodPatch, "/api/v1/agents/weather", map[string]string{"description": "x"},
+			http.Header{"If-Match": {`"stale"`}}, sharewoodapi.CodePreconditionFailed},
+		{"unsupported media type", http.MethodPost, "/api/v1/agents", testAgent("storm"),
+			http.Header{"Content-Type": {"text/plain"}}, sharewoodapi.CodeUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		resp := serve(router, tt.method, tt.path, tt.body, tt.header)
+		var body sharewoodapi.ErrorResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil || body.Code != tt.want {
+			t.Errorf("%s: got %d with code %q, want %q: %s", tt.name, resp.Code, body.Code, tt.want, resp.Body)
+		}
+	}
+
+	// The client exposes the code on APIError
+	_, err := newTestClient(t, router).GetAgent("nosuch")
+	var apiErr *sharewoodapi.APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != sharewoodapi.CodeAgentNotFound {
+		t.Fatalf("GetAgent: got %v, want an APIError with code %s", err, sharewoodapi.CodeAgentNotFound)
+	}
+}
+
+func TestClientFollowsACustomBasePath(t *testing.T) {
+	t.Setenv("API_BASE_PATH", "/registry/v2/")
+	t.Setenv("HEALTH_PATH", "livez")
+	t.Setenv("METRICS_PATH", "/internal/metrics")
+	_, router := newTestRegistry(t)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	options := sharewoodapi.DefaultOptions()
+	options.ServerURL = server.URL
+	options.BasePath = "/registry/v2"
+	client := sharewoodapi.NewClient(options)
+	t.Cleanup(func() { client.Close() })
+
+	if _, err := client.RegisterAgent(testAgent("weather")); err != nil {
+		t.Fatalf("RegisterAgent failed: %v", err)
+	}
+	agent, err := client.GetAgent("weather")
+	if err != nil || agent.Name != "weather" {
+		t.Fatalf("GetAgent: got %+v, %v", agent, err)
+	}
+	if agents, err := client.ListAgents(); err != nil || len(agents) != 1 {
+		t.Fatalf("ListAgents: got %d agents, %v", len(agents), err)
+	}
+
+	for path, want := range map[string]int{
+		"/api/v1/agents":    http.StatusNotFound,
+		"/health":           http.StatusNotFound,
+		"/livez":            http.StatusOK,
+		"/internal/metrics": http.StatusOK,
+	} {
+		if resp := serve(router, http.MethodGet, path, nil, nil); resp.Code != want {
+			t.Errorf("GET %s: got %d, want %d", path, resp.Code, want)
+		}
+	}
+}
+
+func TestBaseURLMustBeAbsoluteHTTP(t *testing.T) {
+	_, router := newTestRegistry(t)
+
+	for url, want := range map[string]int{
+		"https://agents.example.com/weather": http.StatusCreated,
+		"/weather":                           http.StatusBadRequest,
+		"not a url":                          http.StatusBadRequest,
+		"grpc://agents.internal:9000":        http.StatusBadRequest,
+	} {
+		agent := testAgent("weather")
+		agent.BaseURL = url
+		resp := serve(router, http.MethodPost, "/api/v1/agents", agent, nil)
+		if resp.Code != want {
+			t.Errorf("base URL %q: got %d, want %d: %s", url, resp.Code, want, resp.Body)
+			continue
+		}
+		if want == http.StatusBadRequest {
+			var errResp sharewoodapi.ErrorResponse
+			json.Unmarshal(resp.Body.Bytes(), &errResp)
+			if errResp.Fields["baseurl"] == "" {
+				t.Errorf("base URL %q: no baseurl field error: %s", url, resp.Body)
+			}
+		} else {
+			serve(router, http.MethodDelete, "/api/v1/agents/weather", nil, nil)
+		}
+	}
+
+	cfg.AllowAnyURLScheme = true
+	agent := testAgent("internal")
+	agent.BaseURL = "grpc://agents.internal:9000"
+	registerTestAgent(t, router, agent)
+}
+
+func TestSlowClientIsDisconnected(t *testing.T) {
+	_, router := newTestRegistry(t)
+	cfg.ReadHeaderTimeout = 200 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(listener.Addr().String(), router)
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Send the start of a request and then nothing, like a slow-loris client
+	start := time.Now()
+	if _, err := conn.Write([]byte("GET /health HTTP/1.1\r\nHost: registry\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("the connection was not closed by the server: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < cfg.ReadHeaderTimeout {
+		t.Errorf("the connection was closed after %v, before the header timeout", elapsed)
+	}
+}
+
+func TestCustomAgentTagDiscriminatesAgents(t *testing.T) {
+	t.Setenv("AGENT_TAG", "sharewood-agent")
+	consul, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	consul.mu.Lock()
+	tags := consul.services["weather"].Tags
+	// Services of other users of the Consul, one carrying the default tag
+	consul.services["postgres"] = &api.AgentService{ID: "postgres", Service: "postgres", Tags: []string{"ai-agent"}}
+	consul.services["redis"] = &api.AgentService{ID: "redis", Service: "redis"}
+	consul.mu.Unlock()
+	if len(tags) == 0 || tags[0] != "sharewood-agent" {
+		t.Fatalf("registered with tags %v, want the custom discriminator", tags)
+	}
+	for _, tag := range tags {
+		if tag == "ai-agent" {
+			t.Fatalf("registered with the default discriminator: %v", tags)
+		}
+	}
+
+	resp := serve(router, http.MethodGet, "/api/v1/agents", nil, nil)
+	var agents []sharewoodapi.Agent
+	if err := json.Unmarshal(resp.Body.Bytes(), &agents); err != nil {
+		t.Fatalf("decoding the list: %v: %s", err, resp.Body)
+	}
+	if len(agents) != 1 || agents[0].Name != "weather" {
+		t.Fatalf("listed %s, want only weather", resp.Body)
+	}
+	for _, tag := range agents[0].Tags {
+		if tag == "sharewood-agent" {
+			t.Errorf("the discriminator is exposed in the agent's tags: %v", agents[0].Tags)
+		}
+	}
+	if resp := serve(router, http.MethodGet, "/api/v1/agents/postgres", nil, nil); resp.Code != http.StatusNotFound {
+		t.Errorf("getting a service tagged only ai-agent: got %d, want 404", resp.Code)
+	}
+}
+
+func TestTagContainingACommaRoundTrips(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	agent := testAgent("weather")
+	agent.Tags = []string{"eu, west", "geo"}
+	registerTestAgent(t, router, agent)
+
+	got := getTestAgent(t, router, "weather")
+	if strings.Join(got.Tags, "|") != "eu, west|geo" {
+		t.Fatalf("got tags %q, want %q", got.Tags, agent.Tags)
+	}
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	found := false
+	for _, tag := range consul.services["weather"].Tags {
+		found = found || tag == "eu, west"
+	}
+	if !found {
+		t.Errorf("the tag is not a single Consul service tag: %q", consul.services["weather"].Tags)
+	}
+}
+
+func TestRequiredFieldsFollowConfiguration(t *testing.T) {
+	register := func(router http.Handler, agent sharewoodapi.Agent) (int, sharewoodapi.ErrorResponse) {
+		resp := serve(router, http.MethodPost, "/api/v1/agents", agent, nil)
+		var errResp sharewoodapi.ErrorResponse
+		json.Unmarshal(resp.Body.Bytes(), &errResp)
+		return resp.Code, errResp
+	}
+
+	_, router := newTestRegistry(t)
+	noHowTo := testAgent("weather")
+	noHowTo.HowToUse = ""
+	if code, errResp := register(router, noHowTo); code != http.StatusBadRequest || errResp.Fields["howtouse"] == "" {
+		t.Fatalf("by default, an agent without howtouse: got %d %+v, want 400 on howtouse", code, errResp)
+	}
+
+	t.Setenv("REQUIRED_FIELDS", "name,description,baseurl,release")
+	_, router = newTestRegistry(t)
+	if code, errResp := register(router, noHowTo); code != http.StatusBadRequest || errResp.Fields["release"] == "" || errResp.Fields["howtouse"] != "" {
+		t.Fatalf("with release required, an agent without release and howtouse: got %d %+v, want 400 on release only", code, errResp)
+	}
+	noHowTo.Release = "1.2.0"
+	if code, errResp := register(router, noHowTo); code != http.StatusCreated {
+		t.Fatalf("with howtouse optional, an agent without it: got %d %+v", code, errResp)
+	}
+
+	noHowTo.Name = "news"
+	resp := serve(router, http.MethodPost, "/api/v1/agents?dryRun=true", noHowTo, nil)
+	var dryRun sharewoodapi.DryRunResponse
+	json.Unmarshal(resp.Body.Bytes(), &dryRun)
+	if strings.Join(dryRun.RequiredFields, ",") != "name,description,baseurl,release" {
+		t.Errorf("the dry run reports required fields %v: %s", dryRun.RequiredFields, resp.Body)
+	}
+}
+
+func TestNonJSONBodiesAreUnsupported(t *testing.T) {
+	_, router := newTestRegistry(t)
+
+	for _, contentType := range []string{"application/x-www-form-urlencoded", "text/plain", "multipart/form-data; boundary=x", ""} {
+		resp := serve(router, http.MethodPost, "/api/v1/agents", testAgent("weather"), http.Header{"Content-Type": {contentType}})
+		var errResp sharewoodapi.ErrorResponse
+		json.Unmarshal(resp.Body.Bytes(), &errResp)
+		if resp.Code != http.StatusUnsupportedMediaType || errResp.Error != "Unsupported media type" {
+			t.Errorf("registering with Content-Type %q: got %d, want 415: %s", contentType, resp.Code, resp.Body)
+		}
+	}
+	if resp := serve(router, http.MethodGet, "/api/v1/agents/weather", nil, nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("a rejected registration was stored: got %d", resp.Code)
+	}
+
+	if resp := serve(router, http.MethodPost, "/api/v1/agents", testAgent("weather"), http.Header{"Content-Type": {"application/json; charset=utf-8"}}); resp.Code != http.StatusCreated {
+		t.Errorf("registering with a charset parameter: got %d: %s", resp.Code, resp.Body)
+	}
+	if resp := serve(router, http.MethodPatch, "/api/v1/agents/weather", map[string]interface{}{"description": "Forecasts"}, http.Header{"Content-Type": {"application/merge-patch+json"}}); resp.Code != http.StatusOK {
+		t.Errorf("patching with a +json media type: got %d: %s", resp.Code, resp.Body)
+	}
+}
+
+func TestLocationHeaderEscapesTheName(t *testing.T) {
+	_, router := newTestRegistry(t)
+	client := newTestClient(t, router)
+
+	for name, want := range map[string]string{
+		"weather":     "/api/v1/agents/weather",
+		"geo agent?":  "/api/v1/agents/geo%20agent%3F",
+		"café#1":      "/api/v1/agents/caf%C3%A9%231",
+		"100% uptime": "/api/v1/agents/100%25%20uptime",
+	} {
+		response, err := client.RegisterAgentWithResponse(testAgent(name))
+		if err != nil {
+			t.Errorf("registering %q: %v", name, err)
+			continue
+		}
+		if response.Location != want {
+			t.Errorf("registering %q: got Location %q, want %q", name, response.Location, want)
+			continue
+		}
+		resp := serve(router, http.MethodGet, response.Location, nil, nil)
+		var body sharewoodapi.AgentResponse
+		json.Unmarshal(resp.Body.Bytes(), &body)
+		if resp.Code != http.StatusOK || body.Agent.Name != name {
+			t.Errorf("following the Location of %q: got %d: %s", name, resp.Code, resp.Body)
+		}
+	}
+
+	agent := testAgent("weather")
+	agent.Environment = "prod"
+	resp := serve(router, http.MethodPost, "/api/v1/agents", agent, nil)
+	if location := resp.Header().Get("Location"); location != "/api/v1/agents/weather?env=prod" {
+		t.Errorf("registering in an environment: got Location %q", location)
+	}
+}
+
+func TestCursorsAreStableWhileAgentsChange(t *testing.T) {
+	_, router := newTestRegistry(t)
+	client := newTestClient(t, router)
+	for _, name := range []string{"b", "c", "d", "e", "f", "g"} {
+		registerTestAgent(t, router, testAgent(name))
+	}
+
+	page, err := client.ListAgentsCursor("", 2)
+	if err != nil {
+		t.Fatalf("first page: %v", err)
+	}
+	seen := make(map[string]int)
+	for _, agent := range page.Agents {
+		seen[agent.Name]++
+	}
+
+	// Between pages: remove a listed and an unlisted agent, and add agents
+	// before and after the cursor
+	serve(router, http.MethodDelete, "/api/v1/agents/b", nil, nil)
+	serve(router, http.MethodDelete, "/api/v1/agents/e", nil, nil)
+	registerTestAgent(t, router, testAgent("a"))
+	registerTestAgent(t, router, testAgent("h"))
+
+	for page.NextCursor != "" {
+		if page, err = client.ListAgentsCursor(page.NextCursor, 2); err != nil {
+			t.Fatalf("next page: %v", err)
+		}
+		for _, agent := range page.Agents {
+			seen[agent.Name]++
+		}
+	}
+
+	// Every agent present throughout is listed once; added ones appear only
+	// past the cursor and removed ones only if listed before their removal
+	want := map[string]int{"b": 1, "c": 1, "d": 1, "f": 1, "g": 1, "h": 1}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Fatalf("listed %v, want %v", seen, want)
+	}
+}
+
+func TestTagsAreReportedSorted(t *testing.T) {
+	_, router := newTestRegistry(t)
+	client := newTestClient(t, router)
+	agent := testAgent("weather")
+	agent.Tags = []string{"travel", "geography", "locations"}
+	want := "geography,locations,travel"
+
+	registered, err := client.RegisterAgent(agent)
+	if err != nil {
+		t.Fatalf("RegisterAgent failed: %v", err)
+	}
+	if got := strings.Join(registered.Tags, ","); got != want {
+		t.Errorf("registration reported tags %s, want %s", got, want)
+	}
+	fetched, err := client.GetAgent("weather")
+	if err != nil {
+		t.Fatalf("GetAgent failed: %v", err)
+	}
+	if got := strings.Join(fetched.Tags, ","); got != want {
+		t.Errorf("get reported tags %s, want %s", got, want)
+	}
+	agents, err := client.ListAgents()
+	if err != nil || len(agents) != 1 {
+		t.Fatalf("ListAgents: got %d agents, %v", len(agents), err)
+	}
+	if got := strings.Join(agents[0].Tags, ","); got != want {
+		t.Errorf("list reported tags %s, want %s", got, want)
+	}
+}
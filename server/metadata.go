@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Custom agent metadata is stored as Consul service meta under
+//
+//	x-<key>
+//
+// so it can never collide with the registry's own meta keys.
+const metadataMetaPrefix = "x-"
+
+// Query parameter prefix filtering agents by metadata, e.g. meta.team=geo
+const metadataQueryPrefix = "meta."
+
+// Limits on custom metadata, within Consul's: meta keys of at most 128
+// characters including the prefix, values of at most 512 characters, and
+// 64 meta entries per service, shared with the registry's own
+const (
+	maxMetadataKeyLength   = 128 - len(metadataMetaPrefix)
+	maxMetadataValueLength = 512
+	maxMetadataEntries     = 32
+)
+
+// Consul meta keys may only hold letters, digits, dashes and underscores
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedMetadataKeys are the agent's JSON field names, which cannot be
+// used as metadata keys so metadata never reads as a built-in field
+var reservedMetadataKeys = func() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(sharewoodapi.Agent{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		keys[strings.ToLower(name)] = true
+	}
+	return keys
+}()
+
+// validateMetadata checks an agent's custom metadata against the key and
+// value constraints. Returns nil if the metadata is valid.
+func validateMetadata(metadata map[string]string) *sharewoodapi.ErrorResponse {
+	if len(metadata) > maxMetadataEntries {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid metadata",
+			Details: fmt.Sprintf("An agent can have at most %d metadata entries", maxMetadataEntries),
+			Fields:  map[string]string{"metadata": "too many entries"},
+		}
+	}
+
+	fields := make(map[string]string)
+	for key, value := range metadata {
+		path := "metadata/" + key
+		switch {
+		case !metadataKeyPattern.MatchString(key):
+			fields[path] = "key may only contain letters, digits, '-' and '_'"
+		case len(key) > maxMetadataKeyLength:
+			fields[path] = fmt.Sprintf("key must be at most %d characters", maxMetadataKeyLength)
+		case reservedMetadataKeys[strings.ToLower(key)] || strings.HasPrefix(strings.ToLower(key), "consul-"):
+			fields[path] = "key is reserved"
+		case utf8.RuneCountInString(value) > maxMetadataValueLength:
+			fields[path] = fmt.Sprintf("value must be at most %d characters", maxMetadataValueLength)
+		}
+	}
+	if len(fields) > 0 {
+		keys := make([]string, 0, len(fields))
+		for path := range fields {
+			keys = append(keys, strings.TrimPrefix(path, "metadata/"))
+		}
+		sort.Strings(keys)
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid metadata",
+			Details: fmt.Sprintf("Invalid metadata keys or values: %s", strings.Join(keys, ", ")),
+			Fields:  fields,
+		}
+	}
+	return nil
+}
+
+// metadataFromMeta extracts an agent's custom metadata from its service meta
+func metadataFromMeta(meta map[string]string) map[string]string {
+	var metadata map[string]string
+	for key, value := range meta {
+		if name, ok := strings.CutPrefix(key, metadataMetaPrefix); ok {
+			if metadata == nil {
+				metadata = make(map[string]string)
+			}
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// metadataFilters returns the metadata a list request filters on, from its
+// meta.<key>=<value> query parameters
+func metadataFilters(c *gin.Context) map[string]string {
+	var filters map[string]string
+	for param, values := range c.Request.URL.Query() {
+		if key, ok := strings.CutPrefix(param, metadataQueryPrefix); ok && key != "" {
+			if filters == nil {
+				filters = make(map[string]string)
+			}
+			filters[key] = values[0]
+		}
+	}
+	return filters
+}
+
+// matchesMetadata reports whether the agent carries every filtered metadata
+// value
+func matchesMetadata(agent sharewoodapi.Agent, filters map[string]string) bool {
+	for key, value := range filters {
+		if actual, ok := agent.Metadata[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics describing how the Consul backend performs, labeled by operation
+// (e.g. "agent.services", "catalog.services")
+var (
+	consulCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "sharewood",
+		Subsystem: "consul",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of calls to the Consul API.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	consulCallErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "sharewood",
+		Subsystem: "consul",
+		Name:      "call_errors_total",
+		Help:      "Calls to the Consul API that returned an error.",
+	}, []string{"operation"})
+)
+
+func init() {
+	prometheus.MustRegister(consulCallDuration, consulCallErrors)
+}
+
+// observeConsulCall records the latency and outcome of a Consul call started
+// at start
+func observeConsulCall(operation string, start time.Time, err error) {
+	consulCallDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		consulCallErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Deregister By Owner endpoint - removes every agent owned by the given owner,
+// e.g. when a team offboards, and returns the removed names. The owner must be
+// given explicitly so a bare DELETE can never empty the registry. Each removal
+// is logged with the caller.
+func deregisterByOwner(c *gin.Context) {
+	owner := c.Query("owner")
+	if owner == "" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Missing owner",
+			Details: "owner is required to deregister agents in bulk",
+		})
+		return
+	}
+
+	services, err := agentServicesByKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Error listing agents: %v", err)
+		respondConsulError(c, "Failed to list agents", err)
+		return
+	}
+
+	keys := make([]string, 0)
+	for key, service := range services {
+		if service.Meta["owner"] == owner {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	removed := make([]string, 0, len(keys))
+	defer func() {
+		if len(removed) > 0 {
+			log.Printf("Deregistered %d agents owned by '%s' at the request of %s: %s", len(removed), owner, callerIdentity(c), strings.Join(removed, ", "))
+		}
+	}()
+
+	for _, key := range keys {
+		service := services[key]
+		if err := deregisterAgentService(c.Request.Context(), service.ID); err != nil {
+			log.Printf("Error unregistering agent %s: %v", key, err)
+			respondConsulError(c, "Failed to unregister agent", err)
+			return
+		}
+		agentChanged(c.Request.Context(), service, service.ID, nil)
+		removed = append(removed, key)
+	}
+
+	c.JSON(http.StatusOK, sharewoodapi.DeregisterResult{Removed: removed})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAPIPath is where the registry serves its own OpenAPI document
+const openAPIPath = "/openapi.json"
+
+// openAPISpec is the registry's OpenAPI 3 document. Paths are written against
+// the default base, health and metrics paths; they are rewritten at startup
+// when API_BASE_PATH, HEALTH_PATH or METRICS_PATH are set.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Default paths used by openapi.json
+const (
+	defaultSpecBasePath    = "/api/v1"
+	defaultSpecHealthPath  = "/health"
+	defaultSpecMetricsPath = "/metrics"
+)
+
+var ginParamPattern = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// buildOpenAPIDocument returns the embedded document with its paths adjusted
+// to the configured base, health and metrics paths
+func buildOpenAPIDocument() ([]byte, map[string]map[string]interface{}) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		log.Fatalf("Invalid embedded OpenAPI document: %v", err)
+	}
+
+	paths := make(map[string]map[string]interface{})
+	rawPaths, _ := doc["paths"].(map[string]interface{})
+	for path, item := range rawPaths {
+		switch {
+		case path == defaultSpecHealthPath:
+			path = cfg.HealthPath
+		case path == defaultSpecMetricsPath:
+			path = cfg.MetricsPath
+		case strings.HasPrefix(path, defaultSpecBasePath+"/"):
+			path = strings.TrimSuffix(cfg.BasePath, "/") + strings.TrimPrefix(path, defaultSpecBasePath)
+		}
+		itemMap, _ := item.(map[string]interface{})
+		paths[path] = itemMap
+	}
+	doc["paths"] = paths
+
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode OpenAPI document: %v", err)
+	}
+	return body, paths
+}
+
+// checkOpenAPICoverage logs a warning for every registered route that the
+// OpenAPI document does not describe, so the two are kept in sync
+func checkOpenAPICoverage(routes gin.RoutesInfo, paths map[string]map[string]interface{}) {
+	for _, route := range undocumentedRoutes(routes, paths) {
+		log.Printf("Warning: route %s %s is not described in openapi.json", route.Method, route.Path)
+	}
+}
+
+// undocumentedRoutes returns the registered routes, other than OPTIONS and
+// HEAD, that the OpenAPI document does not describe
+func undocumentedRoutes(routes gin.RoutesInfo, paths map[string]map[string]interface{}) gin.RoutesInfo {
+	var missing gin.RoutesInfo
+	for _, route := range routes {
+		if route.Method == http.MethodOptions || route.Method == http.MethodHead {
+			continue
+		}
+		specPath := ginParamPattern.ReplaceAllString(route.Path, "{$1}")
+		if _, ok := paths[specPath][strings.ToLower(route.Method)]; !ok {
+			missing = append(missing, route)
+		}
+	}
+	return missing
+}
+
+// openAPIHandler serves the given OpenAPI document
+func openAPIHandler(doc []byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", doc)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestEveryRouteIsDocumented(t *testing.T) {
+	newTestRegistry(t)
+	_, paths := buildOpenAPIDocument()
+
+	for _, route := range undocumentedRoutes(setupRouter().Routes(), paths) {
+		t.Errorf("route %s %s is not described in openapi.json", route.Method, route.Path)
+	}
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	_, router := newTestRegistry(t)
+
+	resp := serve(router, http.MethodGet, openAPIPath, nil, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", resp.Code)
+	}
+	var doc struct {
+		OpenAPI    string
+		Components struct {
+			Schemas map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding the document: %v", err)
+	}
+	for _, schema := range []string{"Agent", "ErrorResponse"} {
+		if _, ok := doc.Components.Schemas[schema]; !ok {
+			t.Errorf("schema %s is missing", schema)
+		}
+	}
+}
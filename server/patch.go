@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Agent fields that can be changed through a partial update, keyed by JSON name
+var patchableFields = map[string]bool{
+	"description":         true,
+	"release":             true,
+	"baseurl":             true,
+	"baseurls":            true,
+	"openapi":             true,
+	"howtouse":            true,
+	"expiration":          true,
+	"ttl":                 true,
+	"tags":                true,
+	"owner":               true,
+	"lifecycle":           true,
+	"contact":             true,
+	"docsUrl":             true,
+	"iconUrl":             true,
+	"aliases":             true,
+	"dependsOn":           true,
+	"metadata":            true,
+	"healthCheckUrl":      true,
+	"allowMultipleChecks": true,
+	"weight":              true,
+}
+
+// Patch Agent endpoint - merges a sparse JSON object into the stored agent,
+// leaving untouched fields and the current TTL check status as they are.
+// A null value removes the corresponding field. A body sent as
+// application/json-patch+json is instead applied as an RFC 6902 JSON Patch,
+// whose test operations fail the update with 409 Conflict. An If-Match
+// header holding the ETag from a prior read makes the update conditional on
+// the agent being unchanged since then.
+func patchAgent(c *gin.Context) {
+	name := c.Param("name")
+
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType == jsonPatchMediaType {
+		patchAgentJSON(c, name)
+		return
+	}
+
+	var changes map[string]interface{}
+	if !decodeJSONBody(c, &changes) {
+		return
+	}
+
+	if len(changes) == 0 {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "No changes supplied",
+			Details: "Provide a JSON object containing the fields to change",
+		})
+		return
+	}
+
+	for key := range changes {
+		if key == "name" {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid change",
+				Details: "The agent name cannot be changed",
+			})
+			return
+		}
+		if !patchableFields[key] {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid change",
+				Details: fmt.Sprintf("Unknown field '%s'", key),
+			})
+			return
+		}
+	}
+
+	updateAgentFields(c, name, changes, func(current sharewoodapi.Agent) (map[string]interface{}, error) {
+		return mergeAgentChanges(current, changes)
+	})
+}
+
+// patchAgentJSON handles a PATCH whose body is an RFC 6902 JSON Patch
+func patchAgentJSON(c *gin.Context, name string) {
+	var ops []jsonPatchOp
+	if !decodeJSONBody(c, &ops) {
+		return
+	}
+
+	changes, err := checkJSONPatchOps(ops)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid patch",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	updateAgentFields(c, name, changes, func(current sharewoodapi.Agent) (map[string]interface{}, error) {
+		return applyJSONPatch(current, ops)
+	})
+}
+
+// updateAgentFields applies a partial update to the named agent. changes
+// holds the top-level fields the update touches, and apply produces the
+// agent's new JSON document from its current state.
+func updateAgentFields(c *gin.Context, name string, changes map[string]interface{}, apply func(sharewoodapi.Agent) (map[string]interface{}, error)) {
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	if err == nil && service != nil && c.GetHeader("If-Match") != "" {
+		release, ok := lockConditionalUpdate(c, service.ID)
+		if !ok {
+			return
+		}
+		defer release()
+		// Read the agent again under the lock, so the If-Match check sees
+		// any write that finished before it was taken
+		service, err = findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	}
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	current := serviceToAgent(service)
+	if !checkIfMatch(c, current) {
+		return
+	}
+
+	fields, err := apply(current)
+	if errors.Is(err, errJSONPatchTestFailed) {
+		respondError(c, http.StatusConflict, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodePatchTestFailed,
+			Error:   "Patch test failed",
+			Details: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid change",
+			Details: err.Error(),
+		})
+		return
+	}
+
+	if errResp := validateAgentSchema(fields); errResp != nil {
+		respondError(c, http.StatusBadRequest, *errResp)
+		return
+	}
+
+	agent, err := fieldsToAgent(fields)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid change",
+			Details: err.Error(),
+		})
+		return
+	}
+	agent.CreatedAt = current.CreatedAt
+	agent.UpdatedAt = timestampNow()
+
+	// Changing only the primary base URL keeps the fallbacks
+	if _, ok := changes["baseurls"]; !ok {
+		agent.BaseURLs = fallbackBaseURLs(current)
+	}
+	normalizeBaseURLs(&agent)
+
+	if errResp := validateAgent(agent); errResp != nil {
+		respondError(c, http.StatusBadRequest, *errResp)
+		return
+	}
+	if !authorizeAgentTags(c, current.Tags, agent.Tags) {
+		return
+	}
+
+	if _, ok := changes["aliases"]; ok && !checkNameClaims(c, agent, service.ID) {
+		return
+	}
+
+	if _, ok := changes["dependsOn"]; ok {
+		if _, ok := checkAgentDependencies(c, agent); !ok {
+			return
+		}
+	}
+
+	if err := reregisterAgent(c.Request.Context(), service.ID, agent); err != nil {
+		log.Printf("Error updating agent: %v", err)
+		respondConsulError(c, "Failed to update agent", err)
+		return
+	}
+	agentChanged(c.Request.Context(), service, service.ID, &agent)
+	agent = storedAgent(service.ID, agent)
+
+	c.Header("ETag", agentETag(agent))
+	c.JSON(http.StatusOK, sharewoodapi.AgentResponse{
+		Agent: agent,
+	})
+}
+
+// mergeAgentChanges applies a sparse set of JSON field changes to an agent
+// and returns the resulting JSON document. A nil value removes the field.
+// Metadata is merged key by key in the same way, so a change can set or
+// remove single entries.
+func mergeAgentChanges(agent sharewoodapi.Agent, changes map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode agent: %w", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to decode agent: %w", err)
+	}
+
+	for key, value := range changes {
+		if entries, ok := value.(map[string]interface{}); ok && key == "metadata" {
+			merged, _ := fields[key].(map[string]interface{})
+			if merged == nil {
+				merged = make(map[string]interface{})
+			}
+			for entry, entryValue := range entries {
+				if entryValue == nil {
+					delete(merged, entry)
+				} else {
+					merged[entry] = entryValue
+				}
+			}
+			fields[key] = merged
+			continue
+		}
+		if value == nil {
+			delete(fields, key)
+		} else {
+			fields[key] = value
+		}
+	}
+
+	return fields, nil
+}
+
+// fieldsToAgent converts a JSON document produced by mergeAgentChanges back
+// into an agent
+func fieldsToAgent(fields map[string]interface{}) (sharewoodapi.Agent, error) {
+	var merged sharewoodapi.Agent
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return merged, fmt.Errorf("failed to encode changes: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return merged, fmt.Errorf("failed to apply changes: %w", err)
+	}
+
+	return merged, nil
+}
+
+// reregisterAgent replaces the Consul registration of an existing agent,
+// carrying over the current status of its checks so the update does not
+// reset the agent's health
+func reregisterAgent(ctx context.Context, serviceID string, agent sharewoodapi.Agent) error {
+	registration := agentRegistration(agent)
+	registration.ID = serviceID
+	registration.Checks = agentChecks(agent, serviceID)
+
+	if len(registration.Checks) > 0 {
+		checks, err := consulChecks(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read agent checks: %w", err)
+		}
+		for _, registered := range registration.Checks {
+			if check, ok := checks[registered.CheckID]; ok {
+				registered.Status = check.Status
+			}
+		}
+	}
+
+	if err := consulServiceRegister(ctx, registration); err != nil {
+		return fmt.Errorf("failed to update agent: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// patchTestAgent sends a merge patch and returns the updated agent
+func patchTestAgent(t *testing.T, router http.Handler, name string, changes map[string]interface{}) sharewoodapi.Agent {
+	t.Helper()
+	resp := serve(router, http.MethodPatch, "/api/v1/agents/"+name, changes, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("patching %v: got %d: %s", changes, resp.Code, resp.Body)
+	}
+	return getTestAgent(t, router, name)
+}
+
+func TestPatchAddsModifiesAndDeletesFields(t *testing.T) {
+	consul, router := newTestRegistry(t)
+	agent := testAgent("weather")
+	agent.Metadata = map[string]string{"region": "eu", "tier": "gold"}
+	registerTestAgent(t, router, agent)
+	consul.mu.Lock()
+	for _, check := range consul.checks {
+		check.Status = api.HealthPassing
+	}
+	consul.mu.Unlock()
+
+	added := patchTestAgent(t, router, "weather", map[string]interface{}{
+		"owner":    "forecasts",
+		"metadata": map[string]interface{}{"team": "sky"},
+	})
+	if added.Owner != "forecasts" || added.Metadata["team"] != "sky" || added.Metadata["region"] != "eu" {
+		t.Errorf("after adding: owner %q, metadata %v", added.Owner, added.Metadata)
+	}
+
+	modified := patchTestAgent(t, router, "weather", map[string]interface{}{
+		"description": "Forecasts the weather",
+		"metadata":    map[string]interface{}{"tier": "silver"},
+	})
+	if modified.Description != "Forecasts the weather" || modified.Metadata["tier"] != "silver" {
+		t.Errorf("after modifying: description %q, metadata %v", modified.Description, modified.Metadata)
+	}
+	if modified.Owner != "forecasts" || modified.HowToUse != agent.HowToUse || modified.BaseURL != agent.BaseURL {
+		t.Errorf("untouched fields changed: %+v", modified)
+	}
+
+	deleted := patchTestAgent(t, router, "weather", map[string]interface{}{
+		"owner":    nil,
+		"metadata": map[string]interface{}{"region": nil},
+	})
+	if deleted.Owner != "" {
+		t.Errorf("owner %q was not deleted", deleted.Owner)
+	}
+	if _, ok := deleted.Metadata["region"]; ok || deleted.Metadata["tier"] != "silver" || deleted.Metadata["team"] != "sky" {
+		t.Errorf("after deleting region: metadata %v", deleted.Metadata)
+	}
+
+	// The TTL check keeps its status through the updates
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	for id, check := range consul.checks {
+		if check.Status != api.HealthPassing {
+			t.Errorf("check %s is %s after patching, want passing", id, check.Status)
+		}
+	}
+}
+
+func TestPatchRejectsNameAndUnknownFields(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	for _, changes := range []map[string]interface{}{{"name": "storm"}, {"colour": "blue"}, {}} {
+		resp := serve(router, http.MethodPatch, "/api/v1/agents/weather", changes, nil)
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("patching %v: got %d, want 400: %s", changes, resp.Code, resp.Body)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// pingClient returns the HTTP client used to probe agent base URLs. It gives
+// up after cfg.PingMaxRedirects redirects, reporting the last redirect
+// response instead of following it further, and never follows a redirect to
+// a non-HTTP scheme.
+func pingClient() *http.Client {
+	return &http.Client{
+		Timeout: cfg.PingTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if int64(len(via)) > cfg.PingMaxRedirects {
+				return http.ErrUseLastResponse
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// probeURL sends a HEAD request to target, falling back to GET when the agent
+// does not support HEAD, and returns the response status. The response body is
+// discarded.
+func probeURL(ctx context.Context, client *http.Client, target string) (int, error) {
+	statusCode := 0
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			return 0, fmt.Errorf("invalid base URL: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		statusCode = resp.StatusCode
+		if statusCode != http.StatusMethodNotAllowed && statusCode != http.StatusNotImplemented {
+			break
+		}
+	}
+	return statusCode, nil
+}
+
+// Ping Agent endpoint - checks that the agent's base URL answers HTTP
+// requests and reports the status and latency. Base URLs are tried in order,
+// primary first, until one is reachable. This is live reachability, as
+// opposed to the health the agent reports through its TTL check.
+func pingAgent(c *gin.Context) {
+	name := c.Param("name")
+
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	agent := serviceToAgent(service)
+	client := pingClient()
+	response := sharewoodapi.PingResponse{Name: name}
+	for _, target := range agent.BaseURLs {
+		start := time.Now()
+		statusCode, err := probeURL(c.Request.Context(), client, target)
+		response = sharewoodapi.PingResponse{
+			Name:       name,
+			URL:        target,
+			StatusCode: statusCode,
+			LatencyMs:  time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			response.Error = err.Error()
+		} else {
+			response.Reachable = statusCode < http.StatusInternalServerError
+		}
+		if response.Reachable {
+			break
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
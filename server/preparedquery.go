@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// listAgentsByPreparedQuery serves GET /agents?preparedQuery=<name>. It
+// executes the named Consul prepared query, so the operator's discovery
+// policy picks the services, including failover to other datacenters, and
+// returns the registry agents among them. The other list filters do not
+// apply; parameters that change the shape of the response are rejected.
+func listAgentsByPreparedQuery(c *gin.Context, name string) {
+	if name == "" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid preparedQuery",
+			Details: "preparedQuery must name a Consul prepared query",
+		})
+		return
+	}
+	for _, param := range []string{"index", "cursor", "limit", "stream"} {
+		if _, ok := c.GetQuery(param); ok {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid preparedQuery",
+				Details: fmt.Sprintf("preparedQuery cannot be combined with %s", param),
+			})
+			return
+		}
+	}
+
+	result, err := consulPreparedQueryExecute(c.Request.Context(), name)
+	if err != nil {
+		var statusErr api.StatusError
+		if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+			respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+				Error:   "Prepared query not found",
+				Details: fmt.Sprintf("No Consul prepared query named '%s' exists", name),
+			})
+			return
+		}
+		log.Printf("Error executing prepared query %s: %v", name, err)
+		respondConsulError(c, "Failed to execute prepared query", err)
+		return
+	}
+
+	// Health comes from each entry's own checks, as answered by the
+	// datacenter that served the query
+	services := make(map[string]*api.AgentService)
+	statuses := make(map[string]sharewoodapi.HealthStatus)
+	for _, entry := range result.Nodes {
+		if entry.Service == nil {
+			continue
+		}
+		services[entry.Service.ID] = entry.Service
+		for _, check := range entry.Checks {
+			if check.ServiceID != entry.Service.ID {
+				continue
+			}
+			status := sharewoodapi.HealthStatus(check.Status)
+			if current, ok := statuses[entry.Service.ID]; !ok || healthSeverity[status] > healthSeverity[current] {
+				statuses[entry.Service.ID] = status
+			}
+		}
+	}
+
+	agents := make([]sharewoodapi.Agent, 0)
+	for _, instances := range groupAgentInstances(services) {
+		agents = append(agents, instancesToAgent(instances, statuses))
+	}
+	sortAgents(agents, "name", false)
+
+	c.Header(sharewoodapi.DatacenterHeader, result.Datacenter)
+	c.JSON(http.StatusOK, agents)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// readOnly freezes the registry: while set, endpoints that change agents
+// respond 503 and reads keep working. It starts from READ_ONLY and can be
+// toggled at runtime through the admin endpoint.
+var readOnly atomic.Bool
+
+// rejectWhenReadOnly guards an endpoint that changes the registry
+func rejectWhenReadOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if readOnly.Load() {
+			abortWithError(c, http.StatusServiceUnavailable, sharewoodapi.ErrorResponse{
+				Code:    sharewoodapi.CodeReadOnly,
+				Error:   sharewoodapi.ReadOnlyError,
+				Details: "The registry is in read-only mode for maintenance; changes are rejected until it is lifted",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Read-Only Mode endpoint - turns read-only mode on or off at runtime
+func setReadOnlyMode(c *gin.Context) {
+	var mode sharewoodapi.ReadOnlyMode
+	if !decodeJSONBody(c, &mode) {
+		return
+	}
+
+	readOnly.Store(mode.ReadOnly)
+	log.Printf("Read-only mode set to %t by %s", mode.ReadOnly, c.GetString("role"))
+
+	c.JSON(http.StatusOK, sharewoodapi.ReadOnlyMode{ReadOnly: readOnly.Load()})
+}
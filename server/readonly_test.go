@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestReadOnlyModeBlocksWritesOnly(t *testing.T) {
+	_, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+
+	if resp := serve(router, http.MethodPost, "/api/v1/admin/readonly", sharewoodapi.ReadOnlyMode{ReadOnly: true}, nil); resp.Code != http.StatusOK {
+		t.Fatalf("turning read-only mode on: got %d: %s", resp.Code, resp.Body)
+	}
+
+	for _, path := range []string{"/api/v1/agents", "/api/v1/agents/weather"} {
+		if resp := serve(router, http.MethodGet, path, nil, nil); resp.Code != http.StatusOK {
+			t.Errorf("GET %s in read-only mode: got %d: %s", path, resp.Code, resp.Body)
+		}
+	}
+
+	writes := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "/api/v1/agents", testAgent("news")},
+		{http.MethodPatch, "/api/v1/agents/weather", map[string]interface{}{"description": "Forecasts"}},
+		{http.MethodPut, "/api/v1/agents/weather/health", map[string]interface{}{"status": "passing"}},
+		{http.MethodDelete, "/api/v1/agents/weather", nil},
+	}
+	for _, write := range writes {
+		resp := serve(router, write.method, write.path, write.body, nil)
+		var errResp sharewoodapi.ErrorResponse
+		json.Unmarshal(resp.Body.Bytes(), &errResp)
+		if resp.Code != http.StatusServiceUnavailable || errResp.Code != sharewoodapi.CodeReadOnly {
+			t.Errorf("%s %s in read-only mode: got %d: %s", write.method, write.path, resp.Code, resp.Body)
+		}
+	}
+	getTestAgent(t, router, "weather")
+
+	if resp := serve(router, http.MethodPost, "/api/v1/admin/readonly", sharewoodapi.ReadOnlyMode{ReadOnly: false}, nil); resp.Code != http.StatusOK {
+		t.Fatalf("turning read-only mode off: got %d: %s", resp.Code, resp.Body)
+	}
+	registerTestAgent(t, router, testAgent("news"))
+}
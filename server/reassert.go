@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Registrations are recorded in Consul KV under
+//
+//	<KV_PREFIX>/registrations/<service ID>
+//
+// holding the agent as JSON. Consul keeps KV in its replicated store, while a
+// service registration lives only on the Consul agent it was made on, so the
+// record lets the registry restore agents lost when that Consul agent
+// restarts without its state.
+func registrationPrefix() string {
+	return cfg.KVPrefix + "/registrations/"
+}
+
+func registrationKey(serviceID string) string {
+	return registrationPrefix() + url.PathEscape(serviceID)
+}
+
+// agentChanged records a change to one agent in the KV index and, when
+// reasserting is enabled, in its registration record. A deregistered agent's
+// uploaded icon is removed; its registration record is already gone, removed
+// by deregisterAgentService. previous is the service before the change, nil
+// for a new agent; agent is the agent after it, nil for a deregistration.
+// Failures are logged rather than returned,
+// since the change itself has already been applied. For the same reason the
+// bookkeeping is not cancelled when the request that made the change ends.
+func agentChanged(ctx context.Context, previous *api.AgentService, serviceID string, agent *sharewoodapi.Agent) {
+	ctx = context.WithoutCancel(ctx)
+	updateAgentIndex(ctx, previous, serviceID, agent)
+	if agent == nil {
+		deleteAgentIcon(ctx, serviceID)
+	}
+
+	if !cfg.ReassertRegistrations || agent == nil {
+		return
+	}
+	if err := putRegistrationRecord(ctx, serviceID, *agent); err != nil {
+		log.Printf("Error writing registration record for %s: %v", serviceID, err)
+	}
+}
+
+// deregisterAgentService removes an agent's service from Consul. Its
+// registration record is deleted first, so that a reassertion pass running
+// in between cannot restore the agent being removed; if the record cannot be
+// deleted, the service is left registered.
+func deregisterAgentService(ctx context.Context, serviceID string) error {
+	if err := removeRegistrationRecord(ctx, serviceID); err != nil {
+		return err
+	}
+	return consulServiceDeregister(ctx, serviceID)
+}
+
+// removeRegistrationRecord deletes the registration record of serviceID, if
+// reasserting is enabled
+func removeRegistrationRecord(ctx context.Context, serviceID string) error {
+	if !cfg.ReassertRegistrations {
+		return nil
+	}
+	if err := consulKVDelete(ctx, registrationKey(serviceID)); err != nil {
+		return fmt.Errorf("failed to remove registration record: %w", err)
+	}
+	return nil
+}
+
+// putRegistrationRecord stores the agent registered under serviceID
+func putRegistrationRecord(ctx context.Context, serviceID string, agent sharewoodapi.Agent) error {
+	agent.Health = ""
+	agent.ContentHash = ""
+	data, err := json.Marshal(agent)
+	if err != nil {
+		return err
+	}
+	return consulKVPut(ctx, &api.KVPair{Key: registrationKey(serviceID), Value: data})
+}
+
+// runRegistrationReassertion re-asserts recorded registrations every
+// cfg.ReassertInterval, jittered by up to 20% either way so several registry
+// instances sharing a Consul do not act in lockstep. Each pass is cut off
+// after one interval so a slow Consul cannot stack passes up. It never
+// returns.
+func runRegistrationReassertion() {
+	for {
+		jitter := 0.8 + 0.4*rand.Float64()
+		time.Sleep(time.Duration(float64(cfg.ReassertInterval) * jitter))
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ReassertInterval)
+		if err := reassertRegistrations(ctx); err != nil {
+			log.Printf("Error reasserting agent registrations: %v", err)
+		}
+		cancel()
+	}
+}
+
+// reassertRegistrations registers again every recorded agent whose service
+// is missing from Consul, and records agents that have no record yet, such
+// as those registered before reasserting was enabled.
+//
+// The registry runs no expiration sweeper: an expired agent that is still
+// registered stays in Consul and is listed with its expiration, for callers
+// to judge. Reassertion is the one place expiration is acted on. An expired
+// agent whose service is missing is not restored, and its record is dropped.
+// A restore registers the recorded agent as it was, so it never extends an
+// agent's life; only the renew endpoint or an update moves the expiration.
+func reassertRegistrations(ctx context.Context) error {
+	services, err := agentServicesByKey(ctx)
+	if err != nil {
+		return err
+	}
+
+	pairs, err := consulKVList(ctx, registrationPrefix())
+	if err != nil {
+		return err
+	}
+
+	recorded := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		serviceID, err := url.PathUnescape(strings.TrimPrefix(pair.Key, registrationPrefix()))
+		if err != nil {
+			continue
+		}
+		recorded[serviceID] = true
+		if _, ok := services[serviceID]; ok {
+			continue
+		}
+
+		var agent sharewoodapi.Agent
+		if err := json.Unmarshal(pair.Value, &agent); err != nil {
+			log.Printf("Skipping malformed registration record %s: %v", pair.Key, err)
+			continue
+		}
+
+		if !agent.Expiration.IsZero() && agent.Expiration.Before(time.Now()) {
+			log.Printf("Not restoring expired agent %s", serviceID)
+			if err := consulKVDelete(ctx, pair.Key); err != nil {
+				log.Printf("Error removing registration record for %s: %v", serviceID, err)
+			}
+			continue
+		}
+
+		registration := agentRegistration(agent)
+		registration.ID = serviceID
+		if err := consulServiceRegister(ctx, registration); err != nil {
+			log.Printf("Error restoring agent %s: %v", serviceID, err)
+			continue
+		}
+		log.Printf("Restored missing agent %s from its registration record", serviceID)
+	}
+
+	for serviceID, service := range services {
+		if recorded[serviceID] {
+			continue
+		}
+		if err := putRegistrationRecord(ctx, service.ID, serviceToAgent(service)); err != nil {
+			log.Printf("Error writing registration record for %s: %v", serviceID, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReassertRestoresMissingAgentsOnly(t *testing.T) {
+	t.Setenv("REASSERT_REGISTRATIONS", "true")
+	consul, router := newTestRegistry(t)
+	registerTestAgent(t, router, testAgent("weather"))
+	registerTestAgent(t, router, testAgent("maps"))
+
+	// The record must be gone before the service is, or a pass in between
+	// would restore the agent being deregistered
+	recordLeft := false
+	consul.onRequest = func(r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/") {
+			consul.mu.Lock()
+			recordLeft = consul.kv[registrationKey("maps")] != nil
+			consul.mu.Unlock()
+		}
+	}
+	if resp := serve(router, http.MethodDelete, "/api/v1/agents/maps", nil, nil); resp.Code != http.StatusOK {
+		t.Fatalf("deregistering: got %d: %s", resp.Code, resp.Body)
+	}
+	if recordLeft {
+		t.Error("the registration record still existed when the service was deregistered")
+	}
+
+	// A Consul agent restart loses the weather service
+	consul.mu.Lock()
+	delete(consul.services, "weather")
+	consul.mu.Unlock()
+
+	if err := reassertRegistrations(context.Background()); err != nil {
+		t.Fatalf("reassertRegistrations: %v", err)
+	}
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	if consul.services["weather"] == nil {
+		t.Error("the missing agent was not registered again")
+	}
+	if consul.services["maps"] != nil {
+		t.Error("the deregistered agent was registered again")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Renew Agent endpoint - moves an agent's expiration without re-sending its
+// definition. The new expiration is given either as an RFC 3339 time in
+// expiration or as a duration in extend, added to the current expiration (or
+// to now, if the agent has none or it has passed). Everything else, including
+// the status of the TTL check, is preserved.
+func renewAgent(c *gin.Context) {
+	name := c.Param("name")
+
+	expirationStr, hasExpiration := c.GetQuery("expiration")
+	extendStr, hasExtend := c.GetQuery("extend")
+	if hasExpiration == hasExtend {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid renewal",
+			Details: "Provide exactly one of expiration or extend",
+		})
+		return
+	}
+
+	var expiration time.Time
+	var extend time.Duration
+	var err error
+	if hasExpiration {
+		expiration, err = time.Parse(time.RFC3339, expirationStr)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid expiration",
+				Details: "expiration must be an RFC 3339 time such as 2025-01-02T15:04:05Z",
+			})
+			return
+		}
+	} else {
+		extend, err = time.ParseDuration(extendStr)
+		if err != nil || extend <= 0 {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid extend",
+				Details: "extend must be a positive duration such as 720h",
+			})
+			return
+		}
+	}
+
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	agent := serviceToAgent(service)
+
+	now := time.Now()
+	if hasExtend {
+		expiration = agent.Expiration
+		if expiration.Before(now) {
+			expiration = now
+		}
+		expiration = expiration.Add(extend)
+	}
+
+	if !expiration.After(now) {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid expiration",
+			Details: "The new expiration must be in the future",
+		})
+		return
+	}
+
+	agent.Expiration = expiration.UTC()
+	agent.UpdatedAt = timestampNow()
+
+	if err := reregisterAgent(c.Request.Context(), service.ID, agent); err != nil {
+		log.Printf("Error renewing agent: %v", err)
+		respondConsulError(c, "Failed to renew agent", err)
+		return
+	}
+	agentChanged(c.Request.Context(), service, service.ID, &agent)
+	agent = storedAgent(service.ID, agent)
+
+	c.Header("ETag", agentETag(agent))
+	c.JSON(http.StatusOK, sharewoodapi.AgentResponse{
+		Agent: agent,
+	})
+}
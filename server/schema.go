@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// The agent schema in effect, adjusted to the configured required fields, in
+// compiled form and as served by the schema endpoint. Set at startup by
+// initAgentSchema.
+var (
+	agentSchema     *jsonschema.Schema
+	agentSchemaJSON []byte
+)
+
+// initAgentSchema adjusts sharewoodapi.AgentSchema so exactly requiredFields
+// are required and compiles it. Optional string fields may then be sent
+// empty, as the Go client does for fields without omitempty.
+func initAgentSchema(requiredFields []string) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(sharewoodapi.AgentSchema, &doc); err != nil {
+		log.Fatalf("Invalid agent schema: %v", err)
+	}
+
+	properties, _ := doc["properties"].(map[string]interface{})
+	for _, field := range requiredFields {
+		property, ok := properties[field].(map[string]interface{})
+		if !ok || property["readOnly"] == true {
+			log.Fatalf("Invalid REQUIRED_FIELDS: '%s' is not an agent field that can be required", field)
+		}
+	}
+	for name, p := range properties {
+		property := p.(map[string]interface{})
+		if property["type"] != "string" {
+			continue
+		}
+		if containsString(requiredFields, name) {
+			property["minLength"] = 1
+		} else {
+			delete(property, "minLength")
+		}
+	}
+	doc["required"] = requiredFields
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Fatalf("Invalid agent schema: %v", err)
+	}
+	agentSchema = compileAgentSchema(data)
+	agentSchemaJSON = data
+}
+
+// compileAgentSchema compiles an agent schema with format assertions
+// enabled, so malformed values such as an invalid expiration are rejected
+func compileAgentSchema(schema []byte) *jsonschema.Schema {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	if err := compiler.AddResource("agent.schema.json", bytes.NewReader(schema)); err != nil {
+		log.Fatalf("Invalid agent schema: %v", err)
+	}
+	return compiler.MustCompile("agent.schema.json")
+}
+
+var missingPropertyPattern = regexp.MustCompile(`'([^']+)'`)
+
+// validateAgentSchema checks a decoded agent document against the agent JSON
+// Schema. Violations are reported in Fields keyed by the JSON path of the
+// offending value, e.g. "ttl" or "tags/0".
+func validateAgentSchema(doc interface{}) *sharewoodapi.ErrorResponse {
+	err := agentSchema.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return &sharewoodapi.ErrorResponse{
+			Error:   "Invalid agent",
+			Details: err.Error(),
+		}
+	}
+
+	fields := make(map[string]string)
+	var collect func(*jsonschema.ValidationError)
+	collect = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) > 0 {
+			for _, cause := range ve.Causes {
+				collect(cause)
+			}
+			return
+		}
+
+		path := strings.TrimPrefix(ve.InstanceLocation, "/")
+		if strings.HasSuffix(ve.KeywordLocation, "/required") {
+			// Report each missing property under its own name
+			for _, match := range missingPropertyPattern.FindAllStringSubmatch(ve.Message, -1) {
+				fields[strings.TrimPrefix(path+"/"+match[1], "/")] = "is required"
+			}
+			return
+		}
+		fields[path] = ve.Message
+	}
+	collect(validationErr)
+
+	return &sharewoodapi.ErrorResponse{
+		Error:   "Invalid agent",
+		Details: "The agent does not match the agent schema; see fields for details",
+		Fields:  fields,
+	}
+}
+
+// decodeAgentBody decodes the request body into agent after validating it
+// against the agent schema, writing an error response and returning false if
+// either step fails
+func decodeAgentBody(c *gin.Context, agent *sharewoodapi.Agent) bool {
+	var body json.RawMessage
+	if !decodeJSONBody(c, &body) {
+		return false
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid request body",
+			Details: err.Error(),
+		})
+		return false
+	}
+	if errResp := validateAgentSchema(doc); errResp != nil {
+		respondError(c, http.StatusBadRequest, *errResp)
+		return false
+	}
+
+	if err := json.Unmarshal(body, agent); err != nil {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid request body",
+			Details: err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// Agent Schema endpoint - serves the JSON Schema that agent payloads are
+// validated against, including the configured required fields
+func getAgentSchema(c *gin.Context) {
+	c.Data(http.StatusOK, "application/schema+json", agentSchemaJSON)
+}
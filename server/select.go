@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Counter driving round-robin selection across requests
+var selectCounter uint64
+
+// Severity of each Consul health status, used to find an agent's worst check
+var healthSeverity = map[sharewoodapi.HealthStatus]int{
+	sharewoodapi.HealthPassing:     0,
+	sharewoodapi.HealthWarning:     1,
+	sharewoodapi.HealthCritical:    2,
+	sharewoodapi.HealthMaintenance: 2,
+}
+
+// agentHealthStatuses returns the aggregate health of every service that has
+// checks, keyed by service ID. The aggregate is the worst status of its
+// checks, so an agent with both a TTL and an HTTP check is only passing
+// while both are.
+func agentHealthStatuses(ctx context.Context) (map[string]sharewoodapi.HealthStatus, error) {
+	checks, err := consulChecks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent checks: %w", err)
+	}
+
+	statuses := make(map[string]sharewoodapi.HealthStatus)
+	for _, check := range checks {
+		if check.ServiceID == "" {
+			continue
+		}
+		status := sharewoodapi.HealthStatus(check.Status)
+		current, ok := statuses[check.ServiceID]
+		if !ok || healthSeverity[status] > healthSeverity[current] {
+			statuses[check.ServiceID] = status
+		}
+	}
+	return statuses, nil
+}
+
+// serviceHealth looks up a service in the result of agentHealthStatuses.
+// Services without checks are considered passing, as Consul does.
+func serviceHealth(statuses map[string]sharewoodapi.HealthStatus, serviceID string) sharewoodapi.HealthStatus {
+	if status, ok := statuses[serviceID]; ok {
+		return status
+	}
+	return sharewoodapi.HealthPassing
+}
+
+// Select Agent endpoint - picks one healthy agent matching the filter, either
+// round-robin (default) or at random. Agents whose checks are critical and
+// retired agents are never selected. Each instance of a scaled agent is a
+// candidate of its own. Both strategies honor agent weights: an agent of
+// weight 3 is picked three times as often as one of weight 1, and warning
+// agents count as weight 1, as in Consul's load balancing. The selected
+// agent lists all its base URLs, primary first, so callers can fail over to
+// the others.
+func selectAgent(c *gin.Context) {
+	tag := c.Query("tag")
+	environment := c.Query("env")
+	strategy := c.DefaultQuery("strategy", "roundrobin")
+	if strategy != "roundrobin" && strategy != "random" {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "Invalid strategy",
+			Details: "strategy must be 'roundrobin' or 'random'",
+		})
+		return
+	}
+
+	services, err := agentServicesByKey(c.Request.Context())
+	if err != nil {
+		log.Printf("Error selecting agent: %v", err)
+		respondConsulError(c, "Failed to select agent", err)
+		return
+	}
+
+	statuses, err := agentHealthStatuses(c.Request.Context())
+	if err != nil {
+		log.Printf("Error selecting agent: %v", err)
+		respondConsulError(c, "Failed to select agent", err)
+		return
+	}
+
+	candidates := make([]sharewoodapi.Agent, 0)
+	for _, service := range services {
+		if status, ok := statuses[service.ID]; ok && healthSeverity[status] >= healthSeverity[sharewoodapi.HealthCritical] {
+			continue
+		}
+
+		agent := serviceToAgent(service)
+		agent.Health = serviceHealth(statuses, service.ID)
+		if agent.Lifecycle == sharewoodapi.LifecycleRetired {
+			continue
+		}
+		if environment != "" && agent.Environment != environment {
+			continue
+		}
+		if tag != "" && !hasTag(agent, tag) {
+			continue
+		}
+		candidates = append(candidates, agent)
+	}
+
+	if len(candidates) == 0 {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeNoHealthyAgent,
+			Error:   "No healthy agent available",
+			Details: fmt.Sprintf("No passing agent matches tag '%s'", tag),
+		})
+		return
+	}
+
+	// Keep a stable order so round-robin cycles through every candidate
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Name != candidates[j].Name {
+			return candidates[i].Name < candidates[j].Name
+		}
+		return candidates[i].InstanceID < candidates[j].InstanceID
+	})
+
+	c.JSON(http.StatusOK, sharewoodapi.AgentResponse{
+		Agent: pickCandidate(candidates, strategy),
+	})
+}
+
+// pickCandidate picks one of candidates, which must not be empty, with the
+// given strategy. Each candidate's chance is its share of the total
+// selection weight.
+func pickCandidate(candidates []sharewoodapi.Agent, strategy string) sharewoodapi.Agent {
+	// Pick a point within the total weight, then the candidate whose share
+	// of it holds the point
+	total := 0
+	for _, agent := range candidates {
+		total += selectionWeight(agent)
+	}
+	var point int
+	if strategy == "random" {
+		point = rand.Intn(total)
+	} else {
+		point = int((atomic.AddUint64(&selectCounter, 1) - 1) % uint64(total))
+	}
+	index := 0
+	for point >= selectionWeight(candidates[index]) {
+		point -= selectionWeight(candidates[index])
+		index++
+	}
+	return candidates[index]
+}
+
+// selectionWeight returns an agent's share of selections: its weight while
+// passing, and 1 while warning or when it has no weight
+func selectionWeight(agent sharewoodapi.Agent) int {
+	if agent.Health == sharewoodapi.HealthWarning || agent.Weight < 1 {
+		return 1
+	}
+	return agent.Weight
+}
+
+// hasTag reports whether the agent carries the given tag
+func hasTag(agent sharewoodapi.Agent, tag string) bool {
+	for _, t := range agent.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
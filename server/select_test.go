@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestHigherWeightedAgentIsSelectedMoreOften(t *testing.T) {
+	candidates := []sharewoodapi.Agent{
+		{Name: "heavy", Weight: 3, Health: sharewoodapi.HealthPassing},
+		{Name: "light", Weight: 1, Health: sharewoodapi.HealthPassing},
+	}
+
+	for _, strategy := range []string{"roundrobin", "random"} {
+		const picks = 8000
+		counts := make(map[string]int)
+		for i := 0; i < picks; i++ {
+			counts[pickCandidate(candidates, strategy).Name]++
+		}
+
+		// heavy should get three quarters of the picks
+		share := float64(counts["heavy"]) / picks
+		if share < 0.72 || share > 0.78 {
+			t.Errorf("%s: heavy got %.1f%% of picks, want about 75%%", strategy, share*100)
+		}
+	}
+}
+
+func TestWarningAgentCountsAsWeightOne(t *testing.T) {
+	candidates := []sharewoodapi.Agent{
+		{Name: "warning", Weight: 9, Health: sharewoodapi.HealthWarning},
+		{Name: "passing", Weight: 1, Health: sharewoodapi.HealthPassing},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[pickCandidate(candidates, "roundrobin").Name]++
+	}
+	if counts["warning"] != 50 || counts["passing"] != 50 {
+		t.Fatalf("got %v, want an even split", counts)
+	}
+}
@@ -14,7 +14,7 @@ fi
 
 # Build sharewoodserver
 echo "Building sharewoodserver..."
-go build -o sharewoodserver main.go
+go build -o sharewoodserver .
 
 # Check if build was successful
 if [ $? -eq 0 ]; then
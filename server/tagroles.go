@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// authorizeAgentTags enforces TAG_ROLES: an agent carrying a tag that
+// requires a role may only be registered or changed by a caller with that
+// role or an admin. The tags before the change count as well as the tags
+// after it, so a restricted tag can be neither added nor removed without
+// the role. A violation is answered with 403 naming the tag, and false is
+// returned.
+func authorizeAgentTags(c *gin.Context, before, after []string) bool {
+	callerRole := sharewoodapi.Role(c.GetString("role"))
+	if callerRole == sharewoodapi.RoleAdmin {
+		return true
+	}
+
+	field := "tags"
+	_, tag, required := restrictedTag(before, callerRole)
+	if i, afterTag, afterRequired := restrictedTag(after, callerRole); afterTag != "" {
+		field, tag, required = fmt.Sprintf("tags/%d", i), afterTag, afterRequired
+	}
+	if tag == "" {
+		return true
+	}
+
+	respondError(c, http.StatusForbidden, sharewoodapi.ErrorResponse{
+		Error:   "Tag requires a higher role",
+		Details: fmt.Sprintf("Agents tagged '%s' can only be registered or changed with the %s role", tag, required),
+		Fields:  map[string]string{field: fmt.Sprintf("'%s' requires the %s role", tag, required)},
+	})
+	return false
+}
+
+// restrictedTag returns the first of tags requiring a role other than
+// callerRole, with its index and that role. tag is "" if there is none.
+func restrictedTag(tags []string, callerRole sharewoodapi.Role) (index int, tag string, required sharewoodapi.Role) {
+	for i, tag := range tags {
+		if required, ok := cfg.TagRoles[tag]; ok && required != callerRole {
+			return i, tag, required
+		}
+	}
+	return -1, "", ""
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestRestrictedTagsRequireTheirRole(t *testing.T) {
+	_, router := newTestRegistry(t)
+	admin, publisher := roleHeaders(t)
+	cfg.TagRoles = map[string]sharewoodapi.Role{"production": sharewoodapi.RoleAdmin}
+
+	expectForbidden := func(code int, body []byte, what string) {
+		t.Helper()
+		if code != http.StatusForbidden {
+			t.Fatalf("%s: got %d, want 403: %s", what, code, body)
+		}
+		var errResp sharewoodapi.ErrorResponse
+		json.Unmarshal(body, &errResp)
+		if !strings.Contains(errResp.Details, "production") {
+			t.Errorf("%s: the error does not name the tag: %s", what, body)
+		}
+	}
+
+	prod := testAgent("billing")
+	prod.Tags = []string{"finance", "production"}
+	resp := serve(router, http.MethodPost, "/api/v1/agents", prod, publisher)
+	expectForbidden(resp.Code, resp.Body.Bytes(), "publisher registering a production agent")
+	if !strings.Contains(resp.Body.String(), `"tags/1"`) {
+		t.Errorf("the error does not point at the tag: %s", resp.Body)
+	}
+
+	if resp := serve(router, http.MethodPost, "/api/v1/agents", prod, admin); resp.Code != http.StatusCreated {
+		t.Fatalf("admin registering a production agent: got %d: %s", resp.Code, resp.Body)
+	}
+
+	dev := testAgent("sandbox")
+	dev.Tags = []string{"dev"}
+	if resp := serve(router, http.MethodPost, "/api/v1/agents", dev, publisher); resp.Code != http.StatusCreated {
+		t.Fatalf("publisher registering a dev agent: got %d: %s", resp.Code, resp.Body)
+	}
+
+	// Neither adding the tag nor changing an agent that carries it is left
+	// to a publisher
+	resp = serve(router, http.MethodPatch, "/api/v1/agents/sandbox", map[string]interface{}{"tags": []string{"dev", "production"}}, publisher)
+	expectForbidden(resp.Code, resp.Body.Bytes(), "publisher tagging an agent production")
+	resp = serve(router, http.MethodPatch, "/api/v1/agents/billing", map[string]interface{}{"tags": []string{"finance"}}, publisher)
+	expectForbidden(resp.Code, resp.Body.Bytes(), "publisher untagging a production agent")
+
+	if resp := serve(router, http.MethodPatch, "/api/v1/agents/sandbox", map[string]interface{}{"tags": []string{"dev", "production"}}, admin); resp.Code != http.StatusOK {
+		t.Fatalf("admin tagging an agent production: got %d: %s", resp.Code, resp.Body)
+	}
+	if resp := serve(router, http.MethodPatch, "/api/v1/agents/billing", map[string]interface{}{"description": "Bills customers"}, admin); resp.Code != http.StatusOK {
+		t.Fatalf("admin changing a production agent: got %d: %s", resp.Code, resp.Body)
+	}
+}
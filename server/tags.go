@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Allowed Tags endpoint - returns the tag vocabulary agents are restricted
+// to by ALLOWED_TAGS. Restricted is false, and any tag accepted, when none is
+// configured.
+func listAllowedTags(c *gin.Context) {
+	tags := append([]string{}, cfg.AllowedTags...)
+	sort.Strings(tags)
+
+	c.JSON(http.StatusOK, sharewoodapi.AllowedTags{
+		Restricted: len(tags) > 0,
+		Tags:       tags,
+	})
+}
+
+// List Tags endpoint - returns every tag in use across AI agents with the
+// number of agents carrying it, most used first. The optional prefix query
+// parameter restricts the result to tags starting with it, for autocomplete.
+func listTags(c *gin.Context) {
+	prefix := c.Query("prefix")
+
+	services, err := consulServices(c.Request.Context())
+	if err != nil {
+		log.Printf("Error listing tags: %v", err)
+		respondConsulError(c, "Failed to list tags", err)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, instances := range groupAgentInstances(services) {
+		// serviceToAgent already drops the discriminator tag and duplicates
+		for _, tag := range instancesToAgent(instances, nil).Tags {
+			if strings.HasPrefix(tag, prefix) {
+				counts[tag]++
+			}
+		}
+	}
+
+	tags := make([]sharewoodapi.TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tags = append(tags, sharewoodapi.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+
+	c.JSON(http.StatusOK, tags)
+}
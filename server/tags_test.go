@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func allowedTags(t *testing.T, router http.Handler) sharewoodapi.AllowedTags {
+	t.Helper()
+	resp := serve(router, http.MethodGet, "/api/v1/tags/allowed", nil, nil)
+	var allowed sharewoodapi.AllowedTags
+	if err := json.Unmarshal(resp.Body.Bytes(), &allowed); err != nil || resp.Code != http.StatusOK {
+		t.Fatalf("reading the allowed tags: got %d: %s", resp.Code, resp.Body)
+	}
+	return allowed
+}
+
+func TestAnyTagIsAllowedWithoutAVocabulary(t *testing.T) {
+	_, router := newTestRegistry(t)
+	agent := testAgent("weather")
+	agent.Tags = []string{"anything", "goes"}
+	registerTestAgent(t, router, agent)
+
+	if allowed := allowedTags(t, router); allowed.Restricted || len(allowed.Tags) != 0 {
+		t.Errorf("got %+v, want an unrestricted vocabulary", allowed)
+	}
+}
+
+func TestTagsOutsideTheVocabularyAreRejected(t *testing.T) {
+	t.Setenv("ALLOWED_TAGS", "weather,geo,demo")
+	_, router := newTestRegistry(t)
+
+	if allowed := allowedTags(t, router); !allowed.Restricted || strings.Join(allowed.Tags, ",") != "demo,geo,weather" {
+		t.Errorf("got %+v, want the sorted vocabulary", allowed)
+	}
+
+	registerTestAgent(t, router, testAgent("weather"))
+
+	agent := testAgent("news")
+	agent.Tags = []string{"geo", "finance", "crypto"}
+	resp := serve(router, http.MethodPost, "/api/v1/agents", agent, nil)
+	var errResp sharewoodapi.ErrorResponse
+	json.Unmarshal(resp.Body.Bytes(), &errResp)
+	if resp.Code != http.StatusBadRequest {
+		t.Fatalf("registering with disallowed tags: got %d, want 400: %s", resp.Code, resp.Body)
+	}
+	if len(errResp.Fields) != 2 || errResp.Fields["tags/1"] == "" || errResp.Fields["tags/2"] == "" {
+		t.Errorf("got fields %v, want errors on tags/1 and tags/2 only", errResp.Fields)
+	}
+
+	resp = serve(router, http.MethodPatch, "/api/v1/agents/weather", map[string]interface{}{"tags": []string{"geo", "finance"}}, nil)
+	if resp.Code != http.StatusBadRequest || !strings.Contains(resp.Body.String(), "finance") {
+		t.Errorf("patching in a disallowed tag: got %d, want 400 naming it: %s", resp.Code, resp.Body)
+	}
+	patchTestAgent(t, router, "weather", map[string]interface{}{"tags": []string{"weather"}})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Update Tags endpoint - adds and removes individual tags, leaving the
+// agent's other tags and fields as they are, and returns the resulting tags.
+// Removals are applied before additions. The discriminator tag marking
+// services as agents cannot be added or removed.
+func updateAgentTags(c *gin.Context) {
+	name := c.Param("name")
+
+	var update sharewoodapi.TagUpdate
+	if !decodeJSONBody(c, &update) {
+		return
+	}
+
+	if len(update.Add) == 0 && len(update.Remove) == 0 {
+		respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+			Error:   "No changes supplied",
+			Details: "Provide tags to add or remove",
+		})
+		return
+	}
+	for _, tag := range append(append([]string{}, update.Add...), update.Remove...) {
+		if tag == "" || tag == cfg.AgentTag {
+			respondError(c, http.StatusBadRequest, sharewoodapi.ErrorResponse{
+				Error:   "Invalid tag",
+				Details: fmt.Sprintf("'%s' cannot be added or removed: tags must be non-empty and '%s' is reserved", tag, cfg.AgentTag),
+			})
+			return
+		}
+	}
+
+	service, err := findAgentInstance(c.Request.Context(), name, c.Query("env"), c.Query("instance"))
+	if err != nil {
+		log.Printf("Error checking agent existence: %v", err)
+		respondConsulError(c, "Failed to check agent existence", err)
+		return
+	}
+
+	if service == nil {
+		respondError(c, http.StatusNotFound, sharewoodapi.ErrorResponse{
+			Code:    sharewoodapi.CodeAgentNotFound,
+			Error:   "Agent not found",
+			Details: fmt.Sprintf("No agent with the name '%s' was found", name),
+		})
+		return
+	}
+
+	agent := serviceToAgent(service)
+	previousTags := agent.Tags
+
+	tags := make(map[string]bool, len(agent.Tags)+len(update.Add))
+	for _, tag := range agent.Tags {
+		tags[tag] = true
+	}
+	for _, tag := range update.Remove {
+		delete(tags, tag)
+	}
+	for _, tag := range update.Add {
+		tags[tag] = true
+	}
+	agent.Tags = make([]string, 0, len(tags))
+	for tag := range tags {
+		agent.Tags = append(agent.Tags, tag)
+	}
+	sort.Strings(agent.Tags)
+	agent.UpdatedAt = timestampNow()
+
+	if errResp := validateAgent(agent); errResp != nil {
+		respondError(c, http.StatusBadRequest, *errResp)
+		return
+	}
+	if !authorizeAgentTags(c, previousTags, agent.Tags) {
+		return
+	}
+
+	if err := reregisterAgent(c.Request.Context(), service.ID, agent); err != nil {
+		log.Printf("Error updating agent tags: %v", err)
+		respondConsulError(c, "Failed to update agent tags", err)
+		return
+	}
+	agentChanged(c.Request.Context(), service, service.ID, &agent)
+
+	c.Header("ETag", agentETag(storedAgent(service.ID, agent)))
+	c.JSON(http.StatusOK, sharewoodapi.AgentTags{Tags: agent.Tags})
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// versionPath is where the registry reports its build and Consul versions
+const versionPath = "/version"
+
+// Build information, injected at build time with
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// Version endpoint - reports the server build and the version of the Consul
+// agent it is connected to. The Consul version is omitted when Consul cannot
+// be reached, so operators can still confirm what is deployed.
+func getVersion(c *gin.Context) {
+	info := sharewoodapi.VersionInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+	}
+
+	self, err := consulAgentSelf()
+	if err != nil {
+		log.Printf("Error reading Consul version: %v", err)
+	} else if consulVersion, ok := self["Config"]["Version"].(string); ok {
+		info.ConsulVersion = consulVersion
+	}
+
+	c.JSON(http.StatusOK, info)
+}
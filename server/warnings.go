@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// TTLs below this are accepted but likely to flap between passing and critical
+const shortTTLWarning = 30 * time.Second
+
+// registrationWarnings returns non-fatal advisories about an agent that
+// passed validation. They are reported with the registration but never
+// prevent it.
+func registrationWarnings(ctx context.Context, agent sharewoodapi.Agent) []string {
+	warnings := make([]string, 0)
+
+	if agent.Expiration.IsZero() {
+		warnings = append(warnings, "agent has no expiration and stays registered until it is deregistered")
+	}
+
+	if ttl := time.Duration(agent.TTL) * time.Second; ttl > 0 && ttl < shortTTLWarning {
+		warnings = append(warnings, fmt.Sprintf("ttl of %s is very short; the agent turns critical if a single keep-alive is late", ttl))
+	}
+
+	if cfg.CheckOpenAPIURL && agent.OpenAPI != "" {
+		if warning := openAPIURLWarning(ctx, agent.OpenAPI); warning != "" {
+			warnings = append(warnings, warning)
+		}
+	}
+
+	return warnings
+}
+
+// openAPIURLWarning probes an OpenAPI reference that is an HTTP URL and
+// describes why it could not be fetched. Inline specs are not checked.
+func openAPIURLWarning(ctx context.Context, openAPI string) string {
+	u, err := url.Parse(openAPI)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+
+	statusCode, err := probeURL(ctx, pingClient(), openAPI)
+	if err != nil {
+		return fmt.Sprintf("openapi URL %s is unreachable: %v", openAPI, err)
+	}
+	if statusCode >= http.StatusBadRequest {
+		return fmt.Sprintf("openapi URL %s returned status %d", openAPI, statusCode)
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+func TestRegistrationWarnings(t *testing.T) {
+	_, router := newTestRegistry(t)
+	cfg.CheckOpenAPIURL = true
+	// Without a default expiration an agent can be registered without one
+	cfg.DefaultExpiration = 0
+	client := newTestClient(t, router)
+
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	expiring := func(name string) sharewoodapi.Agent {
+		agent := testAgent(name)
+		agent.Expiration = time.Now().Add(24 * time.Hour)
+		return agent
+	}
+
+	noExpiration := testAgent("no-expiration")
+	shortTTL := expiring("short-ttl")
+	shortTTL.TTL = 15
+	missingSpec := expiring("missing-spec")
+	missingSpec.OpenAPI = missing.URL + "/openapi.json"
+	unreachableSpec := expiring("unreachable-spec")
+	unreachableSpec.OpenAPI = closed.URL + "/openapi.json"
+
+	for _, tc := range []struct {
+		agent sharewoodapi.Agent
+		want  string
+	}{
+		{noExpiration, "has no expiration"},
+		{shortTTL, "ttl of 15s is very short"},
+		{missingSpec, "returned status 404"},
+		{unreachableSpec, "is unreachable"},
+	} {
+		registered, warnings, err := client.RegisterAgentWithWarnings(tc.agent)
+		if err != nil || registered == nil {
+			t.Errorf("registering %s: %v", tc.agent.Name, err)
+			continue
+		}
+		if len(warnings) != 1 || !strings.Contains(warnings[0], tc.want) {
+			t.Errorf("registering %s: got warnings %q, want one containing %q", tc.agent.Name, warnings, tc.want)
+		}
+	}
+
+	if _, warnings, err := client.RegisterAgentWithWarnings(expiring("clean")); err != nil || len(warnings) != 0 {
+		t.Errorf("registering an unremarkable agent: got warnings %q, %v", warnings, err)
+	}
+}
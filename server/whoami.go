@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// Ways a caller can be authenticated, reported by the whoami endpoint
+const (
+	authMethodAPIKey = "api-key"
+	authMethodJWT    = "jwt"
+	authMethodDev    = "dev"
+)
+
+// Who Am I endpoint - reports the identity and role the caller's credentials
+// authenticate as. Unauthenticated callers are turned away with 401 by the
+// auth middleware before reaching it.
+func whoAmI(c *gin.Context) {
+	identity := sharewoodapi.Identity{
+		UserID: callerIdentity(c),
+		Role:   sharewoodapi.Role(c.GetString("role")),
+		Method: c.GetString("auth_method"),
+	}
+	if expiresAt, ok := c.Get("token_expires_at"); ok {
+		t := expiresAt.(time.Time)
+		identity.ExpiresAt = &t
+	}
+	c.JSON(http.StatusOK, identity)
+}
@@ -2,26 +2,44 @@ package sharewoodapi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 // ConsulClient is the client for interacting with the Consul AI Agent Registry API
 type ConsulClient struct {
-	serverURL string
-	apiKey    string
-	client    *http.Client
-	debug     bool
+	serverURL         string
+	rootURL           string
+	apiKey            string
+	client            *http.Client
+	timeout           time.Duration
+	timeouts          methodTimeouts
+	logger            Logger
+	allowAnyURLScheme bool
+	tokenKey          interface{}
+	maxRetries        int
+	retryAnyMethod    bool
+
+	// Background work (watches, streams) is stopped when closed is closed
+	closed    chan struct{}
+	closeOnce sync.Once
+	workers   sync.WaitGroup
 }
 
 // DefaultOptions returns the default client options
 func DefaultOptions() ClientOptions {
 	return ClientOptions{
-		ServerURL: "http://localhost:3000/api/v1",
+		ServerURL: "http://localhost:3000",
+		BasePath:  "/api/v1",
 		APIKey:    "test-api-key",
 		Timeout:   10 * time.Second,
 		Debug:     false,
@@ -30,19 +48,375 @@ func DefaultOptions() ClientOptions {
 
 // NewClient creates a new ConsulClient with the specified options
 func NewClient(options ClientOptions) *ConsulClient {
+	// Older configurations put the API prefix in ServerURL itself; accept both forms
+	rootURL := strings.TrimRight(options.ServerURL, "/")
+	basePath := ""
+	if trimmed := strings.Trim(options.BasePath, "/"); trimmed != "" {
+		basePath = "/" + trimmed
+	}
+	rootURL = strings.TrimSuffix(rootURL, basePath)
+
+	logger := options.Logger
+	if logger == nil && options.Debug {
+		logger = stdLogger{}
+	}
+
+	maxRetries := options.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
 	return &ConsulClient{
-		serverURL: options.ServerURL,
+		serverURL: rootURL + basePath,
+		rootURL:   rootURL,
 		apiKey:    options.APIKey,
-		client: &http.Client{
-			Timeout: options.Timeout,
+		// Timeouts are applied per request in doRequest, so that a method's
+		// default may exceed the global Timeout. The transport is a clone of
+		// the default one, which asks for gzip and transparently decompresses
+		// responses, so Close only drops this client's idle connections.
+		client:  &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		timeout: options.Timeout,
+		timeouts: methodTimeouts{
+			list:     options.ListTimeout,
+			register: options.RegisterTimeout,
+			bulk:     options.BulkTimeout,
+			ping:     options.PingTimeout,
+			watch:    options.WatchTimeout,
 		},
-		debug: options.Debug,
+		logger:            logger,
+		allowAnyURLScheme: options.AllowAnyURLScheme,
+		tokenKey:          options.TokenKey,
+		maxRetries:        maxRetries,
+		retryAnyMethod:    options.RetryNonIdempotent,
+		closed:            make(chan struct{}),
 	}
 }
 
+// Close stops every watch and stream started by the client, waits for their
+// goroutines to exit and closes idle connections. The client must not be used
+// after Close. Calling Close more than once is safe.
+func (c *ConsulClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	c.workers.Wait()
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+// backgroundContext derives a context for long-running work that is cancelled
+// either by the caller or when the client is closed
+func (c *ConsulClient) backgroundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-c.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
 // ListAgents retrieves all agents from the registry
 func (c *ConsulClient) ListAgents() ([]Agent, error) {
-	req, err := http.NewRequest("GET", c.serverURL+"/agents", nil)
+	return c.listAgents(nil)
+}
+
+// ListAgentsByOwner retrieves the agents owned by the given team or user
+func (c *ConsulClient) ListAgentsByOwner(owner string) ([]Agent, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("owner cannot be empty")
+	}
+	return c.listAgents(url.Values{"owner": {owner}})
+}
+
+// ListAgentsByEnvironment retrieves the agents registered for the given
+// environment, e.g. "prod"
+func (c *ConsulClient) ListAgentsByEnvironment(environment string) ([]Agent, error) {
+	if environment == "" {
+		return nil, fmt.Errorf("environment cannot be empty")
+	}
+	return c.listAgents(url.Values{"env": {environment}})
+}
+
+// ListAgentsByTag retrieves the agents carrying the given tag
+func (c *ConsulClient) ListAgentsByTag(tag string) ([]Agent, error) {
+	if tag == "" {
+		return nil, fmt.Errorf("tag cannot be empty")
+	}
+	return c.listAgents(url.Values{"tag": {tag}})
+}
+
+// ListAgentsByPreparedQuery retrieves the agents found by the named Consul
+// prepared query, reusing the discovery policy it defines. Consul fails the
+// query over to other datacenters as the query specifies. An unknown query
+// is reported as a 404 error.
+func (c *ConsulClient) ListAgentsByPreparedQuery(name string) ([]Agent, error) {
+	if name == "" {
+		return nil, fmt.Errorf("prepared query name cannot be empty")
+	}
+	return c.listAgents(url.Values{"preparedQuery": {name}})
+}
+
+// SearchAgents retrieves the agents whose name, description or usage
+// instructions contain query, ignoring case. Agents named exactly query come
+// first. This is a simple substring search rather than a ranked full-text
+// index; an empty query returns all agents.
+func (c *ConsulClient) SearchAgents(query string) ([]Agent, error) {
+	if query == "" {
+		return c.ListAgents()
+	}
+	return c.listAgents(url.Values{"q": {query}})
+}
+
+// ListAgentsByMetadata retrieves the agents whose custom metadata holds every
+// given key/value pair
+func (c *ConsulClient) ListAgentsByMetadata(metadata map[string]string) ([]Agent, error) {
+	if len(metadata) == 0 {
+		return nil, fmt.Errorf("metadata filter cannot be empty")
+	}
+	query := url.Values{}
+	for key, value := range metadata {
+		query.Set("meta."+key, value)
+	}
+	return c.listAgents(query)
+}
+
+// FindAgentsWithOpenAPI retrieves the agents that publish an OpenAPI spec
+func (c *ConsulClient) FindAgentsWithOpenAPI() ([]Agent, error) {
+	return c.listAgents(url.Values{"hasOpenapi": {"true"}})
+}
+
+// FindAgentsByReleaseConstraint retrieves the agents whose release satisfies
+// a semver range such as ">=1.2.0 <2.0.0". Agents whose release is not a
+// semantic version are excluded.
+func (c *ConsulClient) FindAgentsByReleaseConstraint(constraint string) ([]Agent, error) {
+	if _, err := ParseVersionConstraint(constraint); err != nil {
+		return nil, err
+	}
+	return c.listAgents(url.Values{"releaseRange": {constraint}})
+}
+
+// ListAgentsSorted retrieves all agents ordered by sortBy, one of "name",
+// "createdAt" or "updatedAt", oldest or alphabetically first unless
+// descending is set
+func (c *ConsulClient) ListAgentsSorted(sortBy string, descending bool) ([]Agent, error) {
+	query := url.Values{"sort": {sortBy}}
+	if descending {
+		query.Set("order", "desc")
+	}
+	return c.listAgents(query)
+}
+
+// ListAgentsByLifecycle retrieves the agents in the given lifecycle state
+// (draft, active, deprecated or retired)
+func (c *ConsulClient) ListAgentsByLifecycle(state string) ([]Agent, error) {
+	if !IsValidLifecycle(state) {
+		return nil, fmt.Errorf("invalid lifecycle %q", state)
+	}
+	return c.listAgents(url.Values{"lifecycle": {state}})
+}
+
+// listAgents retrieves agents from the registry, applying the given query filters
+func (c *ConsulClient) listAgents(query url.Values) ([]Agent, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("envelope", "true")
+	reqURL := c.serverURL + "/agents?" + query.Encode()
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.list)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	return decodeAgentList(body, header)
+}
+
+// ListAgentsCursor retrieves one page of agents, ordered by name. Pass an
+// empty cursor for the first page and the returned NextCursor for each
+// following page until it is empty. A limit of 0 uses the server's default
+// page size. Agents registered or removed while paging do not cause others
+// to be skipped or repeated.
+func (c *ConsulClient) ListAgentsCursor(cursor string, limit int) (AgentPage, error) {
+	query := url.Values{"cursor": {cursor}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	req, err := http.NewRequest("GET", c.serverURL+"/agents?"+query.Encode(), nil)
+	if err != nil {
+		return AgentPage{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.list)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return AgentPage{}, err
+	}
+
+	if statusCode != http.StatusOK {
+		return AgentPage{}, extractErrorFromResponse(statusCode, body)
+	}
+
+	var page AgentPage
+	if err := json.Unmarshal(body, &page); err != nil {
+		return AgentPage{}, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return page, nil
+}
+
+// ServerVersion retrieves the registry server's build information and the
+// version of the Consul agent it is connected to. The endpoint requires no
+// authentication.
+func (c *ConsulClient) ServerVersion() (VersionInfo, error) {
+	req, err := http.NewRequest("GET", c.rootURL+"/version", nil)
+	if err != nil {
+		return VersionInfo{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return VersionInfo{}, err
+	}
+
+	if statusCode != http.StatusOK {
+		return VersionInfo{}, extractErrorFromResponse(statusCode, body)
+	}
+
+	var info VersionInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return VersionInfo{}, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return info, nil
+}
+
+// ListAgentsRaw returns the server's list response exactly as it was sent,
+// bypassing decoding into Agent. Use it to read fields added by a newer
+// server that this version of the client does not know about.
+func (c *ConsulClient) ListAgentsRaw() (json.RawMessage, error) {
+	return c.getRaw(c.serverURL + "/agents")
+}
+
+// GetAgentRaw returns the server's response for a single agent, of the form
+// {"agent": {...}}, exactly as it was sent. Like ListAgentsRaw it bypasses
+// decoding into Agent, so no fields are dropped.
+func (c *ConsulClient) GetAgentRaw(name string) (json.RawMessage, error) {
+	if name == "" {
+		return nil, fmt.Errorf("agent name cannot be empty")
+	}
+	return c.getRaw(fmt.Sprintf("%s/agents/%s", c.serverURL, url.PathEscape(name)))
+}
+
+// getRaw performs a GET request and returns the undecoded JSON response body
+func (c *ConsulClient) getRaw(reqURL string) (json.RawMessage, error) {
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("failed to parse JSON response: invalid JSON")
+	}
+
+	return json.RawMessage(body), nil
+}
+
+// ListTags retrieves the tags in use across all agents with their usage
+// counts, most used first. A non-empty prefix returns only the tags starting
+// with it.
+func (c *ConsulClient) ListTags(prefix string) ([]TagCount, error) {
+	reqURL := c.serverURL + "/tags"
+	if prefix != "" {
+		reqURL += "?" + url.Values{"prefix": {prefix}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.list)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var tags []TagCount
+	if err := json.Unmarshal(body, &tags); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return tags, nil
+}
+
+// WhoAmI reports the identity and role the client's credentials authenticate
+// as, confirming they are accepted. Invalid credentials yield a 401 APIError.
+func (c *ConsulClient) WhoAmI() (Identity, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/whoami", nil)
+	if err != nil {
+		return Identity{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	if statusCode != http.StatusOK {
+		return Identity{}, extractErrorFromResponse(statusCode, body)
+	}
+
+	var identity Identity
+	if err := json.Unmarshal(body, &identity); err != nil {
+		return Identity{}, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return identity, nil
+}
+
+// ListAllowedTags retrieves the tag vocabulary agents are restricted to, if
+// the registry has one
+func (c *ConsulClient) ListAllowedTags() (*AllowedTags, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/tags/allowed", nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -58,6 +432,30 @@ func (c *ConsulClient) ListAgents() ([]Agent, error) {
 		return nil, extractErrorFromResponse(statusCode, body)
 	}
 
+	var allowed AllowedTags
+	if err := json.Unmarshal(body, &allowed); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return &allowed, nil
+}
+
+// decodeAgentList parses a list response. Enveloped responses, requested
+// with ?envelope=true, always carry a plain array. Servers that predate the
+// envelope send either a bare JSON array or an object with an agents field.
+func decodeAgentList(body []byte, header http.Header) ([]Agent, error) {
+	if header.Get(EnvelopeHeader) == "true" {
+		var envelope Envelope
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+		}
+		var agents []Agent
+		if err := json.Unmarshal(envelope.Data, &agents); err != nil {
+			return nil, fmt.Errorf("failed to parse agents in response: %w", err)
+		}
+		return agents, nil
+	}
+
 	// Check the first non-whitespace character to determine the JSON type
 	jsonType := "unknown"
 	for i := 0; i < len(body); i++ {
@@ -97,11 +495,38 @@ func (c *ConsulClient) ListAgents() ([]Agent, error) {
 
 // GetAgent retrieves a specific agent by name
 func (c *ConsulClient) GetAgent(name string) (*Agent, error) {
+	agent, _, err := c.GetAgentWithETag(name)
+	return agent, err
+}
+
+// GetAgentIfExists retrieves an agent like GetAgent, but reports an agent
+// that is not registered as a nil agent, false and no error. Use it when
+// getting each agent of a list: an agent deregistered between the list and
+// the get is then skipped instead of failing the iteration.
+func (c *ConsulClient) GetAgentIfExists(name string) (*Agent, bool, error) {
+	agent, err := c.GetAgent(name)
+	if IsNotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return agent, true, nil
+}
+
+// GetAgentInEnvironment retrieves the variant of an agent registered for the
+// given environment. GetAgent retrieves the variant without an environment.
+func (c *ConsulClient) GetAgentInEnvironment(name, environment string) (*Agent, error) {
 	if name == "" {
 		return nil, fmt.Errorf("agent name cannot be empty")
 	}
 
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/agents/%s", c.serverURL, name), nil)
+	reqURL := fmt.Sprintf("%s/agents/%s", c.serverURL, url.PathEscape(name))
+	if environment != "" {
+		reqURL += "?" + url.Values{"env": {environment}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -125,34 +550,53 @@ func (c *ConsulClient) GetAgent(name string) (*Agent, error) {
 	return &result.Agent, nil
 }
 
-// RegisterAgent registers a new agent with the registry
-func (c *ConsulClient) RegisterAgent(agent Agent) (*Agent, error) {
-	// Validate required fields
-	if agent.Name == "" {
-		return nil, fmt.Errorf("agent name is required")
+// GetAgentWithETag retrieves an agent together with its ETag. Pass the ETag
+// to PatchAgentIfMatch to update the agent only if nobody else has changed it
+// in the meantime.
+func (c *ConsulClient) GetAgentWithETag(name string) (*Agent, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("agent name cannot be empty")
 	}
-	if agent.Description == "" {
-		return nil, fmt.Errorf("agent description is required")
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/agents/%s", c.serverURL, url.PathEscape(name)), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return nil, "", err
 	}
-	if agent.BaseURL == "" {
-		return nil, fmt.Errorf("agent base URL is required")
+
+	if statusCode != http.StatusOK {
+		return nil, "", extractErrorFromResponse(statusCode, body)
 	}
-	if agent.HowToUse == "" {
-		return nil, fmt.Errorf("agent how-to-use is required")
+
+	var result AgentResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JSON response: %w", err)
 	}
 
-	jsonData, err := json.Marshal(agent)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal agent to JSON: %w", err)
+	return &result.Agent, header.Get("ETag"), nil
+}
+
+// GetAgents retrieves several agents in one request. It returns the agents
+// found, in the order requested, and the names that matched no agent.
+func (c *ConsulClient) GetAgents(names []string) ([]Agent, []string, error) {
+	if len(names) == 0 {
+		return []Agent{}, []string{}, nil
 	}
 
-	if c.debug {
-		log.Printf("DEBUG - Sending agent data: %s", string(jsonData))
+	jsonData, err := json.Marshal(GetAgentsRequest{Names: names})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.serverURL+"/agents", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", c.serverURL+"/agents/get", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Add("X-API-Key", c.apiKey)
@@ -160,77 +604,1001 @@ func (c *ConsulClient) RegisterAgent(agent Agent) (*Agent, error) {
 
 	body, statusCode, err := c.doRequest(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	if statusCode != http.StatusCreated {
-		return nil, extractErrorFromResponse(statusCode, body)
+	if statusCode != http.StatusOK {
+		return nil, nil, extractErrorFromResponse(statusCode, body)
 	}
 
-	var response AgentRegistrationResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
+	var result GetAgentsResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON response: %w", err)
 	}
 
-	return &response.Agent, nil
+	return result.Agents, result.NotFound, nil
 }
 
-// DeregisterAgent removes an agent from the registry
-func (c *ConsulClient) DeregisterAgent(name string) error {
+// GetDependencies retrieves the registered agents that the named agent
+// depends on. Dependencies that are not registered are omitted.
+func (c *ConsulClient) GetDependencies(name string) ([]Agent, error) {
 	if name == "" {
-		return fmt.Errorf("agent name cannot be empty")
+		return nil, fmt.Errorf("agent name cannot be empty")
 	}
 
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/agents/%s", c.serverURL, name), nil)
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/agents/%s/dependencies?envelope=true", c.serverURL, url.PathEscape(name)), nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Add("X-API-Key", c.apiKey)
 
-	body, statusCode, err := c.doRequest(req)
+	body, statusCode, header, err := c.doRequestWithHeader(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if statusCode != http.StatusOK {
-		return extractErrorFromResponse(statusCode, body)
+		return nil, extractErrorFromResponse(statusCode, body)
 	}
 
-	return nil
+	return decodeAgentList(body, header)
 }
 
-// doRequest performs an HTTP request and returns the response body and status code
-func (c *ConsulClient) doRequest(req *http.Request) ([]byte, int, error) {
-	resp, err := c.client.Do(req)
+// PingAgent asks the registry to probe the agent's base URL and reports
+// whether it answered and how long it took
+func (c *ConsulClient) PingAgent(name string) (*PingResponse, error) {
+	if name == "" {
+		return nil, fmt.Errorf("agent name cannot be empty")
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/agents/%s/ping", c.serverURL, url.PathEscape(name)), nil)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	defer resp.Body.Close()
+	req = withMethodTimeout(req, c.timeouts.ping)
+
+	req.Header.Add("X-API-Key", c.apiKey)
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, statusCode, err := c.doRequest(req)
 	if err != nil {
-		return nil, resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
 	}
 
-	if c.debug {
-		log.Printf("DEBUG - Server response: %s", string(body))
+	var result PingResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
 	}
 
-	return body, resp.StatusCode, nil
+	return &result, nil
 }
 
-// extractErrorFromResponse parses error information from the response body
-func extractErrorFromResponse(statusCode int, body []byte) error {
-	// Try to parse as JSON error response
-	var errorResp ErrorResponse
-	if err := json.Unmarshal(body, &errorResp); err == nil && (errorResp.Error != "" || errorResp.Details != "") {
-		if errorResp.Details != "" {
-			return fmt.Errorf("%s: %s (Status: %d)", errorResp.Error, errorResp.Details, statusCode)
-		}
-		return fmt.Errorf("%s (Status: %d)", errorResp.Error, statusCode)
+// SelectAgent asks the registry to pick one healthy agent carrying the given
+// tag, rotating across the matching agents on successive calls. An empty tag
+// selects among all agents.
+func (c *ConsulClient) SelectAgent(tag string) (*Agent, error) {
+	reqURL := c.serverURL + "/agents/select"
+	if tag != "" {
+		reqURL += "?" + url.Values{"tag": {tag}}.Encode()
+	}
+
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result AgentResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return &result.Agent, nil
+}
+
+// RegisterAgent registers a new agent with the registry. Which other fields
+// are required depends on the server's configuration, so apart from the name
+// they are checked by the server.
+func (c *ConsulClient) RegisterAgent(agent Agent) (*Agent, error) {
+	registered, _, err := c.RegisterAgentWithWarnings(agent)
+	return registered, err
+}
+
+// RegisterAgentWithWarnings registers a new agent like RegisterAgent and also
+// returns the server's non-fatal warnings about it, such as a missing
+// expiration or a very short TTL. The agent is registered even when there are
+// warnings.
+func (c *ConsulClient) RegisterAgentWithWarnings(agent Agent) (*Agent, []string, error) {
+	response, err := c.RegisterAgentWithResponse(agent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &response.Agent, response.Warnings, nil
+}
+
+// RegisterAgentWithResponse registers a new agent like RegisterAgent and
+// returns the server's full response, including any warnings and the
+// agent's canonical URL from the Location header
+func (c *ConsulClient) RegisterAgentWithResponse(agent Agent) (*AgentRegistrationResponse, error) {
+	// Validate required fields
+	if agent.Name == "" {
+		return nil, fmt.Errorf("agent name is required")
+	}
+	if agent.BaseURL == "" && len(agent.BaseURLs) > 0 {
+		return nil, fmt.Errorf("agent base URL is required when base URLs are given")
+	}
+	for _, u := range agent.BaseURLs {
+		if err := ValidateBaseURL(u, c.allowAnyURLScheme); err != nil {
+			return nil, fmt.Errorf("invalid agent base URL: %w", err)
+		}
+	}
+	if agent.BaseURL != "" {
+		if err := ValidateBaseURL(agent.BaseURL, c.allowAnyURLScheme); err != nil {
+			return nil, fmt.Errorf("invalid agent base URL: %w", err)
+		}
+	}
+
+	jsonData, err := json.Marshal(agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal agent to JSON: %w", err)
+	}
+
+	c.logDebug("Sending agent data", "body", string(jsonData))
+
+	return c.registerAgent(bytes.NewBuffer(jsonData))
+}
+
+// RegisterAgentFrom registers a new agent using the JSON document read from r.
+// The body is streamed to the server without being buffered in memory, which
+// allows registering directly from a file handle. Unlike RegisterAgent, the
+// required fields are validated by the server only.
+func (c *ConsulClient) RegisterAgentFrom(r io.Reader) (*Agent, error) {
+	registered, _, err := c.RegisterAgentFromWithWarnings(r)
+	return registered, err
+}
+
+// RegisterAgentFromWithWarnings registers an agent like RegisterAgentFrom and
+// also returns the server's non-fatal warnings about it
+func (c *ConsulClient) RegisterAgentFromWithWarnings(r io.Reader) (*Agent, []string, error) {
+	if r == nil {
+		return nil, nil, fmt.Errorf("agent reader cannot be nil")
+	}
+
+	c.logDebug("Sending streamed agent data")
+
+	response, err := c.registerAgent(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &response.Agent, response.Warnings, nil
+}
+
+// registerAgent posts the JSON agent document read from body to the registry
+// and returns the server's response
+func (c *ConsulClient) registerAgent(body io.Reader) (*AgentRegistrationResponse, error) {
+	req, err := http.NewRequest("POST", c.serverURL+"/agents", body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.register)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	respBody, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusCreated {
+		return nil, extractErrorFromResponse(statusCode, respBody)
+	}
+
+	var response AgentRegistrationResponse
+	if err := json.Unmarshal(respBody, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	response.Location = header.Get("Location")
+
+	return &response, nil
+}
+
+// ValidateAgent performs a dry-run registration. The server validates the
+// agent without registering it and reports its limits, such as TTL bounds.
+func (c *ConsulClient) ValidateAgent(agent Agent) (*DryRunResponse, error) {
+	jsonData, err := json.Marshal(agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal agent to JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/agents?dryRun=true", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.register)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var response DryRunResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &response, nil
+}
+
+// PatchAgent changes individual fields of a registered agent. changes maps
+// JSON field names (e.g. "description", "tags") to their new values; a nil
+// value removes the field. Fields not present in changes are left untouched.
+func (c *ConsulClient) PatchAgent(name string, changes map[string]interface{}) (*Agent, error) {
+	agent, _, err := c.PatchAgentIfMatch(name, changes, "")
+	return agent, err
+}
+
+// PatchAgentIfMatch changes individual fields of an agent only if its ETag
+// still matches etag, as returned by GetAgentWithETag or a previous patch. If
+// another writer changed the agent first, the server rejects the update with
+// 412 Precondition Failed; see IsPreconditionFailed. An empty etag makes the
+// update unconditional. The agent's new ETag is returned on success.
+func (c *ConsulClient) PatchAgentIfMatch(name string, changes map[string]interface{}, etag string) (*Agent, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("agent name cannot be empty")
+	}
+	if len(changes) == 0 {
+		return nil, "", fmt.Errorf("no changes supplied")
+	}
+
+	jsonData, err := json.Marshal(changes)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal changes to JSON: %w", err)
+	}
+
+	c.logDebug("Sending agent changes", "body", string(jsonData))
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/agents/%s", c.serverURL, url.PathEscape(name)), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+	if etag != "" {
+		req.Header.Add("If-Match", etag)
+	}
+
+	body, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, "", extractErrorFromResponse(statusCode, body)
+	}
+
+	var result AgentResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, "", fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return &result.Agent, header.Get("ETag"), nil
+}
+
+// PatchAgentJSON applies an RFC 6902 JSON Patch to a registered agent, which
+// can change single array elements or metadata entries that PatchAgent can
+// only replace whole. The operations apply in order and all or none take
+// effect. If a test operation does not hold, the server rejects the patch
+// with 409 Conflict.
+func (c *ConsulClient) PatchAgentJSON(name string, ops []PatchOp) (*Agent, error) {
+	if name == "" {
+		return nil, fmt.Errorf("agent name cannot be empty")
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("no patch operations supplied")
+	}
+
+	jsonData, err := json.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal patch to JSON: %w", err)
+	}
+
+	c.logDebug("Sending agent patch", "body", string(jsonData))
+
+	req, err := http.NewRequest("PATCH", fmt.Sprintf("%s/agents/%s", c.serverURL, url.PathEscape(name)), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json-patch+json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result AgentResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return &result.Agent, nil
+}
+
+// SetLifecycle moves an agent to the given lifecycle state
+func (c *ConsulClient) SetLifecycle(name, state string) error {
+	if !IsValidLifecycle(state) {
+		return fmt.Errorf("invalid lifecycle %q", state)
+	}
+	_, err := c.PatchAgent(name, map[string]interface{}{"lifecycle": state})
+	return err
+}
+
+// RenewAgent moves an agent's expiration to newExpiration, leaving the rest of
+// its definition and its health untouched. The server rejects expirations in
+// the past.
+func (c *ConsulClient) RenewAgent(name string, newExpiration time.Time) error {
+	if name == "" {
+		return fmt.Errorf("agent name cannot be empty")
+	}
+
+	query := url.Values{"expiration": {newExpiration.UTC().Format(time.RFC3339)}}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/agents/%s/renew?%s", c.serverURL, url.PathEscape(name), query.Encode()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	return nil
+}
+
+// AddTags adds tags to an agent, keeping its existing tags, and returns the
+// agent's resulting tags
+func (c *ConsulClient) AddTags(name string, tags ...string) ([]string, error) {
+	return c.updateTags(name, TagUpdate{Add: tags})
+}
+
+// RemoveTags removes tags from an agent, keeping its other tags, and returns
+// the agent's resulting tags. Removing a tag the agent does not carry is not
+// an error.
+func (c *ConsulClient) RemoveTags(name string, tags ...string) ([]string, error) {
+	return c.updateTags(name, TagUpdate{Remove: tags})
+}
+
+// updateTags sends a tag update for an agent
+func (c *ConsulClient) updateTags(name string, update TagUpdate) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("agent name cannot be empty")
+	}
+
+	jsonData, err := json.Marshal(update)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal tag update to JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/agents/%s/tags", c.serverURL, url.PathEscape(name)), bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result AgentTags
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return result.Tags, nil
+}
+
+// DeregisterAgent removes an agent from the registry. The instances of a
+// scaled agent are removed one at a time with DeregisterInstance.
+func (c *ConsulClient) DeregisterAgent(name string) error {
+	return c.deregisterAgent(name, "", nil)
+}
+
+// ForceDeregister removes an agent together with any Consul checks left
+// behind for it, cleaning up checks that outlived their service. It succeeds
+// when only orphaned checks remain. Requires an admin role.
+func (c *ConsulClient) ForceDeregister(name string) error {
+	return c.deregisterAgent(name, "", url.Values{"force": {"true"}})
+}
+
+// DeregisterInstance removes one instance of a scaled agent, leaving its
+// other instances registered
+func (c *ConsulClient) DeregisterInstance(name, instanceID string) error {
+	if instanceID == "" {
+		return fmt.Errorf("instance ID cannot be empty")
+	}
+	return c.deregisterAgent(name, instanceID, nil)
+}
+
+func (c *ConsulClient) deregisterAgent(name, instanceID string, query url.Values) error {
+	if name == "" {
+		return fmt.Errorf("agent name cannot be empty")
+	}
+
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/agents/%s%s", c.serverURL, url.PathEscape(name), instanceQuery(instanceID, query)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	return nil
+}
+
+// DeregisterAgentIfExists removes an agent from the registry if it is
+// registered. It reports whether the agent was removed; an agent that is
+// already gone is not an error, which makes it suitable for cleanup.
+func (c *ConsulClient) DeregisterAgentIfExists(name string) (bool, error) {
+	err := c.DeregisterAgent(name)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeregisterByOwner removes every agent owned by owner and returns the names
+// removed, as name or name@environment, with #instance appended for each
+// instance of a scaled agent. Requires an admin role.
+func (c *ConsulClient) DeregisterByOwner(owner string) ([]string, error) {
+	if owner == "" {
+		return nil, fmt.Errorf("owner cannot be empty")
+	}
+
+	query := url.Values{"owner": {owner}}
+	req, err := http.NewRequest("DELETE", c.serverURL+"/agents?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result DeregisterResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return result.Removed, nil
+}
+
+// UpdateAgentHealth reports the health of an agent's TTL check.
+// status must be HealthPassing, HealthWarning or HealthCritical.
+//
+// The status is sent as a JSON body, the form the server prefers, and also
+// as the status query parameter so that servers predating the body still
+// understand the request.
+func (c *ConsulClient) UpdateAgentHealth(name string, status HealthStatus) error {
+	return c.updateAgentHealth(name, "", status)
+}
+
+// UpdateInstanceHealth reports the health of one instance of a scaled agent.
+// Each instance has a TTL check of its own.
+func (c *ConsulClient) UpdateInstanceHealth(name, instanceID string, status HealthStatus) error {
+	if instanceID == "" {
+		return fmt.Errorf("instance ID cannot be empty")
+	}
+	return c.updateAgentHealth(name, instanceID, status)
+}
+
+func (c *ConsulClient) updateAgentHealth(name, instanceID string, status HealthStatus) error {
+	if name == "" {
+		return fmt.Errorf("agent name cannot be empty")
+	}
+	if !IsValidHealthStatus(status) {
+		return fmt.Errorf("invalid status %q: must be passing, warning or critical", status)
+	}
+
+	jsonData, err := json.Marshal(HealthReport{Status: status})
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+
+	reqURL := fmt.Sprintf("%s/agents/%s/health%s", c.serverURL, url.PathEscape(name), instanceQuery(instanceID, url.Values{"status": {string(status)}}))
+	req, err := http.NewRequest("PUT", reqURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	return nil
+}
+
+// SetReadOnly turns the registry's read-only maintenance mode on or off.
+// While it is on, changes to agents fail with 503. Requires an admin role.
+func (c *ConsulClient) SetReadOnly(enabled bool) error {
+	jsonData, err := json.Marshal(ReadOnlyMode{ReadOnly: enabled})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request to JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/admin/readonly", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	return nil
+}
+
+// GroupAgents retrieves the agents grouped by "tag", "owner" or "lifecycle",
+// keyed by tag, owner or lifecycle state. An agent with several tags is
+// listed under each; agents without tags or an owner are left out of those
+// groupings. Health is not reported.
+func (c *ConsulClient) GroupAgents(by string) (map[string][]Agent, error) {
+	if by == "" {
+		return nil, fmt.Errorf("grouping cannot be empty")
+	}
+
+	req, err := http.NewRequest("GET", c.serverURL+"/agents/grouped?"+url.Values{"by": {by}}.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.list)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var groups map[string][]Agent
+	if err := json.Unmarshal(body, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return groups, nil
+}
+
+// GetServerConfig retrieves the configuration the server is running with,
+// secrets redacted, to diagnose why a feature is on or off. Requires an
+// admin role.
+func (c *ConsulClient) GetServerConfig() (*EffectiveConfig, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/admin/config", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var config EffectiveConfig
+	if err := json.Unmarshal(body, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &config, nil
+}
+
+// Export retrieves every agent in the registry with all the fields needed to
+// recreate it through Import. Requires an admin role. For large registries
+// ExportTo streams the backup instead of holding it in memory.
+func (c *ConsulClient) Export() ([]Agent, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/export", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.bulk)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result AgentList
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return result.Agents, nil
+}
+
+// Import registers the given agents, returning the outcome for each one.
+// Agents that already exist are reported as conflicts unless overwrite is set.
+// Requires an admin role.
+func (c *ConsulClient) Import(agents []Agent, overwrite bool) ([]BatchResult, error) {
+	jsonData, err := json.Marshal(ImportRequest{Agents: agents, Overwrite: overwrite})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal agents to JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/import", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req, c.timeouts.bulk)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result BatchResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return result.Results, nil
+}
+
+// GetAgentHealth retrieves the status of an agent's TTL check together with
+// the check's output and notes
+func (c *ConsulClient) GetAgentHealth(name string) (*AgentHealth, error) {
+	return c.getAgentHealth(name, "")
+}
+
+// GetInstanceHealth retrieves the status of the TTL check of one instance of
+// a scaled agent. The health of every instance is also listed in the
+// agent's Instances.
+func (c *ConsulClient) GetInstanceHealth(name, instanceID string) (*AgentHealth, error) {
+	if instanceID == "" {
+		return nil, fmt.Errorf("instance ID cannot be empty")
+	}
+	return c.getAgentHealth(name, instanceID)
+}
+
+func (c *ConsulClient) getAgentHealth(name, instanceID string) (*AgentHealth, error) {
+	if name == "" {
+		return nil, fmt.Errorf("agent name cannot be empty")
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/agents/%s/health%s", c.serverURL, url.PathEscape(name), instanceQuery(instanceID, nil)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var health AgentHealth
+	if err := json.Unmarshal(body, &health); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return &health, nil
+}
+
+// AgentsHealthSummary retrieves the registry-wide health rollup: how many
+// agents are passing, warning and critical, and the percentage passing
+func (c *ConsulClient) AgentsHealthSummary() (HealthSummary, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/health/agents", nil)
+	if err != nil {
+		return HealthSummary{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return HealthSummary{}, err
+	}
+
+	if statusCode != http.StatusOK {
+		return HealthSummary{}, extractErrorFromResponse(statusCode, body)
+	}
+
+	var summary HealthSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return HealthSummary{}, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return summary, nil
+}
+
+// GetAgentDefaults retrieves the values the server applies to fields a
+// registration omits, such as the lifecycle and expiration
+func (c *ConsulClient) GetAgentDefaults() (*AgentDefaults, error) {
+	req, err := http.NewRequest("GET", c.serverURL+"/agents/defaults", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var defaults AgentDefaults
+	if err := json.Unmarshal(body, &defaults); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return &defaults, nil
+}
+
+// SetAgentIcon uploads a small image for an agent, replacing any previous
+// one. contentType must be image/png, image/jpeg or image/svg+xml, and the
+// image must fit the server's MAX_ICON_BYTES.
+func (c *ConsulClient) SetAgentIcon(name, contentType string, data []byte) error {
+	if name == "" {
+		return fmt.Errorf("agent name cannot be empty")
+	}
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("%s/agents/%s/icon", c.serverURL, url.PathEscape(name)), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Set("Content-Type", contentType)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	return nil
+}
+
+// GetAgentIcon retrieves the image uploaded for an agent and its content
+// type. Agents without an uploaded icon yield a 404 APIError; an icon linked
+// through IconURL is not fetched.
+func (c *ConsulClient) GetAgentIcon(name string) ([]byte, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("agent name cannot be empty")
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/agents/%s/icon", c.serverURL, url.PathEscape(name)), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, "", extractErrorFromResponse(statusCode, body)
+	}
+
+	return body, header.Get("Content-Type"), nil
+}
+
+// UpdateHealthBatch reports the health of several agents in one request. It
+// returns a result per update; an update that fails, e.g. for an unknown
+// agent, does not prevent the others from being applied.
+func (c *ConsulClient) UpdateHealthBatch(updates []HealthUpdate) ([]BatchResult, error) {
+	jsonData, err := json.Marshal(HealthBatchRequest{Updates: updates})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal health updates to JSON: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.serverURL+"/agents/health", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", c.apiKey)
+	req.Header.Add("Content-Type", "application/json")
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, extractErrorFromResponse(statusCode, body)
+	}
+
+	var result BatchResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return result.Results, nil
+}
+
+// doRequest performs an HTTP request and returns the response body and status code
+func (c *ConsulClient) doRequest(req *http.Request) ([]byte, int, error) {
+	body, statusCode, _, err := c.doRequestWithHeader(req)
+	return body, statusCode, err
+}
+
+// doRequestWithHeader performs an HTTP request and returns the response body,
+// status code and headers. A 429 or 503 response carrying Retry-After is
+// retried after the delay the server asks for, up to maxRetries times and
+// within the request's timeout, if the request is safe to send again (see
+// retryable); otherwise the response is returned as is.
+func (c *ConsulClient) doRequestWithHeader(req *http.Request) ([]byte, int, http.Header, error) {
+	c.logDebug("Sending request", "method", req.Method, "url", req.URL.String(), "headers", redactedHeaders(req.Header))
+
+	req, cancel := c.applyTimeout(req)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, resp.StatusCode, resp.Header, fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		c.logDebug("Server response", "method", req.Method, "url", req.URL.String(), "status", resp.StatusCode, "body", string(body))
+
+		if attempt < c.maxRetries && retryable(req, c.retryAnyMethod) {
+			if delay, ok := retryDelay(resp, time.Now()); ok && rewindBody(req) && waitToRetry(req.Context(), delay) {
+				c.logDebug("Retrying request", "method", req.Method, "url", req.URL.String(), "after", delay)
+				continue
+			}
+		}
+		return body, resp.StatusCode, resp.Header, nil
+	}
+}
+
+// instanceQuery returns the query string of a request, with the instance
+// parameter selecting one instance of a scaled agent when instanceID is set.
+// It is empty when there are no parameters.
+func instanceQuery(instanceID string, query url.Values) string {
+	if instanceID != "" {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("instance", instanceID)
+	}
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// extractErrorFromResponse parses error information from the response body
+func extractErrorFromResponse(statusCode int, body []byte) error {
+	// Try to parse as JSON error response
+	var errorResp ErrorResponse
+	if err := json.Unmarshal(body, &errorResp); err == nil && (errorResp.Error != "" || errorResp.Details != "") {
+		return &APIError{
+			StatusCode: statusCode,
+			Code:       errorResp.Code,
+			Message:    errorResp.Error,
+			Details:    errorResp.Details,
+			Fields:     errorResp.Fields,
+			Similar:    errorResp.Similar,
+		}
+	}
+	
+	// Fallback for non-standard error responses
+	return &APIError{
+		StatusCode: statusCode,
+		Details:    string(body),
 	}
-	
-	// Fallback for non-standard error responses
-	return fmt.Errorf("request failed with status %d: %s", statusCode, string(body))
 }
@@ -0,0 +1,146 @@
+package sharewoodapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestClient(serverURL string) *ConsulClient {
+	options := DefaultOptions()
+	options.ServerURL = serverURL
+	return NewClient(options)
+}
+
+func TestCloseStopsWatches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("index") != "0" {
+			// Block like a long poll with no changes until the client gives up
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set(IndexHeader, "1")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	events, errs := client.WatchAgents(context.Background(), 0)
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no snapshot delivered")
+	}
+
+	client.Close()
+
+	// Close waits for the watch to exit, so both channels are already closed
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("watch delivered an event after Close")
+		}
+	default:
+		t.Fatal("events channel still open after Close")
+	}
+	if _, ok := <-errs; ok {
+		t.Fatal("errors channel still open after Close")
+	}
+}
+
+func TestClientsDoNotShareTransport(t *testing.T) {
+	a := newTestClient("http://localhost")
+	b := newTestClient("http://localhost")
+	defer a.Close()
+	defer b.Close()
+
+	if a.client.Transport == http.DefaultTransport {
+		t.Fatal("client uses http.DefaultTransport, so Close would affect other users of it")
+	}
+	if a.client.Transport == b.client.Transport {
+		t.Fatal("clients share a transport, so closing one would affect the other")
+	}
+}
+
+func TestAgentNamesAreEscapedInPaths(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.EscapedPath()
+		w.Write([]byte(`{"agent":{"name":"geo agent?"}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	defer client.Close()
+
+	if _, err := client.GetAgent("geo agent?"); err != nil {
+		t.Fatalf("GetAgent failed: %v", err)
+	}
+	if want := "/api/v1/agents/geo%20agent%3F"; path != want {
+		t.Fatalf("requested %s, want %s", path, want)
+	}
+}
+
+func TestGetAgentIfExistsSkipsAgentsRemovedAfterTheList(t *testing.T) {
+	registered := map[string]bool{"weather": true, "news": true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/agents":
+			w.Write([]byte(`[{"name":"weather"},{"name":"news"}]`))
+			// news is deregistered right after the list is served
+			delete(registered, "news")
+		case "/api/v1/agents/weather":
+			w.Write([]byte(`{"agent":{"name":"weather"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"Agent not found","code":"agent_not_found"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	defer client.Close()
+
+	agents, err := client.ListAgents()
+	if err != nil {
+		t.Fatalf("ListAgents failed: %v", err)
+	}
+	var found []string
+	for _, listed := range agents {
+		agent, exists, err := client.GetAgentIfExists(listed.Name)
+		if err != nil {
+			t.Fatalf("GetAgentIfExists(%s) failed: %v", listed.Name, err)
+		}
+		if !exists {
+			if agent != nil {
+				t.Errorf("GetAgentIfExists(%s) returned %+v for a removed agent", listed.Name, agent)
+			}
+			continue
+		}
+		found = append(found, agent.Name)
+	}
+	if len(found) != 1 || found[0] != "weather" {
+		t.Fatalf("got %v, want only weather", found)
+	}
+}
+
+func TestGetAgentIfExistsReturnsOtherErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"Insufficient permissions"}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	defer client.Close()
+
+	agent, exists, err := client.GetAgentIfExists("weather")
+	if err == nil || IsNotFound(err) {
+		t.Fatalf("got error %v, want the 403", err)
+	}
+	if agent != nil || exists {
+		t.Errorf("got %+v, %v alongside the error", agent, exists)
+	}
+}
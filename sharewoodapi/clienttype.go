@@ -2,6 +2,7 @@ package sharewoodapi
  
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -16,17 +17,195 @@ type Agent struct {
 	Expiration  time.Time `json:"expiration"`
 	TTL         int64     `json:"ttl,omitempty"`
 	Tags        []string  `json:"tags,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Lifecycle   string    `json:"lifecycle,omitempty"`
+	// Contact is who to reach about the agent, e.g. an email address or
+	// chat channel; DocsURL points to its documentation
+	Contact string `json:"contact,omitempty"`
+	DocsURL string `json:"docsUrl,omitempty"`
+	// IconURL is an image registry UIs show for the agent. An icon can
+	// also be uploaded to the registry itself, see SetAgentIcon.
+	IconURL string `json:"iconUrl,omitempty"`
+	// Environment distinguishes variants of the same agent, e.g. dev or prod.
+	// An agent is unique per name and environment.
+	Environment string `json:"environment,omitempty"`
+	// BaseURLs lists every URL the agent is reachable at, primary first.
+	// BaseURL remains the primary; the rest are fallbacks.
+	BaseURLs []string `json:"baseurls,omitempty"`
+	// Aliases are alternate names the agent can be looked up by. Like names,
+	// they are unique across agents in an environment.
+	Aliases []string `json:"aliases,omitempty"`
+	// DependsOn names the registered agents this agent calls, in the same
+	// environment
+	DependsOn  []string `json:"dependsOn,omitempty"`
+	Deprecated bool     `json:"deprecated,omitempty"`
+	// HealthCheckURL is polled by Consul with an HTTP check: a 2xx response
+	// is passing, 429 warning and anything else critical. An agent has
+	// either a TTL check or an HTTP check unless AllowMultipleChecks is
+	// set; with both, its health is the worse of the two.
+	HealthCheckURL      string `json:"healthCheckUrl,omitempty"`
+	AllowMultipleChecks bool   `json:"allowMultipleChecks,omitempty"`
+	// Weight is the agent's relative share of selections and of Consul's
+	// load balancing while it is passing, from 1 to MaxAgentWeight. A
+	// warning agent counts as weight 1. Omitted, it is DefaultAgentWeight.
+	Weight int `json:"weight,omitempty"`
+	// Metadata holds free-form key/value pairs beyond the fixed fields.
+	// Keys may contain letters, digits, '-' and '_' and cannot be agent
+	// field names; agents can be listed by them with meta.<key>=<value>.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Set by the server: when the agent was first registered and last changed
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// Health is the agent's current Consul health (passing, warning or
+	// critical), reported by the server on reads and never stored
+	Health HealthStatus `json:"health,omitempty"`
+	// InstanceID registers the agent as one instance of a horizontally
+	// scaled agent. Instances share the name and environment; each has its
+	// own base URL and health. Reads report the instances together as one
+	// agent, listed in Instances, with InstanceID empty.
+	InstanceID string `json:"instanceId,omitempty"`
+	// Instances is set by the server on reads of a scaled agent
+	Instances []AgentInstance `json:"instances,omitempty"`
+	// ContentHash is set by the server to AgentContentHash of the agent.
+	// Agents with equal hashes are defined the same, whichever registry
+	// holds them.
+	ContentHash string `json:"contentHash,omitempty"`
+}
+
+// AgentInstance is one registered instance of a horizontally scaled agent
+type AgentInstance struct {
+	ID      string       `json:"id"`
+	BaseURL string       `json:"baseurl,omitempty"`
+	Health  HealthStatus `json:"health,omitempty"`
+}
+
+// Agent lifecycle states
+const (
+	LifecycleDraft      = "draft"
+	LifecycleActive     = "active"
+	LifecycleDeprecated = "deprecated"
+	LifecycleRetired    = "retired"
+)
+
+// Bounds of an agent's weight
+const (
+	DefaultAgentWeight = 1
+	MaxAgentWeight     = 1000
+)
+
+// Role is the role of an authenticated caller, granting access to endpoints
+type Role string
+
+// Roles known to the registry. Admins may call every endpoint.
+const (
+	RoleAdmin          Role = "admin"
+	RoleAgentPublisher Role = "agent-publisher"
+)
+
+// Identity is who the caller's credentials authenticate as. Method is how
+// they were authenticated: "api-key", "jwt", or "dev" when the server runs
+// with authentication bypassed.
+type Identity struct {
+	// UserID is the token's user_id claim; API keys carry no user
+	UserID string `json:"userId,omitempty"`
+	Role   Role   `json:"role"`
+	Method string `json:"method"`
+	// ExpiresAt is when a bearer token stops being accepted
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// HealthStatus is the Consul health of an agent
+type HealthStatus string
+
+// Health statuses. Agents may report passing, warning or critical;
+// maintenance is set by Consul operators only.
+const (
+	HealthPassing     HealthStatus = "passing"
+	HealthWarning     HealthStatus = "warning"
+	HealthCritical    HealthStatus = "critical"
+	HealthMaintenance HealthStatus = "maintenance"
+)
+
+// IsValidHealthStatus reports whether status may be reported for an agent's
+// TTL check
+func IsValidHealthStatus(status HealthStatus) bool {
+	switch status {
+	case HealthPassing, HealthWarning, HealthCritical:
+		return true
+	}
+	return false
+}
+
+// IsValidLifecycle reports whether state is one of the known lifecycle states
+func IsValidLifecycle(state string) bool {
+	switch state {
+	case LifecycleDraft, LifecycleActive, LifecycleDeprecated, LifecycleRetired:
+		return true
+	}
+	return false
 }
 
 // ErrorResponse represents the standard error response from the server
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details"`
+	// Code is the stable machine-readable code of the error, one of the
+	// Code constants; Error is meant for people and may change
+	Code    string            `json:"code"`
+	Error   string            `json:"error"`
+	Details string            `json:"details"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	// Similar lists existing agent names that differ from the requested
+	// name only by case or separators, sent with registration conflicts
+	Similar []string `json:"similar,omitempty"`
 }
 
 // AgentList represents a list of agents returned by the API
 type AgentList struct {
 	Agents []Agent `json:"agents"`
+	// Degraded is set when the server could only read part of the data, e.g.
+	// agent health during a Consul outage; Warnings says what is missing
+	Degraded bool     `json:"degraded,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// AgentPage is one page of a cursor-paginated agent listing. NextCursor
+// fetches the following page and is empty on the last one.
+type AgentPage struct {
+	Agents     []Agent `json:"agents"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+	// Degraded and Warnings are as for AgentList
+	Degraded bool     `json:"degraded,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// EnvelopeHeader is the response header set to "true" when the server has
+// wrapped the response in an Envelope
+const EnvelopeHeader = "X-Sharewood-Envelope"
+
+// Envelope is the uniform shape of successful responses requested with
+// ?envelope=true. Data holds what the endpoint returns without the envelope,
+// except that lists are always a plain array, with paging and degradation
+// details moved to Meta.
+type Envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta EnvelopeMeta    `json:"meta"`
+}
+
+// EnvelopeMeta describes an enveloped response. Degraded and Warnings are as
+// for AgentList and NextCursor as for AgentPage.
+type EnvelopeMeta struct {
+	Degraded   bool     `json:"degraded,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+// PatchOp is one RFC 6902 JSON Patch operation, as sent by PatchAgentJSON.
+// Op is one of add, remove, replace, move, copy or test; Path and From are
+// JSON Pointers into the agent, e.g. "/tags/0".
+type PatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value"`
 }
 
 // AgentResponse represents a single agent response
@@ -38,12 +217,273 @@ type AgentResponse struct {
 type AgentRegistrationResponse struct {
 	Agent   Agent  `json:"agent"`
 	Message string `json:"message,omitempty"`
+	// Warnings are non-fatal advisories about the registered agent, such as
+	// a missing expiration
+	Warnings []string `json:"warnings,omitempty"`
+	// Location is the URL of the registered agent, taken by the client from
+	// the response's Location header
+	Location string `json:"-"`
+}
+
+// TTLBounds describes the range of TTL values accepted by the server, in seconds
+type TTLBounds struct {
+	Min int64 `json:"min"`
+	Max int64 `json:"max"`
+}
+
+// DryRunResponse represents the server response to a dry-run registration
+type DryRunResponse struct {
+	Agent     Agent     `json:"agent"`
+	Message   string    `json:"message,omitempty"`
+	TTLBounds TTLBounds `json:"ttlBounds"`
+	// RequiredFields lists the agent fields this server requires
+	RequiredFields []string `json:"requiredFields"`
+	// Warnings are the advisories a real registration would report
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// AgentDefaults describes the values the server gives fields a registration
+// omits, as reported by the defaults endpoint
+type AgentDefaults struct {
+	// Lifecycle is the lifecycle state of an agent registered without one
+	Lifecycle string `json:"lifecycle"`
+	// ExpirationSeconds is how long after its registration an agent without
+	// an expiration expires. Zero means such agents never expire.
+	ExpirationSeconds int64 `json:"expirationSeconds"`
+	// Weight is the weight of an agent registered without one
+	Weight int `json:"weight"`
+}
+
+// ImportRequest is the body accepted by the import endpoint
+type ImportRequest struct {
+	Agents    []Agent `json:"agents"`
+	Overwrite bool    `json:"overwrite,omitempty"`
+}
+
+// BatchResult reports the outcome for one agent of a batch operation.
+// Status carries the HTTP status the equivalent single request would have returned.
+type BatchResult struct {
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Code is set on failure, like ErrorResponse.Code
+	Code string `json:"code,omitempty"`
+}
+
+// BatchResponse represents the server response to a batch operation
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// AgentHealth is the state of an agent's TTL check as held by Consul
+type AgentHealth struct {
+	Name string `json:"name"`
+	// InstanceID is set when the health is that of one instance of a scaled
+	// agent
+	InstanceID string       `json:"instanceId,omitempty"`
+	Status     HealthStatus `json:"status"`
+	// Output and Notes are the check's output and notes, which explain a
+	// warning or critical status
+	Output string `json:"output,omitempty"`
+	Notes  string `json:"notes,omitempty"`
+	// LastUpdated is when the serving registry instance last applied a
+	// health update for the agent; it is not known across restarts
+	LastUpdated *time.Time `json:"lastUpdated,omitempty"`
+}
+
+// HealthSummary rolls up the health of every agent in the registry.
+// PercentHealthy is the share of agents passing, from 0 to 100, and 100 when
+// there are no agents. Agents in maintenance count as critical.
+type HealthSummary struct {
+	Total          int     `json:"total"`
+	Passing        int     `json:"passing"`
+	Warning        int     `json:"warning"`
+	Critical       int     `json:"critical"`
+	PercentHealthy float64 `json:"percentHealthy"`
+}
+
+// HealthReport is the body of the single agent health endpoint
+type HealthReport struct {
+	Status HealthStatus `json:"status"`
+}
+
+// HealthUpdate is one agent's new health in a batch health request.
+// Environment selects the agent's environment variant, if any, and
+// InstanceID the instance of a scaled agent.
+type HealthUpdate struct {
+	Name        string       `json:"name"`
+	Environment string       `json:"environment,omitempty"`
+	InstanceID  string       `json:"instanceId,omitempty"`
+	Status      HealthStatus `json:"status"`
+}
+
+// HealthBatchRequest is the body of the batch health endpoint
+type HealthBatchRequest struct {
+	Updates []HealthUpdate `json:"updates"`
+}
+
+// GetAgentsRequest is the body of the batch get endpoint
+type GetAgentsRequest struct {
+	Names []string `json:"names"`
+}
+
+// GetAgentsResponse holds the agents found by a batch get and the requested
+// names that matched no agent
+type GetAgentsResponse struct {
+	Agents   []Agent  `json:"agents"`
+	NotFound []string `json:"notFound"`
+}
+
+// DeregisterResult lists the agents removed by a bulk deregistration, as
+// name or name@environment, with #instance appended for each instance of a
+// scaled agent
+type DeregisterResult struct {
+	Removed []string `json:"removed"`
+}
+
+// ReadOnlyMode is the body of the admin read-only endpoint, both to set the
+// mode and to report it
+type ReadOnlyMode struct {
+	ReadOnly bool `json:"readOnly"`
+}
+
+// ReindexResult reports the size of a rebuilt agent index
+type ReindexResult struct {
+	Agents  int `json:"agents"`
+	Entries int `json:"entries"`
+}
+
+// TagUpdate is the body of the tag update endpoint: tags to add to and
+// remove from an agent, leaving its other tags as they are
+type TagUpdate struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// AgentTags holds an agent's tags after a tag update
+type AgentTags struct {
+	Tags []string `json:"tags"`
+}
+
+// AllowedTags is the tag vocabulary of the registry. When Restricted is
+// false any tag is accepted and Tags is empty.
+type AllowedTags struct {
+	Restricted bool     `json:"restricted"`
+	Tags       []string `json:"tags"`
+}
+
+// VersionInfo reports the registry server's build and the version of the
+// Consul agent it is connected to
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	// ConsulVersion is empty when the server cannot reach Consul
+	ConsulVersion string `json:"consulVersion,omitempty"`
+}
+
+// EffectiveConfig is the configuration a registry server is running with,
+// as reported to admins for debugging. Secrets are never included.
+type EffectiveConfig struct {
+	Port            string `json:"port"`
+	TLS             bool   `json:"tls"`
+	ConsulAddress   string `json:"consulAddress"`
+	ConsulNamespace string `json:"consulNamespace,omitempty"`
+	DevMode         bool   `json:"devMode"`
+	// ReadOnly is the current read-only mode, which admins can change at
+	// runtime; Settings holds the mode the server started in
+	ReadOnly bool `json:"readOnly"`
+	// Secrets lists each secret setting as RedactedValue when it is set and
+	// empty when it is not
+	Secrets map[string]string `json:"secrets"`
+	// Settings holds the settings read from the environment at startup,
+	// keyed by name, with durations written like 30s
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// RedactedValue stands in for a secret in EffectiveConfig
+const RedactedValue = "[redacted]"
+
+// TagCount is a tag in use in the registry and the number of agents carrying it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// PingResponse reports whether an agent's base URL answered a probe from the
+// registry. The agent's response body is never included.
+type PingResponse struct {
+	Name      string `json:"name"`
+	Reachable bool   `json:"reachable"`
+	// URL is the base URL the result is for: the first reachable one, or
+	// the last tried if none answered
+	URL string `json:"url,omitempty"`
+	// StatusCode is the HTTP status the agent answered with, 0 if it did not answer
+	StatusCode int `json:"statusCode,omitempty"`
+	// LatencyMs is the round-trip time of the probe in milliseconds
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
 }
 
 // ClientOptions contains configuration options for the ConsulClient
 type ClientOptions struct {
+	// ServerURL is the scheme and host of the registry, e.g. http://localhost:3000
 	ServerURL string
-	APIKey    string
-	Timeout   time.Duration
-	Debug     bool
+	// BasePath is the prefix the registry API is mounted under, e.g. /api/v1.
+	// It must match the server's API_BASE_PATH.
+	BasePath string
+	APIKey   string
+	// Timeout bounds each request. The per-method defaults below override it
+	// for their methods when non-zero; a deadline on a request's context
+	// overrides both. Precedence: context deadline > per-method default >
+	// Timeout.
+	Timeout time.Duration
+	// ListTimeout applies to listing and searching agents and tags
+	ListTimeout time.Duration
+	// RegisterTimeout applies to registering and validating agents
+	RegisterTimeout time.Duration
+	// BulkTimeout applies to Import and Export
+	BulkTimeout time.Duration
+	// PingTimeout applies to PingAgent
+	PingTimeout time.Duration
+	// WatchTimeout applies to each blocking request made by WatchAgents
+	WatchTimeout time.Duration
+	// MaxRetries is how often a request answered with 429 or 503 and a
+	// Retry-After header is retried, each time after the delay the server
+	// asks for. Retries stay within the request's timeout. Zero uses
+	// DefaultMaxRetries; a negative value disables retries.
+	MaxRetries int
+	// RetryNonIdempotent also retries POST, PATCH and DELETE requests sent
+	// without If-Match. By default only GET, HEAD, OPTIONS and PUT requests
+	// and conditional ones are retried, since repeating a registration or a
+	// patch the server may have applied could apply it twice.
+	RetryNonIdempotent bool
+	// Debug logs requests and responses through the standard log package
+	// when no Logger is set
+	Debug bool
+	// Logger receives debug output for every request and response. When set,
+	// it is used regardless of Debug.
+	Logger Logger
+	// AllowAnyURLScheme skips the client-side http/https check on base URLs,
+	// for registries configured to accept internal schemes
+	AllowAnyURLScheme bool
+	// TokenKey verifies tokens in ParseToken: the HMAC secret as []byte, or
+	// an *rsa.PublicKey or *ecdsa.PublicKey
+	TokenKey interface{}
+}
+
+// IndexHeader is the response header carrying the registry index for
+// blocking list requests
+const IndexHeader = "X-Sharewood-Index"
+
+// DatacenterHeader is the response header naming the Consul datacenter that
+// answered a prepared query, which differs from the local one after failover
+const DatacenterHeader = "X-Sharewood-Datacenter"
+
+// AgentEvent is delivered by WatchAgents whenever the set of agents changes.
+// Index can be persisted and passed back to WatchAgents to resume watching
+// after a restart.
+type AgentEvent struct {
+	Agents []Agent
+	Index  uint64
 }
@@ -0,0 +1,73 @@
+package sharewoodapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// AgentContentHash returns a stable hash of the fields that define an agent,
+// for spotting duplicates and changes across registries. The registry reports
+// the same value as Agent.ContentHash.
+//
+// Fields the server maintains (timestamps, health, deprecated, instances and
+// the hash itself) are ignored, as is the instance ID of a scaled agent's
+// instance, and so are differences that carry no meaning: the order of
+// tags, aliases and dependencies, an omitted lifecycle or weight versus its
+// default, whether the primary base URL is repeated in BaseURLs, and the
+// time zone or sub-second part of the expiration.
+func AgentContentHash(a Agent) string {
+	a.CreatedAt = nil
+	a.UpdatedAt = nil
+	a.Health = ""
+	a.Deprecated = false
+	a.ContentHash = ""
+	a.InstanceID = ""
+	a.Instances = nil
+
+	if a.Lifecycle == "" {
+		a.Lifecycle = LifecycleActive
+	}
+	if a.Weight == 0 {
+		a.Weight = DefaultAgentWeight
+	}
+	a.Expiration = a.Expiration.UTC().Truncate(time.Second)
+	a.Tags = sortedStringSet(a.Tags)
+	a.Aliases = sortedStringSet(a.Aliases)
+	a.DependsOn = sortedStringSet(a.DependsOn)
+
+	// Report the primary base URL first among all of them, as the server does
+	if a.BaseURL != "" {
+		baseURLs := []string{a.BaseURL}
+		for _, u := range a.BaseURLs {
+			if u != a.BaseURL {
+				baseURLs = append(baseURLs, u)
+			}
+		}
+		a.BaseURLs = baseURLs
+	}
+
+	// Map keys are encoded sorted, so metadata needs no normalizing
+	data, _ := json.Marshal(a)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sortedStringSet returns the distinct entries of list, sorted
+func sortedStringSet(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(list))
+	set := make([]string, 0, len(list))
+	for _, entry := range list {
+		if !seen[entry] {
+			seen[entry] = true
+			set = append(set, entry)
+		}
+	}
+	sort.Strings(set)
+	return set
+}
@@ -0,0 +1,105 @@
+package sharewoodapi
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FieldDiff describes one agent field that differs between two definitions.
+// Field is the JSON field name. List fields such as tags are compared as sets
+// and report the entries added and removed; other fields report their old
+// and new values.
+type FieldDiff struct {
+	Field   string      `json:"field"`
+	Old     interface{} `json:"old,omitempty"`
+	New     interface{} `json:"new,omitempty"`
+	Added   []string    `json:"added,omitempty"`
+	Removed []string    `json:"removed,omitempty"`
+}
+
+// Fields maintained by the server, which never count as differences
+var serverManagedFields = map[string]bool{
+	"createdAt":   true,
+	"updatedAt":   true,
+	"health":      true,
+	"instances":   true,
+	"contentHash": true,
+}
+
+// DiffAgents returns the fields that differ between a and b, in the order
+// they are declared on Agent. Server-managed fields such as timestamps and
+// health are ignored, so an agent read from the server can be compared
+// directly with a desired definition.
+func DiffAgents(a, b Agent) []FieldDiff {
+	diffs := make([]FieldDiff, 0)
+
+	va := reflect.ValueOf(a)
+	vb := reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || serverManagedFields[name] {
+			continue
+		}
+
+		oldValue := va.Field(i).Interface()
+		newValue := vb.Field(i).Interface()
+
+		if oldList, ok := oldValue.([]string); ok {
+			added, removed := diffStringSets(oldList, newValue.([]string))
+			if len(added) > 0 || len(removed) > 0 {
+				diffs = append(diffs, FieldDiff{Field: name, Added: added, Removed: removed})
+			}
+			continue
+		}
+
+		// Times decoded from JSON and built locally differ in location, so
+		// compare the instant
+		if oldTime, ok := oldValue.(time.Time); ok {
+			if !oldTime.Equal(newValue.(time.Time)) {
+				diffs = append(diffs, FieldDiff{Field: name, Old: oldValue, New: newValue})
+			}
+			continue
+		}
+
+		// An empty map, as decoded from JSON, equals a missing one
+		if oldMap, ok := oldValue.(map[string]string); ok && len(oldMap) == 0 && len(newValue.(map[string]string)) == 0 {
+			continue
+		}
+
+		if !reflect.DeepEqual(oldValue, newValue) {
+			diffs = append(diffs, FieldDiff{Field: name, Old: oldValue, New: newValue})
+		}
+	}
+
+	return diffs
+}
+
+// diffStringSets returns the entries of b missing from a and the entries of
+// a missing from b, each sorted
+func diffStringSets(a, b []string) (added, removed []string) {
+	inA := make(map[string]bool, len(a))
+	for _, s := range a {
+		inA[s] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	for s := range inB {
+		if !inA[s] {
+			added = append(added, s)
+		}
+	}
+	for s := range inA {
+		if !inB[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
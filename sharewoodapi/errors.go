@@ -0,0 +1,167 @@
+package sharewoodapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// APIError is returned by the client when the server responds with an error
+// status. Use errors.As to inspect the status code:
+//
+//	var apiErr *APIError
+//	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+//		// handle missing agent
+//	}
+//
+// Code holds the stable machine-readable code of the error, one of the Code
+// constants, for branching without matching messages:
+//
+//	if errors.As(err, &apiErr) && apiErr.Code == CodeAgentConflict {
+//		// pick another name
+//	}
+type APIError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	Details    string
+	// Fields holds per-field validation messages, keyed by JSON field name
+	Fields map[string]string
+	// Similar holds near-duplicate agent names reported with a 409 conflict
+	Similar []string
+}
+
+// Error formats the error the same way earlier client versions did, so code
+// that only logs errors sees no change
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		// The server did not send a standard error response
+		return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Details)
+	}
+	if e.Details != "" {
+		return fmt.Sprintf("%s: %s (Status: %d)", e.Message, e.Details, e.StatusCode)
+	}
+	return fmt.Sprintf("%s (Status: %d)", e.Message, e.StatusCode)
+}
+
+// Error messages the server uses in ErrorResponse.Error for conditions a
+// client may want to tell apart
+const (
+	// BackendUnavailableError reports, with status 503, that the registry
+	// could not reach Consul
+	BackendUnavailableError = "registry backend unavailable"
+	// ReadOnlyError reports, with status 503, that the registry is in
+	// read-only maintenance mode
+	ReadOnlyError = "Registry is read-only"
+)
+
+// Error codes the server sets in ErrorResponse.Code. Unlike the messages
+// they never change, so clients can branch on them. Errors without a more
+// specific code carry the general code of their status, see
+// ErrorCodeForStatus.
+const (
+	// CodeAgentNotFound: no agent has the requested name (404)
+	CodeAgentNotFound = "AGENT_NOT_FOUND"
+	// CodeAgentConflict: the name or an alias is taken by another agent (409)
+	CodeAgentConflict = "AGENT_CONFLICT"
+	// CodeInstanceRequired: the agent is scaled and the request must name
+	// one of its instances (400)
+	CodeInstanceRequired = "INSTANCE_REQUIRED"
+	// CodePatchTestFailed: a JSON Patch test operation did not hold (409)
+	CodePatchTestFailed = "PATCH_TEST_FAILED"
+	// CodeNoHealthyAgent: no healthy agent matches a selection (404)
+	CodeNoHealthyAgent = "NO_HEALTHY_AGENT"
+	// CodeReadOnly: the registry is in read-only maintenance mode (503)
+	CodeReadOnly = "READ_ONLY"
+
+	// General codes, one per error status
+	CodeValidationFailed     = "VALIDATION_FAILED"      // 400
+	CodeUnauthenticated      = "UNAUTHENTICATED"        // 401
+	CodeForbidden            = "FORBIDDEN"              // 403
+	CodeNotFound             = "NOT_FOUND"              // 404
+	CodeConflict             = "CONFLICT"               // 409
+	CodePreconditionFailed   = "PRECONDITION_FAILED"    // 412
+	CodePayloadTooLarge      = "PAYLOAD_TOO_LARGE"      // 413
+	CodeUnsupportedMediaType = "UNSUPPORTED_MEDIA_TYPE" // 415
+	CodeHTTPSRequired        = "HTTPS_REQUIRED"         // 426
+	CodeInternal             = "INTERNAL_ERROR"         // 500 and other statuses
+	CodeBackendDenied        = "BACKEND_DENIED"         // 502
+	CodeBackendUnavailable   = "BACKEND_UNAVAILABLE"    // 503
+)
+
+// ErrorCodeForStatus returns the general error code of an HTTP status
+func ErrorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeValidationFailed
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusUpgradeRequired:
+		return CodeHTTPSRequired
+	case http.StatusBadGateway:
+		return CodeBackendDenied
+	case http.StatusServiceUnavailable:
+		return CodeBackendUnavailable
+	}
+	return CodeInternal
+}
+
+// ErrBackendUnavailable matches, with errors.Is, a 503 from the server
+// other than the read-only mode rejection: the registry could not reach its
+// Consul backend, or a proxy in front of it found no healthy registry. Such
+// requests are safe to retry later.
+var ErrBackendUnavailable = errors.New(BackendUnavailableError)
+
+// Is makes errors.Is(err, ErrBackendUnavailable) hold for backend outages
+func (e *APIError) Is(target error) bool {
+	return target == ErrBackendUnavailable && e.StatusCode == http.StatusServiceUnavailable && e.Message != ReadOnlyError
+}
+
+// IsNotFound reports whether err is the server reporting that the requested
+// agent does not exist
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsPreconditionFailed reports whether err is the server rejecting a
+// conditional update because the agent changed since its ETag was read
+func IsPreconditionFailed(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusPreconditionFailed
+}
+
+// HealthTimeoutError is returned by WaitForHealthy when the agent did not
+// report passing within the timeout
+type HealthTimeoutError struct {
+	Name    string
+	Timeout time.Duration
+	// LastStatus is the health last seen, empty if the agent could not be read
+	LastStatus HealthStatus
+	// LastErr is the error from the last failed read, if any
+	LastErr error
+}
+
+func (e *HealthTimeoutError) Error() string {
+	if e.LastErr != nil {
+		return fmt.Sprintf("agent '%s' did not become healthy within %s: %v", e.Name, e.Timeout, e.LastErr)
+	}
+	return fmt.Sprintf("agent '%s' did not become healthy within %s (last status: %s)", e.Name, e.Timeout, e.LastStatus)
+}
+
+func (e *HealthTimeoutError) Unwrap() error {
+	return e.LastErr
+}
@@ -0,0 +1,49 @@
+package sharewoodapi
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Interval between health polls in WaitForHealthy
+const healthPollInterval = time.Second
+
+// WaitForHealthy blocks until the agent reports passing health, polling the
+// registry once a second. It returns immediately if the agent is already
+// passing. If timeout elapses first, it returns a *HealthTimeoutError; if ctx
+// is cancelled, it returns the context's error. Agents that are not yet
+// registered are waited for as well.
+func (c *ConsulClient) WaitForHealthy(ctx context.Context, name string, timeout time.Duration) error {
+	if name == "" {
+		return fmt.Errorf("agent name cannot be empty")
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	timeoutErr := &HealthTimeoutError{Name: name, Timeout: timeout}
+	for {
+		agent, err := c.GetAgent(name)
+		if err == nil && agent.Health == HealthPassing {
+			return nil
+		}
+		if err == nil {
+			timeoutErr.LastStatus = agent.Health
+		}
+		timeoutErr.LastErr = err
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return timeoutErr
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
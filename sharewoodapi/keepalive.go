@@ -0,0 +1,106 @@
+package sharewoodapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// KeepAlive keeps an agent's TTL check passing by reporting "passing" for it
+// immediately and then once every interval. Choose an interval comfortably
+// shorter than the agent's TTL.
+//
+// Failed updates are reported on the returned channel without stopping the
+// loop; if nobody is reading, errors are dropped rather than blocking. The
+// channel is closed once ctx is cancelled or the client is closed, after the
+// background goroutine has exited.
+func (c *ConsulClient) KeepAlive(ctx context.Context, name string, interval time.Duration) (<-chan error, error) {
+	if name == "" {
+		return nil, fmt.Errorf("agent name cannot be empty")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("keep-alive interval must be positive")
+	}
+
+	errs := make(chan error, 1)
+
+	ctx, cancel := c.backgroundContext(ctx)
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		defer cancel()
+		defer close(errs)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := c.UpdateAgentHealth(name, HealthPassing); err != nil && ctx.Err() == nil {
+				select {
+				case errs <- fmt.Errorf("keep-alive for agent '%s' failed: %w", name, err):
+				default:
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return errs, nil
+}
+
+// RegisterAndKeepAlive registers agent and keeps its TTL check passing every
+// interval, bundling the lifecycle of an agent process into one call. The
+// returned stop function ends the keep-alive and deregisters the agent; it is
+// safe to call more than once. Cancelling ctx ends the keep-alive without
+// deregistering. Keep-alive failures are logged through the client's logger.
+//
+// Only agents in the default environment and registered without an
+// instance ID are supported, as KeepAlive and DeregisterAgent address agents
+// by name alone.
+func (c *ConsulClient) RegisterAndKeepAlive(ctx context.Context, agent Agent, interval time.Duration) (*Agent, func(), error) {
+	if agent.Environment != "" || agent.InstanceID != "" {
+		return nil, nil, fmt.Errorf("agents with an environment or instance ID cannot be kept alive by name")
+	}
+	if interval <= 0 {
+		return nil, nil, fmt.Errorf("keep-alive interval must be positive")
+	}
+
+	registered, err := c.RegisterAgent(agent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	errs, err := c.KeepAlive(ctx, registered.Name, interval)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for err := range errs {
+			c.logDebug("Keep-alive failed", "agent", registered.Name, "error", err)
+		}
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			<-done
+			if err := c.DeregisterAgent(registered.Name); err != nil {
+				c.logDebug("Deregistration failed", "agent", registered.Name, "error", err)
+			}
+		})
+	}
+
+	return registered, stop, nil
+}
@@ -0,0 +1,62 @@
+package sharewoodapi
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Logger receives the client's debug output as a message followed by
+// alternating keys and values. *slog.Logger satisfies this interface, so
+// callers can route client logs into their own structured logging.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+}
+
+// stdLogger writes debug output through the standard log package, as the
+// client did before Logger existed
+type stdLogger struct{}
+
+func (stdLogger) Debug(msg string, keysAndValues ...interface{}) {
+	var b strings.Builder
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	log.Printf("DEBUG - %s%s", msg, b.String())
+}
+
+// logDebug sends a debug message to the configured logger, if any
+func (c *ConsulClient) logDebug(msg string, keysAndValues ...interface{}) {
+	if c.logger != nil {
+		c.logger.Debug(msg, keysAndValues...)
+	}
+}
+
+// RedactCredential masks a secret such as an API key or bearer token for
+// logging, keeping only its last four characters when it is long enough for
+// that to reveal nothing useful, e.g. "****1234"
+func RedactCredential(secret string) string {
+	if len(secret) < 12 {
+		return "****"
+	}
+	return "****" + secret[len(secret)-4:]
+}
+
+// redactedHeaders returns a copy of h suitable for logging, with the API key
+// and bearer token masked
+func redactedHeaders(h http.Header) http.Header {
+	redacted := h.Clone()
+	if key := redacted.Get("X-API-Key"); key != "" {
+		redacted.Set("X-API-Key", RedactCredential(key))
+	}
+	if auth := redacted.Get("Authorization"); auth != "" {
+		scheme, credential, found := strings.Cut(auth, " ")
+		if found {
+			redacted.Set("Authorization", scheme+" "+RedactCredential(credential))
+		} else {
+			redacted.Set("Authorization", RedactCredential(auth))
+		}
+	}
+	return redacted
+}
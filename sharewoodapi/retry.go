@@ -0,0 +1,84 @@
+package sharewoodapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultMaxRetries is how often a request is retried after a Retry-After
+// hint when ClientOptions.MaxRetries is zero
+const DefaultMaxRetries = 2
+
+// retryable reports whether req may be sent again: idempotent methods can
+// be, as can requests made conditional with If-Match, which the server
+// rejects with 412 if an earlier attempt already applied them. Other
+// methods are only retried when anyMethod is set.
+func retryable(req *http.Request, anyMethod bool) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut:
+		return true
+	}
+	return anyMethod || req.Header.Get("If-Match") != ""
+}
+
+// retryDelay returns the delay a 429 or 503 response asks for in its
+// Retry-After header, given as seconds or as an HTTP date. ok is false for
+// other responses and for a missing or malformed header.
+func retryDelay(resp *http.Response, now time.Time) (delay time.Duration, ok bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay = at.Sub(now); delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// rewindBody prepares req to be sent again, reporting false if its body
+// cannot be replayed
+func rewindBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	req.Body = body
+	return true
+}
+
+// waitToRetry sleeps for delay before a retry. It returns false, without
+// waiting out the delay, when ctx ends first: a request is never retried
+// past its deadline.
+func waitToRetry(ctx context.Context, delay time.Duration) bool {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,135 @@
+package sharewoodapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// retryServer answers the first request with status and Retry-After, and
+// every later one with an empty agent list, recording when each arrived
+type retryServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []time.Time
+}
+
+func newRetryServer(status int, retryAfter string) *retryServer {
+	s := &retryServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, time.Now())
+		first := len(s.requests) == 1
+		s.mu.Unlock()
+
+		if first {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(status)
+			w.Write([]byte(`{"error":"Slow down"}`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	}))
+	return s
+}
+
+func (s *retryServer) requestTimes() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.requests...)
+}
+
+func TestRetryAfterWaitsBeforeRetrying(t *testing.T) {
+	server := newRetryServer(http.StatusTooManyRequests, "2")
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	defer client.Close()
+
+	if _, err := client.ListAgents(); err != nil {
+		t.Fatalf("ListAgents failed after retry: %v", err)
+	}
+
+	requests := server.requestTimes()
+	if len(requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(requests))
+	}
+	if waited := requests[1].Sub(requests[0]); waited < 1900*time.Millisecond {
+		t.Fatalf("retried after %s, want about 2s", waited)
+	}
+}
+
+func TestRetryAfterBeyondTimeoutIsNotWaitedFor(t *testing.T) {
+	server := newRetryServer(http.StatusServiceUnavailable, "30")
+	defer server.Close()
+
+	options := DefaultOptions()
+	options.ServerURL = server.URL
+	options.Timeout = time.Second
+	client := NewClient(options)
+	defer client.Close()
+
+	start := time.Now()
+	if _, err := client.ListAgents(); err == nil {
+		t.Fatal("ListAgents succeeded, want the 503")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("ListAgents took %s, want it to give up without waiting", elapsed)
+	}
+	if n := len(server.requestTimes()); n != 1 {
+		t.Fatalf("got %d requests, want 1", n)
+	}
+}
+
+func TestNonIdempotentRequestsAreNotRetried(t *testing.T) {
+	for _, retryAny := range []bool{false, true} {
+		server := newRetryServer(http.StatusServiceUnavailable, "0")
+
+		options := DefaultOptions()
+		options.ServerURL = server.URL
+		options.RetryNonIdempotent = retryAny
+		client := NewClient(options)
+
+		client.DeregisterAgent("geo")
+		want := 1
+		if retryAny {
+			want = 2
+		}
+		if n := len(server.requestTimes()); n != want {
+			t.Errorf("RetryNonIdempotent=%v: got %d requests, want %d", retryAny, n, want)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	now := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		status     int
+		retryAfter string
+		delay      time.Duration
+		ok         bool
+	}{
+		{http.StatusTooManyRequests, "2", 2 * time.Second, true},
+		{http.StatusServiceUnavailable, now.Add(3 * time.Second).Format(http.TimeFormat), 3 * time.Second, true},
+		{http.StatusServiceUnavailable, now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{http.StatusTooManyRequests, "-1", 0, false},
+		{http.StatusTooManyRequests, "soon", 0, false},
+		{http.StatusTooManyRequests, "", 0, false},
+		{http.StatusInternalServerError, "2", 0, false},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+		if test.retryAfter != "" {
+			resp.Header.Set("Retry-After", test.retryAfter)
+		}
+		delay, ok := retryDelay(resp, now)
+		if delay != test.delay || ok != test.ok {
+			t.Errorf("status %d, Retry-After %q: got %s, %v; want %s, %v", test.status, test.retryAfter, delay, ok, test.delay, test.ok)
+		}
+	}
+}
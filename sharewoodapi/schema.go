@@ -0,0 +1,9 @@
+package sharewoodapi
+
+import _ "embed"
+
+// AgentSchema is the JSON Schema (draft 2020-12) describing an Agent payload.
+// The server validates registrations and updates against it.
+//
+//go:embed agent.schema.json
+var AgentSchema []byte
@@ -0,0 +1,179 @@
+package sharewoodapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as found in an agent's release, e.g. 1.2.0
+// or v2.0.0-rc.1. Build metadata is accepted but ignored when comparing.
+type Version struct {
+	Major, Minor, Patch int
+	Prerelease          []string
+}
+
+// ParseVersion parses a semantic version of the form MAJOR.MINOR.PATCH with
+// an optional leading "v", prerelease and build metadata
+func ParseVersion(s string) (Version, error) {
+	raw := s
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+
+	var v Version
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		pre := s[i+1:]
+		s = s[:i]
+		if pre == "" {
+			return Version{}, fmt.Errorf("%q is not a semantic version", raw)
+		}
+		v.Prerelease = strings.Split(pre, ".")
+		for _, id := range v.Prerelease {
+			if id == "" {
+				return Version{}, fmt.Errorf("%q is not a semantic version", raw)
+			}
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("%q is not a semantic version", raw)
+	}
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part[0] == '+' {
+			return Version{}, fmt.Errorf("%q is not a semantic version", raw)
+		}
+		numbers[i] = n
+	}
+	v.Major, v.Minor, v.Patch = numbers[0], numbers[1], numbers[2]
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 as v is lower than, equal to or higher than
+// other, following semver precedence: a prerelease sorts before its release
+func (v Version) Compare(other Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	switch {
+	case len(v.Prerelease) == 0 && len(other.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(other.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(other.Prerelease); i++ {
+		if c := comparePrerelease(v.Prerelease[i], other.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return sign(len(v.Prerelease) - len(other.Prerelease))
+}
+
+// comparePrerelease compares two prerelease identifiers: numeric ones
+// numerically and below alphanumeric ones, which compare lexically
+func comparePrerelease(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return sign(na - nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// versionComparison is one term of a VersionConstraint, e.g. >=1.2.0
+type versionComparison struct {
+	op      string
+	version Version
+}
+
+// VersionConstraint is a semver range of space-separated comparisons, all of
+// which must hold, e.g. ">=1.2.0 <2.0.0". The operators are =, !=, >, >=, <
+// and <=; a bare version means =.
+type VersionConstraint struct {
+	terms []versionComparison
+}
+
+// ParseVersionConstraint parses a range such as ">=1.2.0 <2.0.0"
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return VersionConstraint{}, fmt.Errorf("version constraint cannot be empty")
+	}
+
+	var constraint VersionConstraint
+	for _, field := range fields {
+		op := ""
+		for _, candidate := range []string{">=", "<=", "!=", ">", "<", "="} {
+			if strings.HasPrefix(field, candidate) {
+				op = candidate
+				break
+			}
+		}
+		version, err := ParseVersion(field[len(op):])
+		if err != nil {
+			return VersionConstraint{}, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+		if op == "" {
+			op = "="
+		}
+		constraint.terms = append(constraint.terms, versionComparison{op: op, version: version})
+	}
+	return constraint, nil
+}
+
+// Check reports whether v satisfies every comparison of the constraint
+func (vc VersionConstraint) Check(v Version) bool {
+	for _, term := range vc.terms {
+		c := v.Compare(term.version)
+		var ok bool
+		switch term.op {
+		case "=":
+			ok = c == 0
+		case "!=":
+			ok = c != 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ReleaseSatisfies reports whether agent's release parses as a semantic
+// version satisfying the constraint. Agents without a semver release never do.
+func ReleaseSatisfies(agent Agent, constraint VersionConstraint) bool {
+	v, err := ParseVersion(agent.Release)
+	return err == nil && constraint.Check(v)
+}
@@ -0,0 +1,492 @@
+// Package sharewoodapitest provides an in-memory registry server for testing
+// code that uses sharewoodapi.ConsulClient, without Consul or a running
+// registry.
+//
+//	srv := sharewoodapitest.NewServer(sharewoodapi.Agent{Name: "geo"})
+//	defer srv.Close()
+//	agents, err := srv.Client.ListAgents()
+//
+// The server implements the agent endpoints: list, get, register (including
+// dry runs), patch, deregister, renew, health and defaults. Agents are kept in a map
+// keyed by name and environment. It does not authenticate requests or apply
+// the real registry's full validation.
+package sharewoodapitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rdhillbb/sharewood/sharewoodapi"
+)
+
+// BasePath is the prefix the test server mounts the API under
+const BasePath = "/api/v1"
+
+// DefaultExpiration is the expiration the test server gives agents
+// registered without one, counted from the registration
+const DefaultExpiration = 365 * 24 * time.Hour
+
+// Operation names an endpoint of the test server, for simulating failures
+type Operation string
+
+// Operations that can be made to fail with Fail
+const (
+	OpList         Operation = "list"
+	OpGet          Operation = "get"
+	OpRegister     Operation = "register"
+	OpPatch        Operation = "patch"
+	OpDeregister   Operation = "deregister"
+	OpRenew        Operation = "renew"
+	OpGetHealth    Operation = "getHealth"
+	OpUpdateHealth Operation = "updateHealth"
+	OpGetDefaults  Operation = "getDefaults"
+)
+
+// Server is an in-memory registry listening on a local httptest.Server.
+// Client is configured to talk to it.
+type Server struct {
+	*httptest.Server
+	Client *sharewoodapi.ConsulClient
+
+	mu       sync.Mutex
+	agents   map[string]sharewoodapi.Agent
+	failures map[Operation]int
+}
+
+// NewServer starts a test server seeded with agents and returns it with a
+// client pointed at it. The caller must call Close when done.
+func NewServer(agents ...sharewoodapi.Agent) *Server {
+	s := &Server{
+		agents:   make(map[string]sharewoodapi.Agent),
+		failures: make(map[Operation]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+BasePath+"/agents", s.handle(OpList, s.listAgents))
+	mux.HandleFunc("POST "+BasePath+"/agents", s.handle(OpRegister, s.registerAgent))
+	mux.HandleFunc("GET "+BasePath+"/agents/defaults", s.handle(OpGetDefaults, s.getDefaults))
+	mux.HandleFunc("GET "+BasePath+"/agents/{name}", s.handle(OpGet, s.getAgent))
+	mux.HandleFunc("PATCH "+BasePath+"/agents/{name}", s.handle(OpPatch, s.patchAgent))
+	mux.HandleFunc("DELETE "+BasePath+"/agents/{name}", s.handle(OpDeregister, s.deregisterAgent))
+	mux.HandleFunc("POST "+BasePath+"/agents/{name}/renew", s.handle(OpRenew, s.renewAgent))
+	mux.HandleFunc("GET "+BasePath+"/agents/{name}/health", s.handle(OpGetHealth, s.getHealth))
+	mux.HandleFunc("PUT "+BasePath+"/agents/{name}/health", s.handle(OpUpdateHealth, s.updateHealth))
+	s.Server = httptest.NewServer(mux)
+
+	options := sharewoodapi.DefaultOptions()
+	options.ServerURL = s.URL
+	options.BasePath = BasePath
+	s.Client = sharewoodapi.NewClient(options)
+
+	s.Seed(agents...)
+	return s
+}
+
+// Close stops the client and the server
+func (s *Server) Close() {
+	s.Client.Close()
+	s.Server.Close()
+}
+
+// Seed stores agents as if they had been registered, replacing any with the
+// same name and environment. Agents without a lifecycle or health are active
+// and passing.
+func (s *Server) Seed(agents ...sharewoodapi.Agent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, agent := range agents {
+		s.store(agent)
+	}
+}
+
+// Agents returns every stored agent, ordered by name and environment
+func (s *Server) Agents() []sharewoodapi.Agent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedAgents()
+}
+
+// Agent returns the stored agent with the given name in the default
+// environment
+func (s *Server) Agent(name string) (sharewoodapi.Agent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.agents[agentKey(name, "")]
+	return agent, ok
+}
+
+// Fail makes every request to op answer with status and an ErrorResponse
+// until Fail is called again for op with status 0
+func (s *Server) Fail(op Operation, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status == 0 {
+		delete(s.failures, op)
+		return
+	}
+	s.failures[op] = status
+}
+
+// handle wraps an endpoint with the simulated failure for op, if any
+func (s *Server) handle(op Operation, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		status, failing := s.failures[op]
+		s.mu.Unlock()
+		if failing {
+			writeError(w, status, "Simulated failure", fmt.Sprintf("%s is configured to fail", op))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) listAgents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	all := s.sortedAgents()
+	s.mu.Unlock()
+
+	agents := make([]sharewoodapi.Agent, 0, len(all))
+	for _, agent := range all {
+		if owner := query.Get("owner"); owner != "" && agent.Owner != owner {
+			continue
+		}
+		if lifecycle := query.Get("lifecycle"); lifecycle != "" && agent.Lifecycle != lifecycle {
+			continue
+		}
+		if env := query.Get("env"); env != "" && agent.Environment != env {
+			continue
+		}
+		if tag := query.Get("tag"); tag != "" && !hasTag(agent, tag) {
+			continue
+		}
+		if !matchesMetadata(agent, query) {
+			continue
+		}
+		agents = append(agents, agent)
+	}
+
+	writeJSON(w, http.StatusOK, agents)
+}
+
+func (s *Server) registerAgent(w http.ResponseWriter, r *http.Request) {
+	var agent sharewoodapi.Agent
+	if err := json.NewDecoder(r.Body).Decode(&agent); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	if agent.Name == "" {
+		writeError(w, http.StatusBadRequest, "Missing required fields", "name is required")
+		return
+	}
+
+	if r.URL.Query().Get("dryRun") == "true" {
+		writeJSON(w, http.StatusOK, sharewoodapi.DryRunResponse{
+			Agent:          agent,
+			Message:        "Agent is valid",
+			RequiredFields: []string{"name"},
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := agentKey(agent.Name, agent.Environment)
+	if _, exists := s.agents[key]; exists {
+		writeError(w, http.StatusConflict, "Agent already exists", fmt.Sprintf("An agent with the name '%s' already exists", agent.Name))
+		return
+	}
+	agent.CreatedAt = nil
+	if agent.Expiration.IsZero() {
+		agent.Expiration = time.Now().UTC().Truncate(time.Second).Add(DefaultExpiration)
+	}
+	agent = s.store(agent)
+
+	location := BasePath + "/agents/" + url.PathEscape(agent.Name)
+	if agent.Environment != "" {
+		location += "?env=" + url.QueryEscape(agent.Environment)
+	}
+	w.Header().Set("Location", location)
+	writeJSON(w, http.StatusCreated, sharewoodapi.AgentRegistrationResponse{
+		Agent:   agent,
+		Message: "Agent registered successfully",
+	})
+}
+
+func (s *Server) getAgent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	agent, ok := s.lookup(r)
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sharewoodapi.AgentResponse{Agent: agent})
+}
+
+func (s *Server) patchAgent(w http.ResponseWriter, r *http.Request) {
+	var changes map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+		return
+	}
+	if _, ok := changes["name"]; ok {
+		writeError(w, http.StatusBadRequest, "Invalid change", "The agent name cannot be changed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.lookup(r)
+	if !ok {
+		writeNotFound(w, r)
+		return
+	}
+
+	// Merge the changes into the agent's JSON form; null removes a field,
+	// and metadata entries are merged one by one
+	var fields map[string]interface{}
+	data, _ := json.Marshal(agent)
+	json.Unmarshal(data, &fields)
+	for key, value := range changes {
+		if entries, ok := value.(map[string]interface{}); ok && key == "metadata" {
+			merged, _ := fields[key].(map[string]interface{})
+			if merged == nil {
+				merged = make(map[string]interface{})
+			}
+			for entry, entryValue := range entries {
+				if entryValue == nil {
+					delete(merged, entry)
+				} else {
+					merged[entry] = entryValue
+				}
+			}
+			fields[key] = merged
+			continue
+		}
+		if value == nil {
+			delete(fields, key)
+		} else {
+			fields[key] = value
+		}
+	}
+	data, _ = json.Marshal(fields)
+	var updated sharewoodapi.Agent
+	if err := json.Unmarshal(data, &updated); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid change", err.Error())
+		return
+	}
+	updated.CreatedAt = agent.CreatedAt
+	updated = s.store(updated)
+
+	writeJSON(w, http.StatusOK, sharewoodapi.AgentResponse{Agent: updated})
+}
+
+func (s *Server) deregisterAgent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.lookup(r)
+	if !ok {
+		writeNotFound(w, r)
+		return
+	}
+	delete(s.agents, agentKey(agent.Name, agent.Environment))
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Agent unregistered successfully"})
+}
+
+func (s *Server) renewAgent(w http.ResponseWriter, r *http.Request) {
+	expiration, err := time.Parse(time.RFC3339, r.URL.Query().Get("expiration"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid expiration", "expiration must be an RFC3339 timestamp")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.lookup(r)
+	if !ok {
+		writeNotFound(w, r)
+		return
+	}
+	agent.Expiration = expiration
+	agent = s.store(agent)
+
+	writeJSON(w, http.StatusOK, sharewoodapi.AgentResponse{Agent: agent})
+}
+
+func (s *Server) getHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	agent, ok := s.lookup(r)
+	s.mu.Unlock()
+	if !ok {
+		writeNotFound(w, r)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sharewoodapi.AgentHealth{
+		Name:   agent.Name,
+		Status: agent.Health,
+	})
+}
+
+func (s *Server) updateHealth(w http.ResponseWriter, r *http.Request) {
+	// The status may come in a JSON body or the query string
+	status := sharewoodapi.HealthStatus(r.URL.Query().Get("status"))
+	if r.ContentLength != 0 {
+		var report sharewoodapi.HealthReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body", err.Error())
+			return
+		}
+		if report.Status != "" {
+			status = report.Status
+		}
+	}
+	if status == "" {
+		writeError(w, http.StatusBadRequest, "Missing status", "status parameter is required, in the JSON body or the query string")
+		return
+	}
+	if !sharewoodapi.IsValidHealthStatus(status) {
+		writeError(w, http.StatusBadRequest, "Invalid status. Must be 'passing', 'warning', or 'critical'", "")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	agent, ok := s.lookup(r)
+	if !ok {
+		writeNotFound(w, r)
+		return
+	}
+	agent.Health = status
+	s.agents[agentKey(agent.Name, agent.Environment)] = agent
+
+	writeJSON(w, http.StatusOK, map[string]string{"message": "Agent health updated successfully"})
+}
+
+func (s *Server) getDefaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, sharewoodapi.AgentDefaults{
+		Lifecycle:         sharewoodapi.LifecycleActive,
+		ExpirationSeconds: int64(DefaultExpiration / time.Second),
+		Weight:            sharewoodapi.DefaultAgentWeight,
+	})
+}
+
+// store saves agent with server-side defaults and timestamps applied and
+// returns the stored agent. The caller must hold s.mu.
+func (s *Server) store(agent sharewoodapi.Agent) sharewoodapi.Agent {
+	now := time.Now().UTC().Truncate(time.Second)
+	if agent.CreatedAt == nil {
+		agent.CreatedAt = &now
+	}
+	agent.UpdatedAt = &now
+	if agent.Lifecycle == "" {
+		agent.Lifecycle = sharewoodapi.LifecycleActive
+	}
+	agent.Deprecated = agent.Lifecycle == sharewoodapi.LifecycleDeprecated
+	if agent.Weight == 0 {
+		agent.Weight = sharewoodapi.DefaultAgentWeight
+	}
+	if agent.Health == "" {
+		agent.Health = sharewoodapi.HealthPassing
+	}
+	agent.ContentHash = sharewoodapi.AgentContentHash(agent)
+	s.agents[agentKey(agent.Name, agent.Environment)] = agent
+	return agent
+}
+
+// lookup finds the agent named in the request path, in the environment given
+// by the env query parameter. The caller must hold s.mu.
+func (s *Server) lookup(r *http.Request) (sharewoodapi.Agent, bool) {
+	agent, ok := s.agents[agentKey(r.PathValue("name"), r.URL.Query().Get("env"))]
+	return agent, ok
+}
+
+// sortedAgents returns the stored agents ordered by name and environment.
+// The caller must hold s.mu.
+func (s *Server) sortedAgents() []sharewoodapi.Agent {
+	agents := make([]sharewoodapi.Agent, 0, len(s.agents))
+	for _, agent := range s.agents {
+		agents = append(agents, agent)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		if agents[i].Name != agents[j].Name {
+			return agents[i].Name < agents[j].Name
+		}
+		return agents[i].Environment < agents[j].Environment
+	})
+	return agents
+}
+
+// agentKey identifies an agent by name and environment, as the registry does
+func agentKey(name, environment string) string {
+	if environment == "" {
+		return name
+	}
+	return name + "@" + environment
+}
+
+// hasTag reports whether the agent carries the given tag
+func hasTag(agent sharewoodapi.Agent, tag string) bool {
+	for _, t := range agent.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMetadata applies the meta.<key>=<value> list filters
+func matchesMetadata(agent sharewoodapi.Agent, query url.Values) bool {
+	for param, values := range query {
+		key, ok := strings.CutPrefix(param, "meta.")
+		if !ok {
+			continue
+		}
+		if actual, ok := agent.Metadata[key]; !ok || actual != values[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, "Agent not found", fmt.Sprintf("No agent with the name '%s' was found", r.PathValue("name")))
+}
+
+func writeError(w http.ResponseWriter, status int, message, details string) {
+	writeJSON(w, status, sharewoodapi.ErrorResponse{Code: errorCode(status, message), Error: message, Details: details})
+}
+
+// errorCode returns the code the registry sends with an error
+func errorCode(status int, message string) string {
+	switch message {
+	case "Agent not found":
+		return sharewoodapi.CodeAgentNotFound
+	case "Agent already exists":
+		return sharewoodapi.CodeAgentConflict
+	}
+	return sharewoodapi.ErrorCodeForStatus(status)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,165 @@
+package sharewoodapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// StreamAgents retrieves all agents as a newline-delimited JSON stream,
+// delivering each agent as soon as it is decoded rather than waiting for the
+// full list. The agent channel is closed when the stream ends; at most one
+// error is sent on the error channel. Cancel ctx or close the client to
+// abandon the stream early.
+func (c *ConsulClient) StreamAgents(ctx context.Context) (<-chan Agent, <-chan error) {
+	agents := make(chan Agent)
+	errs := make(chan error, 1)
+
+	ctx, cancel := c.backgroundContext(ctx)
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		defer cancel()
+		defer close(agents)
+		defer close(errs)
+
+		if err := c.streamAgents(ctx, agents); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+
+	return agents, errs
+}
+
+// streamAgents reads the NDJSON agent stream and sends each agent to out
+func (c *ConsulClient) streamAgents(ctx context.Context, out chan<- Agent) error {
+	req, err := http.NewRequest("GET", c.serverURL+"/agents?stream=ndjson", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	// The stream is bounded by ctx alone rather than the client's timeouts,
+	// which would otherwise cut off large registries mid-stream
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		return extractErrorFromResponse(resp.StatusCode, body)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var agent Agent
+		if err := decoder.Decode(&agent); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to parse agent stream: %w", err)
+		}
+
+		select {
+		case out <- agent:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Agents sent per import request by ImportFrom, keeping each request well
+// within the server's request body limit
+const importBatchSize = 100
+
+// ExportTo writes every agent in the registry to w as newline-delimited JSON,
+// one agent per line ordered by name, as the server streams them. Unlike
+// Export the backup is never held in memory, so it suits large registries;
+// write it to a file or pipe and restore it with ImportFrom. Requires an
+// admin role. The transfer is not subject to the client's timeouts; close
+// the client to abandon it.
+func (c *ConsulClient) ExportTo(w io.Writer) error {
+	ctx, cancel := c.backgroundContext(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequest("GET", c.serverURL+"/export?stream=ndjson", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %w", err)
+		}
+		return extractErrorFromResponse(resp.StatusCode, body)
+	}
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	return nil
+}
+
+// ImportFrom registers the agents of a newline-delimited JSON backup, as
+// written by ExportTo, returning the outcome for each one. The agents are
+// sent in batches, so a failed request leaves the batches before it
+// imported; their results are returned along with the error. Requires an
+// admin role.
+func (c *ConsulClient) ImportFrom(r io.Reader, overwrite bool) ([]BatchResult, error) {
+	results := make([]BatchResult, 0)
+	batch := make([]Agent, 0, importBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		batchResults, err := c.Import(batch, overwrite)
+		if err != nil {
+			return err
+		}
+		results = append(results, batchResults...)
+		batch = batch[:0]
+		return nil
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var agent Agent
+		if err := decoder.Decode(&agent); err == io.EOF {
+			break
+		} else if err != nil {
+			return results, fmt.Errorf("failed to parse agent backup: %w", err)
+		}
+
+		batch = append(batch, agent)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return results, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return results, err
+	}
+	return results, nil
+}
@@ -0,0 +1,52 @@
+package sharewoodapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// methodTimeoutKey carries a per-method default timeout in a request's context
+type methodTimeoutKey struct{}
+
+// methodTimeouts holds the per-method defaults from ClientOptions. A zero
+// value falls back to the client's Timeout.
+type methodTimeouts struct {
+	list     time.Duration
+	register time.Duration
+	bulk     time.Duration
+	ping     time.Duration
+	watch    time.Duration
+}
+
+// withMethodTimeout marks req with the default timeout of the method sending it
+func withMethodTimeout(req *http.Request, timeout time.Duration) *http.Request {
+	if timeout <= 0 {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), methodTimeoutKey{}, timeout))
+}
+
+// requestTimeout returns the timeout that applies to req when its context has
+// no deadline: the method's default if set, otherwise the client's Timeout
+func (c *ConsulClient) requestTimeout(req *http.Request) time.Duration {
+	if timeout, ok := req.Context().Value(methodTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return c.timeout
+}
+
+// applyTimeout bounds req by its timeout unless the caller's context already
+// carries a deadline, which always takes precedence. The returned cancel
+// function must be called once the response has been read.
+func (c *ConsulClient) applyTimeout(req *http.Request) (*http.Request, context.CancelFunc) {
+	if _, ok := req.Context().Deadline(); ok {
+		return req, func() {}
+	}
+	timeout := c.requestTimeout(req)
+	if timeout <= 0 {
+		return req, func() {}
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	return req.WithContext(ctx), cancel
+}
@@ -0,0 +1,87 @@
+package sharewoodapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newSlowServer answers every request after delay, or gives up when the
+// client does
+func newSlowServer(t *testing.T, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/agents":
+			w.Write([]byte(`[]`))
+		case "/version":
+			w.Write([]byte(`{"version":"test"}`))
+		default:
+			w.Write([]byte(`{"agent":{"name":"weather"}}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTimeoutPrecedence(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	const short, long = 50 * time.Millisecond, 5 * time.Second
+	server := newSlowServer(t, delay)
+
+	newClient := func(timeout, listTimeout time.Duration) *ConsulClient {
+		options := DefaultOptions()
+		options.ServerURL = server.URL
+		options.Timeout = timeout
+		options.ListTimeout = listTimeout
+		client := NewClient(options)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	timedOut := func(err error) bool {
+		return errors.Is(err, context.DeadlineExceeded)
+	}
+
+	t.Run("client default", func(t *testing.T) {
+		if _, err := newClient(short, 0).GetAgent("weather"); !timedOut(err) {
+			t.Errorf("with a short Timeout: got %v, want a deadline error", err)
+		}
+		if _, err := newClient(long, 0).GetAgent("weather"); err != nil {
+			t.Errorf("with a long Timeout: %v", err)
+		}
+	})
+
+	t.Run("per-method default over client default", func(t *testing.T) {
+		client := newClient(long, short)
+		if _, err := client.ListAgents(); !timedOut(err) {
+			t.Errorf("with a short ListTimeout: got %v, want a deadline error", err)
+		}
+		if _, err := client.GetAgent("weather"); err != nil {
+			t.Errorf("a get, which ListTimeout does not cover: %v", err)
+		}
+		if _, err := newClient(short, long).ListAgents(); err != nil {
+			t.Errorf("with a long ListTimeout and a short Timeout: %v", err)
+		}
+	})
+
+	t.Run("caller deadline over both", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), long)
+		defer cancel()
+		if err := newClient(short, short).Warmup(ctx); err != nil {
+			t.Errorf("with a long caller deadline and short defaults: %v", err)
+		}
+
+		ctx, cancel = context.WithTimeout(context.Background(), short)
+		defer cancel()
+		if err := newClient(long, long).Warmup(ctx); !timedOut(err) {
+			t.Errorf("with a short caller deadline and long defaults: got %v, want a deadline error", err)
+		}
+	})
+}
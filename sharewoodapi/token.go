@@ -0,0 +1,68 @@
+package sharewoodapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// JWTClaims are the claims carried by the bearer tokens the registry accepts
+type JWTClaims struct {
+	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
+	jwt.StandardClaims
+}
+
+// ParseToken validates a bearer token's signature and expiry against the
+// client's TokenKey and returns its claims, without contacting the server.
+func (c *ConsulClient) ParseToken(token string) (*JWTClaims, error) {
+	if c.tokenKey == nil {
+		return nil, fmt.Errorf("no token key configured; set ClientOptions.TokenKey or use DecodeTokenUnverified")
+	}
+	return ParseTokenWithKey(token, c.tokenKey)
+}
+
+// ParseTokenWithKey validates a token's signature and expiry and returns its
+// claims. key is the HMAC secret as []byte, or an *rsa.PublicKey or
+// *ecdsa.PublicKey; the token's signing method must match the kind of key,
+// so a token cannot pick a weaker algorithm than the key implies.
+func ParseTokenWithKey(token string, key interface{}) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		var ok bool
+		switch key.(type) {
+		case []byte:
+			_, ok = t.Method.(*jwt.SigningMethodHMAC)
+		case *rsa.PublicKey:
+			_, ok = t.Method.(*jwt.SigningMethodRSA)
+		case *ecdsa.PublicKey:
+			_, ok = t.Method.(*jwt.SigningMethodECDSA)
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if !parsed.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// DecodeTokenUnverified returns a token's claims without checking its
+// signature or expiry. Use it only to display a token's contents, never to
+// make access decisions.
+func DecodeTokenUnverified(token string) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	return claims, nil
+}
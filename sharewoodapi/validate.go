@@ -0,0 +1,22 @@
+package sharewoodapi
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ValidateBaseURL checks that raw is an absolute URL with a host. Unless
+// allowAnyScheme is set, the scheme must be http or https.
+func ValidateBaseURL(raw string, allowAnyScheme bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid URL", raw)
+	}
+	if !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("%q must be an absolute URL including scheme and host", raw)
+	}
+	if !allowAnyScheme && u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must use the http or https scheme", raw)
+	}
+	return nil
+}
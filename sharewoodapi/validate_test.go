@@ -0,0 +1,45 @@
+package sharewoodapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateBaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		url            string
+		allowAnyScheme bool
+		valid          bool
+	}{
+		{"http://localhost:8080", false, true},
+		{"https://agents.example.com/weather", false, true},
+		{"/weather", false, false},
+		{"localhost:8080", false, false},
+		{"not a url", false, false},
+		{"http://[::1", false, false},
+		{"grpc://agents.internal:9000", false, false},
+		{"grpc://agents.internal:9000", true, true},
+		{"grpc:///no-host", true, false},
+	} {
+		err := ValidateBaseURL(tc.url, tc.allowAnyScheme)
+		if (err == nil) != tc.valid {
+			t.Errorf("ValidateBaseURL(%q, %v) = %v, want valid %v", tc.url, tc.allowAnyScheme, err, tc.valid)
+		}
+	}
+}
+
+func TestRegisterAgentRejectsInvalidBaseURLBeforeSending(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL)
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	defer client.Close()
+
+	agent := Agent{Name: "weather", Description: "Forecasts", BaseURL: "/weather", HowToUse: "GET /forecast"}
+	if _, err := client.RegisterAgent(agent); err == nil {
+		t.Fatal("a relative base URL was accepted")
+	}
+}
@@ -0,0 +1,50 @@
+package sharewoodapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// Warmup verifies that the registry is reachable and that the client's
+// credentials are accepted, so a long-lived process can fail its startup
+// early instead of on its first lookup. It pings the server's version
+// endpoint, then fetches the full agent list. The client keeps no agent
+// cache, so the list is only checked and discarded; Warmup still exercises
+// the same request path later calls will use. Calling it is optional and
+// idempotent: it changes no state and may be called any number of times.
+// ctx bounds both requests.
+func (c *ConsulClient) Warmup(ctx context.Context) error {
+	req, err := http.NewRequest("GET", c.rootURL+"/version", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return fmt.Errorf("registry is unreachable: %w", err)
+	}
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	req, err = http.NewRequest("GET", c.serverURL+"/agents?envelope=true", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req.WithContext(ctx), c.timeouts.list)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusOK {
+		return extractErrorFromResponse(statusCode, body)
+	}
+
+	_, err = decodeAgentList(body, header)
+	return err
+}
@@ -0,0 +1,127 @@
+package sharewoodapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Delay before retrying a watch request that failed
+	watchRetryMin = 1 * time.Second
+	// Upper bound for the exponential watch retry delay
+	watchRetryMax = 30 * time.Second
+	// Wait used for blocking requests when the client has no timeout
+	watchDefaultWait = 5 * time.Minute
+)
+
+// WatchAgents watches the registry and delivers an event each time the set of
+// agents changes. Pass 0 as fromIndex to receive the current snapshot first, or
+// the Index of a previously delivered event to resume from that point.
+//
+// The watch survives dropped connections: failed requests are reported on the
+// error channel and retried with backoff, resuming from the last index seen so
+// no update is missed and no snapshot is delivered twice. Both channels are
+// closed once ctx is cancelled or the client is closed.
+func (c *ConsulClient) WatchAgents(ctx context.Context, fromIndex uint64) (<-chan AgentEvent, <-chan error) {
+	events := make(chan AgentEvent)
+	errs := make(chan error, 1)
+
+	ctx, cancel := c.backgroundContext(ctx)
+	c.workers.Add(1)
+	go func() {
+		defer c.workers.Done()
+		defer cancel()
+		defer close(events)
+		defer close(errs)
+
+		index := fromIndex
+		retry := watchRetryMin
+		for {
+			agents, newIndex, err := c.watchOnce(ctx, index)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				// Report the failure without blocking the watch loop
+				select {
+				case errs <- err:
+				default:
+				}
+
+				select {
+				case <-time.After(retry):
+				case <-ctx.Done():
+					return
+				}
+				if retry *= 2; retry > watchRetryMax {
+					retry = watchRetryMax
+				}
+				continue
+			}
+			retry = watchRetryMin
+
+			// The wait elapsed without changes; this snapshot was already delivered
+			if newIndex == index {
+				continue
+			}
+
+			// Any other index, including one that went backwards after a Consul
+			// reset, marks a new snapshot to resume from
+			index = newIndex
+
+			select {
+			case events <- AgentEvent{Agents: agents, Index: newIndex}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, errs
+}
+
+// watchOnce performs a single blocking list request starting from index
+func (c *ConsulClient) watchOnce(ctx context.Context, index uint64) ([]Agent, uint64, error) {
+	wait := watchDefaultWait
+	req, err := http.NewRequest("GET", c.serverURL+"/agents", nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req = withMethodTimeout(req.WithContext(ctx), c.timeouts.watch)
+	if _, ok := ctx.Deadline(); !ok {
+		if timeout := c.requestTimeout(req); timeout > 0 {
+			// Leave headroom so the server answers before the client gives up
+			wait = timeout / 2
+		}
+	}
+
+	req.URL.RawQuery = fmt.Sprintf("index=%d&wait=%s&envelope=true", index, wait)
+
+	req.Header.Add("X-API-Key", c.apiKey)
+
+	body, statusCode, header, err := c.doRequestWithHeader(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, 0, extractErrorFromResponse(statusCode, body)
+	}
+
+	newIndex, err := strconv.ParseUint(header.Get(IndexHeader), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("server did not return a valid watch index")
+	}
+
+	agents, err := decodeAgentList(body, header)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.logDebug("Watch index advanced", "from", index, "to", newIndex)
+
+	return agents, newIndex, nil
+}
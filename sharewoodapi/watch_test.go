@@ -0,0 +1,76 @@
+package sharewoodapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchResumesAfterDroppedConnection(t *testing.T) {
+	var mu sync.Mutex
+	var indexes []string
+	dropped := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := r.URL.Query().Get("index")
+		mu.Lock()
+		indexes = append(indexes, index)
+		drop := index == "5" && !dropped
+		dropped = dropped || drop
+		mu.Unlock()
+
+		switch {
+		case index == "0":
+			w.Header().Set(IndexHeader, "5")
+			w.Write([]byte(`[{"name":"a"}]`))
+		case drop:
+			// The connection drops while the blocking request waits
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		default:
+			// An agent was registered while the watch was disconnected
+			w.Header().Set(IndexHeader, "6")
+			w.Write([]byte(`[{"name":"a"},{"name":"b"}]`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestClient(server.URL)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, _ := client.WatchAgents(ctx, 0)
+
+	want := []struct {
+		index  uint64
+		agents int
+	}{{5, 1}, {6, 2}}
+	for _, w := range want {
+		select {
+		case event := <-events:
+			if event.Index != w.index || len(event.Agents) != w.agents {
+				t.Fatalf("got index %d with %d agents, want index %d with %d", event.Index, len(event.Agents), w.index, w.agents)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event for index %d", w.index)
+		}
+	}
+
+	// After the drop the watch resumed from the last index instead of
+	// starting over with a fresh snapshot
+	mu.Lock()
+	defer mu.Unlock()
+	if !dropped {
+		t.Fatal("the connection was never dropped")
+	}
+	for _, index := range indexes[1:] {
+		if index == "0" {
+			t.Fatalf("watch restarted from index 0 after the drop: %v", indexes)
+		}
+	}
+}
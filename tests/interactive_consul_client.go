@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,39 +12,49 @@ import (
 	"strconv"
 	"strings"
 	"time"
-)
 
-const (
-	serverURL = "http://localhost:3000/api/v1"
-	apiKey    = "test-api-key"
-	debugMode = false // Set to true to show debug information
+	"github.com/rdhillbb/sharewood/sharewoodapi"
 )
 
-// Agent represents an AI agent in the registry
-// Changed the JSON tag from "version" to "release" to match the server's metadata key.
-type Agent struct {
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Release     string    `json:"release,omitempty"`
-	BaseURL     string    `json:"baseurl"`
-	OpenAPI     string    `json:"openapi,omitempty"`
-	HowToUse    string    `json:"howtouse"`
-	Expiration  time.Time `json:"expiration"`
-	TTL         int64     `json:"ttl,omitempty"`
-	Tags        []string  `json:"tags,omitempty"`
-}
+// Connection settings. Each can be set with a flag or an environment variable;
+// flags take precedence, and the defaults target a local development server.
+var (
+	serverURL = envOrDefault("SHAREWOOD_SERVER_URL", "http://localhost:3000/api/v1")
+	apiKey    = envOrDefault("SHAREWOOD_API_KEY", "test-api-key")
+	debugMode = envOrDefault("SHAREWOOD_DEBUG", "false") == "true" // Show debug information
+)
 
-// ErrorResponse represents the standard error response from the server
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Details string `json:"details"`
+// envOrDefault returns the value of the environment variable key, or def when unset
+func envOrDefault(key, def string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return def
 }
 
 func main() {
+	flag.StringVar(&serverURL, "server", serverURL, "registry API URL including the base path (env SHAREWOOD_SERVER_URL)")
+	flag.StringVar(&apiKey, "api-key", apiKey, "API key used to authenticate (env SHAREWOOD_API_KEY)")
+	flag.BoolVar(&debugMode, "debug", debugMode, "show debug information (env SHAREWOOD_DEBUG)")
+	flag.Parse()
+	serverURL = strings.TrimSuffix(serverURL, "/")
+
 	reader := bufio.NewReader(os.Stdin)
 
+	// Confirm the credentials up front and show the role they grant
+	signedInAs := "unknown (credentials could not be checked)"
+	if identity, err := getWhoAmI(); err != nil {
+		displayError("Failed to check credentials", err)
+	} else {
+		signedInAs = string(identity.Role)
+		if identity.UserID != "" {
+			signedInAs = fmt.Sprintf("%s (%s)", identity.UserID, identity.Role)
+		}
+	}
+
 	for {
 		fmt.Println("\n=== Consul AI Agent Registry Client ===")
+		fmt.Printf("Signed in as: %s\n", signedInAs)
 		fmt.Println("1. List all agents")
 		fmt.Println("2. View agent details")
 		fmt.Println("3. Create Geography agent")
@@ -258,6 +269,43 @@ func ZgetAllAgents() ([]map[string]interface{}, error) {
 	return agentMaps, nil
 }
 
+// getWhoAmI asks the registry who the configured credentials authenticate as
+func getWhoAmI() (sharewoodapi.Identity, error) {
+	req, err := http.NewRequest("GET", serverURL+"/whoami", nil)
+	if err != nil {
+		return sharewoodapi.Identity{}, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Add("X-API-Key", apiKey)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return sharewoodapi.Identity{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return sharewoodapi.Identity{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if debugMode {
+		fmt.Println("DEBUG - Server response:", string(body))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return sharewoodapi.Identity{}, extractErrorFromResponse(resp.StatusCode, body)
+	}
+
+	var identity sharewoodapi.Identity
+	if err := json.Unmarshal(body, &identity); err != nil {
+		return sharewoodapi.Identity{}, fmt.Errorf("failed to parse JSON response: %w", err)
+	}
+
+	return identity, nil
+}
+
 func getAgent(name string) (map[string]interface{}, error) {
 	req, err := http.NewRequest("GET", serverURL+"/agents/"+name, nil)
 	if err != nil {
@@ -377,6 +425,21 @@ func displayAgentDetails(agent map[string]interface{}) {
 		fmt.Printf("How To Use: %v\n", agent["howtouse"])
 	}
 
+	fmt.Println("\nSupport:")
+	if agent["contact"] != nil {
+		fmt.Printf("Contact: %v\n", agent["contact"])
+	} else {
+		fmt.Println("Contact: <not specified>")
+	}
+	if agent["docsUrl"] != nil {
+		fmt.Printf("Docs URL: %v\n", agent["docsUrl"])
+	} else {
+		fmt.Println("Docs URL: <not specified>")
+	}
+	if agent["iconUrl"] != nil {
+		fmt.Printf("Icon URL: %v\n", agent["iconUrl"])
+	}
+
 	fmt.Println("\nOperational Details:")
 	if agent["expiration"] != nil {
 		fmt.Printf("Expiration: %v\n", agent["expiration"])
@@ -408,7 +471,7 @@ func formatArray(value interface{}) string {
 }
 
 func createGeographyAgent() error {
-	agent := Agent{
+	agent := sharewoodapi.Agent{
 		Name:        "Geography",
 		Description: "Provide information on historical places, countries, cities, or places of interest",
 		Release:     "1.0.0",
@@ -424,7 +487,7 @@ func createGeographyAgent() error {
 }
 
 func createCustomAgent(reader *bufio.Reader) error {
-	agent := Agent{}
+	agent := sharewoodapi.Agent{}
 
 	fmt.Println("\n=== Create Custom Agent ===")
 
@@ -458,6 +521,15 @@ func createCustomAgent(reader *bufio.Reader) error {
 	fmt.Print("OpenAPI URL (optional): ")
 	agent.OpenAPI = readString(reader)
 
+	fmt.Print("Contact, e.g. email or chat channel (optional): ")
+	agent.Contact = readString(reader)
+
+	fmt.Print("Docs URL (optional): ")
+	agent.DocsURL = readString(reader)
+
+	fmt.Print("Icon URL (optional): ")
+	agent.IconURL = readString(reader)
+
 	fmt.Print("Tags (comma-separated): ")
 	tags := readString(reader)
 	if tags != "" {
@@ -477,13 +549,12 @@ func createCustomAgent(reader *bufio.Reader) error {
 		agent.TTL = seconds
 	}
 
-	agent.Expiration = time.Now().AddDate(1, 0, 0)
-
+	// The server gives agents without an expiration its default one
 	fmt.Println("Attempting to register custom agent...")
 	return registerAgent(agent)
 }
 
-func registerAgent(agent Agent) error {
+func registerAgent(agent sharewoodapi.Agent) error {
 	jsonData, err := json.Marshal(agent)
 	if err != nil {
 		return fmt.Errorf("failed to marshal agent to JSON: %w", err)
@@ -567,7 +638,7 @@ func deleteAgent(name string) error {
 
 func extractErrorFromResponse(statusCode int, body []byte) error {
 	// Try to parse as JSON error response
-	var errorResp ErrorResponse
+	var errorResp sharewoodapi.ErrorResponse
 	if err := json.Unmarshal(body, &errorResp); err == nil && (errorResp.Error != "" || errorResp.Details != "") {
 		if errorResp.Details != "" {
 			return fmt.Errorf("%s: %s (Status: %d)", errorResp.Error, errorResp.Details, statusCode)
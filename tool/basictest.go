@@ -1,31 +1,167 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"sort"
 	"strings"
 	"time"
        shwood "github.com/rdhillbb/sharewood/sharewoodapi"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s [flags] <command> [args]
+
+Commands:
+  list                          List all agents
+  get <name>                    Show details for an agent
+  register -f <agent.json>      Register an agent from a JSON file
+  deregister <name>             Remove an agent from the registry
+  health <name> <status>        Report agent health (passing, warning, critical)
+  demo                          Run the scripted list/deregister/register walkthrough
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
 func main() {
-	// Initialize client with default options
+	// Start from the default options and let flags override them
 	options := shwood.DefaultOptions()
-	// Disable debug mode for cleaner output
-	options.Debug = false
-	
+	flag.StringVar(&options.ServerURL, "server", options.ServerURL, "registry server URL")
+	flag.StringVar(&options.BasePath, "base-path", options.BasePath, "path prefix of the registry API")
+	flag.StringVar(&options.APIKey, "api-key", options.APIKey, "API key used to authenticate")
+	flag.BoolVar(&options.Debug, "debug", false, "log requests and responses")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+
 	client := shwood.NewClient(options)
 
-	// Step 1: List all agents
-	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
-	fmt.Println("║                   LISTING ALL AGENTS                     ║")
-	fmt.Println("╚══════════════════════════════════════════════════════════╝")
-	
+	var err error
+	switch args[0] {
+	case "list":
+		err = runList(client)
+	case "get":
+		err = runGet(client, args[1:])
+	case "register":
+		err = runRegister(client, args[1:])
+	case "deregister":
+		err = runDeregister(client, args[1:])
+	case "health":
+		err = runHealth(client, args[1:])
+	case "demo":
+		runDemo(client)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s failed: %v", args[0], err)
+	}
+}
+
+func runList(client *shwood.ConsulClient) error {
 	agents, err := client.ListAgents()
 	if err != nil {
-		log.Fatalf("Failed to list agents: %v", err)
+		return err
 	}
-	
+
+	printAgentTable(agents)
+	return nil
+}
+
+func runGet(client *shwood.ConsulClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: get <name>")
+	}
+
+	agent, err := client.GetAgent(args[0])
+	var apiErr *shwood.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("no agent named '%s' is registered", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	printAgentDetails(agent)
+	return nil
+}
+
+func runRegister(client *shwood.ConsulClient, args []string) error {
+	fs := flag.NewFlagSet("register", flag.ExitOnError)
+	file := fs.String("f", "", "path to the agent JSON file")
+	fs.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("usage: register -f <agent.json>")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return fmt.Errorf("failed to open agent file: %w", err)
+	}
+	defer f.Close()
+
+	agent, warnings, err := client.RegisterAgentFromWithWarnings(f)
+	var apiErr *shwood.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict && len(apiErr.Similar) > 0 {
+		return fmt.Errorf("%w\nsimilar agent names already registered: %s", err, formatTags(apiErr.Similar))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Agent '%s' registered successfully!\n", agent.Name)
+	for _, warning := range warnings {
+		fmt.Printf("⚠️  %s\n", warning)
+	}
+	return nil
+}
+
+func runDeregister(client *shwood.ConsulClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: deregister <name>")
+	}
+
+	err := client.DeregisterAgent(args[0])
+	var apiErr *shwood.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("no agent named '%s' is registered", args[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Agent '%s' deregistered successfully!\n", args[0])
+	return nil
+}
+
+func runHealth(client *shwood.ConsulClient, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: health <name> <passing|warning|critical>")
+	}
+
+	if err := client.UpdateAgentHealth(args[0], shwood.HealthStatus(args[1])); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Agent '%s' health set to %s\n", args[0], args[1])
+	return nil
+}
+
+func printAgentTable(agents []shwood.Agent) {
 	fmt.Printf("Found %d agents\n", len(agents))
 	fmt.Println("┌────┬────────────────────┬──────────────────────────────────────────┐")
 	fmt.Println("│ #  │ Name               │ Description                              │")
@@ -38,6 +174,83 @@ func main() {
 	}
 	
 	fmt.Println("└────┴────────────────────┴──────────────────────────────────────────┘")
+}
+
+func printAgentDetails(agentDetails *shwood.Agent) {
+	fmt.Println("┌──────────────────────────────────────────────────────────────┐")
+	fmt.Printf("│ Name:        %-48s │\n", agentDetails.Name)
+	fmt.Printf("│ Description: %-48s │\n", truncateString(agentDetails.Description, 48))
+	fmt.Printf("│ Base URL:    %-48s │\n", truncateString(agentDetails.BaseURL, 48))
+	
+	if agentDetails.Release != "" {
+		fmt.Printf("│ Release:     %-48s │\n", agentDetails.Release)
+	}
+	
+	if agentDetails.OpenAPI != "" {
+		fmt.Printf("│ OpenAPI:     %-48s │\n", truncateString(agentDetails.OpenAPI, 48))
+	}
+	
+	if agentDetails.HowToUse != "" {
+		fmt.Printf("│ How To Use:  %-48s │\n", truncateString(agentDetails.HowToUse, 48))
+	}
+	
+	if !agentDetails.Expiration.IsZero() {
+		fmt.Printf("│ Expires:     %-48s │\n", agentDetails.Expiration.Format("2006-01-02 15:04:05"))
+	}
+	
+	if len(agentDetails.Tags) > 0 {
+		fmt.Printf("│ Tags:        %-48s │\n", truncateString(formatTags(agentDetails.Tags), 48))
+	}
+	
+	if agentDetails.Contact != "" {
+		fmt.Printf("│ Contact:     %-48s │\n", truncateString(agentDetails.Contact, 48))
+	}
+	
+	if agentDetails.DocsURL != "" {
+		fmt.Printf("│ Docs:        %-48s │\n", truncateString(agentDetails.DocsURL, 48))
+	}
+	
+	if agentDetails.IconURL != "" {
+		fmt.Printf("│ Icon:        %-48s │\n", truncateString(agentDetails.IconURL, 48))
+	}
+	
+	if len(agentDetails.Metadata) > 0 {
+		keys := make([]string, 0, len(agentDetails.Metadata))
+		for key := range agentDetails.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := key + "=" + agentDetails.Metadata[key]
+			fmt.Printf("│ Metadata:    %-48s │\n", truncateString(entry, 48))
+		}
+	}
+	
+	if agentDetails.CreatedAt != nil {
+		fmt.Printf("│ Created:     %-48s │\n", agentDetails.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+	
+	if agentDetails.UpdatedAt != nil {
+		fmt.Printf("│ Updated:     %-48s │\n", agentDetails.UpdatedAt.Format("2006-01-02 15:04:05"))
+	}
+	
+	fmt.Println("└──────────────────────────────────────────────────────────────┘")
+}
+
+// runDemo runs the scripted walkthrough: list, inspect and deregister every
+// agent, then register a fresh Geography agent
+func runDemo(client *shwood.ConsulClient) {
+	// Step 1: List all agents
+	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
+	fmt.Println("║                   LISTING ALL AGENTS                     ║")
+	fmt.Println("╚══════════════════════════════════════════════════════════╝")
+	
+	agents, err := client.ListAgents()
+	if err != nil {
+		log.Fatalf("Failed to list agents: %v", err)
+	}
+	
+	printAgentTable(agents)
 
 	// Step 2: Get detailed information for each agent
 	fmt.Println("\n╔══════════════════════════════════════════════════════════╗")
@@ -46,40 +259,20 @@ func main() {
 	
 	for i, agent := range agents {
 		fmt.Printf("\n[Agent %d/%d] %s\n", i+1, len(agents), agent.Name)
-		fmt.Println("┌──────────────────────────────────────────────────────────────┐")
 		
-		agentDetails, err := client.GetAgent(agent.Name)
+		agentDetails, exists, err := client.GetAgentIfExists(agent.Name)
 		if err != nil {
+			fmt.Println("┌──────────────────────────────────────────────────────────────┐")
 			fmt.Printf("│ ERROR: Failed to get agent details: %v\n", err)
 			fmt.Println("└──────────────────────────────────────────────────────────────┘")
 			continue
 		}
-		
-		fmt.Printf("│ Name:        %-48s │\n", agentDetails.Name)
-		fmt.Printf("│ Description: %-48s │\n", truncateString(agentDetails.Description, 48))
-		fmt.Printf("│ Base URL:    %-48s │\n", truncateString(agentDetails.BaseURL, 48))
-		
-		if agentDetails.Release != "" {
-			fmt.Printf("│ Release:     %-48s │\n", agentDetails.Release)
-		}
-		
-		if agentDetails.OpenAPI != "" {
-			fmt.Printf("│ OpenAPI:     %-48s │\n", truncateString(agentDetails.OpenAPI, 48))
-		}
-		
-		if agentDetails.HowToUse != "" {
-			fmt.Printf("│ How To Use:  %-48s │\n", truncateString(agentDetails.HowToUse, 48))
-		}
-		
-		if !agentDetails.Expiration.IsZero() {
-			fmt.Printf("│ Expires:     %-48s │\n", agentDetails.Expiration.Format("2006-01-02 15:04:05"))
-		}
-		
-		if len(agentDetails.Tags) > 0 {
-			fmt.Printf("│ Tags:        %-48s │\n", truncateString(formatTags(agentDetails.Tags), 48))
+		if !exists {
+			fmt.Println("  Agent was deregistered after the list was read, skipping")
+			continue
 		}
 		
-		fmt.Println("└──────────────────────────────────────────────────────────────┘")
+		printAgentDetails(agentDetails)
 	}
 	
 	// Step 3: Deregister all agents
@@ -93,11 +286,13 @@ func main() {
 	
 	for _, agent := range agents {
 		name := padOrTruncate(agent.Name, 20)
-		err := client.DeregisterAgent(agent.Name)
+		removed, err := client.DeregisterAgentIfExists(agent.Name)
 		if err != nil {
 			fmt.Printf("│ %-20s │ ❌ Failed: %-26s │\n", name, truncateString(err.Error(), 26))
-		} else {
+		} else if removed {
 			fmt.Printf("│ %-20s │ ✅ Successfully deregistered            │\n", name)
+		} else {
+			fmt.Printf("│ %-20s │ ✅ Already removed                      │\n", name)
 		}
 	}
 	
@@ -154,6 +349,11 @@ func main() {
 		fmt.Println("✅ Agent registered successfully!")
 		fmt.Printf("   Name: %s\n", registeredAgent.Name)
 		fmt.Printf("   Expiration: %s\n", registeredAgent.Expiration.Format("2006-01-02 15:04:05"))
+		fmt.Printf("   Tags: %s\n", formatTags(registeredAgent.Tags))
+		// The registry reports tags sorted, whatever order they were sent in
+		if !sort.StringsAreSorted(registeredAgent.Tags) {
+			fmt.Println("⚠️  Tags were not returned in sorted order")
+		}
 	}
 	
 	fmt.Println("\n✨ All operations completed!")